@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"github.com/zxc7563598/go-lab/shell/scheduler"
+)
+
+// runDaemonCommand 命令行子命令 daemon：常驻运行，通过控制 socket 接收 shellctl 提交的流水线
+//
+//	shell daemon -socket /run/user/1000/shelld.sock -workers 4
+func runDaemonCommand(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	socket := fs.String("socket", scheduler.DefaultDaemonSocket(), "控制 socket 路径")
+	socketMode := fs.String("socket-mode", "0600", "控制 socket 的权限，例如 0660 允许同组用户使用 shellctl")
+	workers := fs.Int("workers", runtime.NumCPU(), "每次运行的最大并发数")
+	keep := fs.Int("keep", 20, "最多保留多少次运行供 shellctl 查询")
+	historyFile := fs.String("history", "", "任务历史耗时记录文件，用于估算进度和剩余时间，每次运行结束后更新，为空则不启用")
+	logFormat := fs.String("log-format", "text", "日志格式: text 或 json")
+	logLevel := fs.String("log-level", "info", "日志级别: debug, info, warn, error")
+	fs.Parse(args)
+
+	handler, err := scheduler.NewLogHandler(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	slog.SetDefault(slog.New(handler))
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || mode&^0o777 != 0 {
+		fmt.Fprintf(os.Stderr, "无效的 --socket-mode %q\n", *socketMode)
+		return 2
+	}
+
+	// 任务结果通过 shellctl 查看，不再打印到守护进程的标准输出
+	opts := []scheduler.Option{
+		scheduler.WithMaxWorkers(*workers),
+		scheduler.WithLogger(slog.Default()),
+		scheduler.WithOutput(io.Discard),
+	}
+	if *historyFile != "" {
+		history, err := scheduler.LoadHistory(*historyFile)
+		if err != nil {
+			slog.Error("读取历史耗时失败", "error", err)
+			return 1
+		}
+		opts = append(opts, scheduler.WithHistory(history))
+	}
+	daemon := scheduler.NewDaemon(opts...)
+	daemon.Keep = *keep
+	daemon.SocketMode = os.FileMode(mode)
+	server, err := daemon.Serve(*socket)
+	if err != nil {
+		slog.Error("启动守护进程失败", "error", err)
+		return 1
+	}
+	slog.Info("守护进程启动", "socket", server.Path(), "pid", os.Getpid())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	slog.Info("守护进程退出，取消所有运行")
+	server.Close()
+	daemon.Close()
+	return 0
+}
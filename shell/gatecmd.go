@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zxc7563598/go-lab/shell/scheduler"
+)
+
+// runGateCommand 命令行子命令 approve / reject，通过控制 socket 向运行中的调度器发送审批决定
+// 审批人由调度器根据 socket 对端的凭据确定，即执行本命令的用户
+//
+//	shell approve -lock shell -comment "可以发布" deploy-gate
+func runGateCommand(action string, args []string) int {
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	socket := fs.String("socket", "", "调度器的控制 socket，为空时从 -lock-dir 下的锁文件中查找")
+	lockName := fs.String("lock", "shell", "运行中的流水线锁的名称（即运行时的 --lock）")
+	lockDir := fs.String("lock-dir", os.TempDir(), "锁文件所在目录（即运行时的 --lock-dir）")
+	comment := fs.String("comment", "", "审批意见")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "用法: %s [flags] <任务ID>\n", action)
+		fs.PrintDefaults()
+		return 2
+	}
+
+	path := *socket
+	if path == "" {
+		var err error
+		if path, err = scheduler.LockSocket(*lockDir, *lockName); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	req := scheduler.ControlRequest{Command: action, Task: fs.Arg(0), Comment: *comment}
+	if err := (scheduler.ControlClient{Path: path}).Call(req, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "%s 失败: %v\n", action, err)
+		return 1
+	}
+	if action == "approve" {
+		fmt.Printf("已通过任务 %s\n", fs.Arg(0))
+	} else {
+		fmt.Printf("已拒绝任务 %s\n", fs.Arg(0))
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// switchWriter 可以在运行中切换目标的 writer
+// 终端界面接管屏幕时，日志改为写入界面中的日志区域
+type switchWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newSwitchWriter(w io.Writer) *switchWriter {
+	return &switchWriter{w: w}
+}
+
+func (sw *switchWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.Write(p)
+}
+
+// Set 切换输出目标
+func (sw *switchWriter) Set(w io.Writer) {
+	sw.mu.Lock()
+	sw.w = w
+	sw.mu.Unlock()
+}
@@ -1,512 +1,227 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
-	"github.com/fatih/color"
+	"github.com/zxc7563598/go-lab/shell/scheduler"
 )
 
-// TaskStatus 任务状态
-type TaskStatus int
+// reportFlag 实现 flag.Value，使 --report 可以重复指定
+// 格式为 format 或 format=path，例如 --report junit=out/junit.xml
+type reportFlag []scheduler.ReportTarget
 
-const (
-	StatusPending   TaskStatus = iota // 0
-	StatusRunning                     // 1
-	StatusSuccess                     // 2
-	StatusFailed                      // 3
-	StatusTimeout                     // 4
-	StatusCancelled                   // 5
-)
-
-func (s TaskStatus) String() string {
-	switch s {
-	case StatusPending:
-		return "待处理"
-	case StatusRunning:
-		return "运行中"
-	case StatusSuccess:
-		return "成功"
-	case StatusFailed:
-		return "失败"
-	case StatusTimeout:
-		return "超时"
-	case StatusCancelled:
-		return "取消"
-	default:
-		return "未知"
-	}
-}
-
-// Task 任务定义
-type Task struct {
-	ID           string        // 任务ID
-	Name         string        // 任务名称
-	Cmd          string        // 执行命令
-	Args         []string      // 命令参数
-	Timeout      time.Duration // 超时时间
-	RetryCount   int           // 重试次数
-	RetryDelay   time.Duration // 重试延迟
-	MaxOutput    int           // 最大输出行数
-	Env          []string      // 环境变量
-	WorkDir      string        // 工作目录
-	Dependencies []string      // 依赖的任务ID
-}
-
-// TaskResult 任务执行结果
-type TaskResult struct {
-	TaskID     string        // 任务ID
-	TaskName   string        // 任务名称
-	Status     TaskStatus    // 任务状态
-	StartTime  time.Time     // 开始时间
-	EndTime    time.Time     // 结束时间
-	Duration   time.Duration // 持续时间
-	ExitCode   int           // 退出code
-	Output     string        // 输出内容
-	Error      error         // 错误信息
-	RetryCount int           // 重试次数
-}
-
-// Scheduler 调度器
-type Scheduler struct {
-	maxWorkers      int                    // 最大并发数
-	tasks           map[string]*Task       // 所有任务
-	taskResults     map[string]*TaskResult // 所有任务结果
-	taskQueue       chan *Task             // 任务队列
-	taskResultQueue chan *TaskResult       // 结果队列
-	wg              sync.WaitGroup         // 等待组
-	mu              sync.Mutex             // 读写锁
-	ctx             context.Context        // 上下文
-	cancel          context.CancelFunc     // 取消函数
-	isRunning       bool                   // 是否正在运行
-	completedTasks  map[string]bool        // 已完成任务
-}
-
-// NewScheduler 创建调度器
-func NewScheduler(maxWorkers int) *Scheduler {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &Scheduler{
-		maxWorkers:      maxWorkers,
-		tasks:           make(map[string]*Task),
-		taskResults:     make(map[string]*TaskResult),
-		taskQueue:       make(chan *Task, 100),
-		taskResultQueue: make(chan *TaskResult, 100),
-		ctx:             ctx,
-		cancel:          cancel,
-		completedTasks:  make(map[string]bool),
+func (f *reportFlag) String() string {
+	parts := make([]string, 0, len(*f))
+	for _, t := range *f {
+		if t.Path == "" {
+			parts = append(parts, t.Format)
+		} else {
+			parts = append(parts, t.Format+"="+t.Path)
+		}
 	}
+	return strings.Join(parts, ",")
 }
 
-// AddTask 添加任务
-func (s *Scheduler) AddTask(task *Task) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if task.ID == "" {
-		task.ID = fmt.Sprintf("task-%d", len(s.tasks)+1)
-	}
-	if task.Name == "" {
-		task.Name = task.ID
-	}
-	if task.Timeout == 0 {
-		task.Timeout = 5 * time.Minute
-	}
-	if task.MaxOutput == 0 {
-		task.MaxOutput = 5000
+func (f *reportFlag) Set(value string) error {
+	target, err := scheduler.ParseReportTarget(value)
+	if err != nil {
+		return err
 	}
-	s.tasks[task.ID] = task
+	*f = append(*f, target)
 	return nil
 }
 
-// checkDependencies 检查任务依赖
-func (s *Scheduler) checkDependencies() error {
-	// 根据 Dependencies 去检查需要的任务是否存在在队列中
-	for _, task := range s.tasks {
-		for _, depID := range task.Dependencies {
-			if _, exists := s.tasks[depID]; !exists {
-				return fmt.Errorf("任务 %s 依赖的任务 %s 不存在", task.ID, depID)
-			}
-		}
+// finish 停止调度器并输出汇总与报告
+func finish(s *scheduler.Scheduler, reports reportFlag) {
+	s.Stop()
+	s.PrintSummary(os.Stdout)
+	if err := s.SaveHistory(); err != nil {
+		slog.Error("保存历史耗时失败", "error", err)
+	}
+	if err := s.CloseWorkspace(); err != nil {
+		slog.Error("清理工作区失败", "error", err)
 	}
-	return nil
-}
 
-// taskDispatcher 任务分发器
-func (s *Scheduler) taskDispatcher() {
-	// 检查依赖
-	if err := s.checkDependencies(); err != nil {
-		log.Printf("检查依赖失败, %v", err)
+	if len(reports) == 0 {
 		return
 	}
-	// 没有依赖的任务加入队列
-	// 有依赖的任务会在没有依赖的任务完成后执行
-	s.mu.Lock()
-	for _, task := range s.tasks {
-		if len(task.Dependencies) == 0 {
-			s.taskQueue <- task
+	report := s.BuildReport(s.Name())
+	for _, target := range reports {
+		if err := scheduler.WriteReport(report, target); err != nil {
+			slog.Error("生成报告失败", "format", target.Format, "error", err)
 		}
 	}
-	s.mu.Unlock()
 }
 
-// copyAndLog 复制并输出记录
-func (s *Scheduler) copyAndLog(dst io.Writer, src io.Reader, prefix, taskName string) {
-	scanner := bufio.NewScanner(src)
-	for scanner.Scan() {
-		line := scanner.Text()
-		io.WriteString(dst, line+"\n")
-		// 实时日志
-		log.Printf("[%s] %s: %s", taskName, prefix, line)
-	}
+// fatal 记录错误并退出
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
 
-// runCommand 执行shell命令
-func (s *Scheduler) runCommand(task *Task, output io.Writer) (int, error) {
-	ctx, cancel := context.WithTimeout(s.ctx, task.Timeout)
-	defer cancel()
-
-	// 创建命令
-	var cmd *exec.Cmd
-	if len(task.Args) > 0 {
-		cmd = exec.CommandContext(ctx, task.Cmd, task.Args...)
-	} else {
-		cmd = exec.CommandContext(ctx, "sh", "-c", task.Cmd)
-	}
-
-	// 设置工作目录
-	if task.WorkDir != "" {
-		cmd.Dir = task.WorkDir
-	}
-
-	// 设置环境变量
-	if len(task.Env) > 0 {
-		cmd.Env = append(os.Environ(), cmd.Env...)
-	}
-
-	// 设置输出
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return -1, err
-	}
-	stderrPipe, err := cmd.StderrPipe()
+func main() {
+	// 子命令：向运行中的调度器发送审批决定
+	if len(os.Args) > 1 && (os.Args[1] == "approve" || os.Args[1] == "reject") {
+		os.Exit(runGateCommand(os.Args[1], os.Args[2:]))
+	}
+	// 子命令：常驻运行，接收 shellctl 提交的流水线
+	if len(os.Args) > 1 && os.Args[1] == "daemon" {
+		os.Exit(runDaemonCommand(os.Args[2:]))
+	}
+
+	// 命令行参数
+	var reports reportFlag
+	flag.Var(&reports, "report", "输出运行报告，格式为 format 或 format=path，可重复指定 (json, junit, markdown, html)")
+	runName := flag.String("name", "shell", "运行的名称，出现在汇总、报告和通知中")
+	listen := flag.String("listen", "", "HTTP 监听地址，用于暴露 /metrics、状态 API /status、等待审批的节点 /gates 和冻结状态 /freeze，例如 :9090，为空则不启动")
+	notifyOn := flag.String("notify-on", "run_failed,task_failed,run_recovered", "触发通知的事件，逗号分隔")
+	notifyWebhook := flag.String("notify-webhook", "", "通知 webhook 地址")
+	notifyWebhookBody := flag.String("notify-webhook-body", "", "webhook 请求体模板 (text/template，渲染结果需为 JSON)")
+	notifyCommand := flag.String("notify-command", "", "通知命令，通知内容以 JSON 写入其标准输入")
+	notifySMTP := flag.String("notify-smtp", "", "SMTP 服务器地址，例如 smtp.example.com:587")
+	notifySMTPUser := flag.String("notify-smtp-user", "", "SMTP 用户名")
+	notifyEmailFrom := flag.String("notify-email-from", "", "通知邮件发件人")
+	notifyEmailTo := flag.String("notify-email-to", "", "通知邮件收件人，逗号分隔")
+	notifyState := flag.String("notify-state", "", "记录上一次运行结果的文件，用于 run_recovered 通知")
+	cacheDir := flag.String("cache-dir", ".shell-cache", "任务输入指纹缓存目录，为空则不启用缓存")
+	tui := flag.Bool("tui", false, "使用全屏终端界面显示任务状态，标准输出不是终端时退回普通输出")
+	runTimeout := flag.Duration("run-timeout", 0, "整个运行的时长限制，例如 30m，为 0 时不限制")
+	watch := flag.Bool("watch", false, "监听模式：所有任务结束后不退出，任务的 Inputs 变化时重新执行它们及依赖它们的任务")
+	watchDebounce := flag.Duration("watch-debounce", 300*time.Millisecond, "监听模式下最后一次变化之后等待多久再重新执行")
+	watchPoll := flag.Duration("watch-poll", time.Second, "不支持 inotify 时轮询输入的间隔")
+	gitRepo := flag.String("git-repo", "", "源码仓库地址或本地路径，设置后在所有任务之前检出源码，并导出 GIT_COMMIT、GIT_BRANCH")
+	gitRef := flag.String("git-ref", "", "检出的分支或标签，默认为仓库的默认分支")
+	gitCommit := flag.String("git-commit", "", "检出的提交，优先于 --git-ref")
+	gitDir := flag.String("git-dir", "src", "检出目录，相对路径基于 $WORKSPACE")
+	gitCache := flag.String("git-cache", filepath.Join(".shell-cache", "git"), "仓库镜像的缓存目录，多次运行之间复用")
+	workspaceRoot := flag.String("workspace-root", ".shell-runs", "运行目录的根目录，每次运行在其中创建 <run_id>/workspace 和 <run_id>/artifacts，为空则不启用")
+	keepWorkspace := flag.String("keep-workspace", string(scheduler.KeepOnFailure), "运行结束后是否保留临时工作区: on-failure、always 或 never")
+	historyFile := flag.String("history", ".shell-history.json", "任务历史耗时记录文件，用于 SLA 检查和估算进度、剩余时间，为空则不启用")
+	progress := flag.Duration("progress", 10*time.Second, "每隔多久输出一行进度和预计剩余时间，为 0 时不输出")
+	lockName := flag.String("lock", "shell", "流水线锁的名称，同名流水线同一时间只能运行一个，为空则不加锁")
+	lockDir := flag.String("lock-dir", os.TempDir(), "锁文件和控制 socket 所在目录")
+	onConflict := flag.String("on-conflict", string(scheduler.LockFail), "流水线已经在运行时的处理方式: fail、wait 或 cancel（通过控制 socket 取消对方）")
+	lockTimeout := flag.Duration("lock-timeout", 10*time.Minute, "wait/cancel 策略下等待锁的最长时间")
+	secretsFile := flag.String("secrets-file", "", "秘密文件，每行一个 NAME=VALUE，多个文件用逗号分隔")
+	secretEnv := flag.String("secret-env", "", "从环境变量读取的秘密名称，逗号分隔，读取后不再被任务继承")
+	simulate := flag.String("simulate", "", "模拟模式：按 JSON 脚本中的耗时和结果在虚拟时间中运行任务，不执行任何命令，用于检查调度顺序")
+	logFormat := flag.String("log-format", "text", "日志格式: text 或 json")
+	logLevel := flag.String("log-level", "info", "日志级别: debug, info, warn, error；debug 级别会输出任务的每一行输出")
+	flag.Parse()
+
+	// 日志：所有日志（包括标准库 log）都经过同一个 handler，写出前遮盖秘密
+	secrets := scheduler.NewSecretStore()
+	logOut := newSwitchWriter(os.Stderr)
+	handler, err := scheduler.NewLogHandler(secrets.Writer(logOut), *logFormat, *logLevel)
 	if err != nil {
-		return -1, err
-	}
-
-	// 启动命令
-	if err := cmd.Start(); err != nil {
-		return -1, err
-	}
-
-	// 并发读取 stdout 和 stderr
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		s.copyAndLog(output, stdoutPipe, "STDOUT", task.Name)
-	}()
-	go func() {
-		defer wg.Done()
-		s.copyAndLog(output, stderrPipe, "STDERR", task.Name)
-	}()
-	wg.Wait()
-
-	// 等待命令完成
-	err = cmd.Wait()
-	exitCode := cmd.ProcessState.ExitCode()
-	if ctx.Err() == context.DeadlineExceeded {
-		return exitCode, fmt.Errorf("任务执行超时(限时: %v)", task.Timeout)
-	}
-	return exitCode, err
-}
-
-// trimOutput 限制输出大小
-func (s *Scheduler) trimOutput(output string, maxLines int) string {
-	lines := bytes.Split([]byte(output), []byte("\n"))
-	if len(lines) <= maxLines {
-		return output
-	}
-
-	// 保留开头和结尾
-	keep := maxLines / 2
-	firstPart := lines[:keep]
-	lastPart := lines[len(lines)-keep:]
-
-	var result []byte
-	result = append(result, bytes.Join(firstPart, []byte("\n"))...)
-	result = append(result, []byte("\n... (忽略中间内容) ...\n")...)
-	result = append(result, bytes.Join(lastPart, []byte("\n"))...)
-
-	return string(result)
-}
-
-// executeTask 执行单个任务
-func (s *Scheduler) executeTask(workerID int, task *Task) *TaskResult {
-	result := &TaskResult{
-		TaskID:     task.ID,
-		TaskName:   task.Name,
-		Status:     StatusRunning,
-		StartTime:  time.Now(),
-		RetryCount: 0,
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	slog.SetDefault(slog.New(handler))
 
-	log.Printf("Worker-%d 开始执行%s: %s", workerID, task.Name, task.Cmd)
-
-	// 执行命令
-	var output bytes.Buffer
-	var err error
-	var exitCode int
-
-	for attempt := 0; attempt <= task.RetryCount; attempt++ {
-		if attempt > 0 {
-			log.Printf("任务 %s 第 %d 次重试...", task.Name, attempt)
-			time.Sleep(task.RetryDelay)
-		}
-
-		result.RetryCount = attempt
-		output.Reset()
-		exitCode, err = s.runCommand(task, &output)
-
-		if err == nil {
-			result.Status = StatusSuccess
-			break
-		}
-
-		if attempt == task.RetryCount {
-			result.Status = StatusFailed
-		}
-	}
-
-	result.EndTime = time.Now()
-	result.Duration = result.EndTime.Sub(result.StartTime)
-	result.ExitCode = exitCode
-	result.Output = s.trimOutput(output.String(), task.MaxOutput)
-	result.Error = err
-
-	return result
-}
-
-// worker 工作协程
-func (s *Scheduler) worker(id int) {
-	defer s.wg.Done()
-	for {
-		select {
-		case <-s.ctx.Done():
-			return
-		case task := <-s.taskQueue:
-			result := s.executeTask(id, task)
-			s.taskResultQueue <- result
+	// 秘密
+	for _, path := range strings.Split(*secretsFile, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
 		}
-	}
-}
-
-// printResult 打印任务结果
-func (s *Scheduler) printResult(result *TaskResult) {
-	var statusColor *color.Color
-
-	switch result.Status {
-	case StatusSuccess:
-		statusColor = color.New(color.FgGreen, color.Bold)
-	case StatusFailed:
-		statusColor = color.New(color.FgRed, color.Bold)
-	case StatusCancelled:
-		statusColor = color.New(color.FgYellow, color.Bold)
-	default:
-		statusColor = color.New(color.FgWhite)
-	}
-
-	statusColor.Printf("\n任务完成: %s (%s)\n", result.TaskName, result.TaskID)
-	fmt.Printf("  状态: %s", result.Status)
-	fmt.Printf("  耗时: %v", result.Duration)
-	fmt.Printf("  开始: %s", result.StartTime.Format(time.DateTime))
-	fmt.Printf("  结束: %s", result.EndTime.Format(time.DateTime))
-	fmt.Printf("  退出码: %d", result.ExitCode)
-	fmt.Printf("  重试次数: %d", result.RetryCount)
-
-	if result.Error != nil {
-		fmt.Printf("  错误: %v\n", result.Error)
-	}
-
-	if result.Output != "" {
-		fmt.Println("  输出预览:")
-		lines := bytes.SplitN([]byte(result.Output), []byte("\n"), 6)
-		for i, line := range lines {
-			if i >= 5 {
-				fmt.Println("    ...(更多输出请查看完整日志)...")
-			}
-			if len(line) > 0 {
-				fmt.Printf("    %s\n", line)
-			}
+		if err := secrets.LoadFile(path); err != nil {
+			fatal("读取秘密文件失败", err)
 		}
 	}
-	fmt.Println()
-}
-
-// checkDependentTasks 检查依赖任务
-func (s *Scheduler) checkDependentTasks() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for _, task := range s.tasks {
-		// 如果任务已经在队列或已完成则跳过
-		if s.completedTasks[task.ID] {
-			continue
-		}
-		// 未进行任务依赖项是否全部满足
-		allDepsCompleted := true
-		for _, depID := range task.Dependencies {
-			if !s.completedTasks[depID] {
-				allDepsCompleted = false
-				break
+	if *secretEnv != "" {
+		var names []string
+		for _, name := range strings.Split(*secretEnv, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
 			}
 		}
-		// 如果依赖项项目全部满足，加入队列
-		if allDepsCompleted && len(task.Dependencies) > 0 {
-			// 标记已调度
-			if !s.completedTasks[task.ID] {
-				select {
-				case s.taskQueue <- task:
-					s.completedTasks[task.ID] = true
-				default:
-					log.Printf("队列任务已满, 任务 %s 等待调度", task.Name)
-				}
-			}
+		if err := secrets.LoadEnv(names...); err != nil {
+			fatal("读取秘密失败", err)
 		}
 	}
-}
 
-// resultProcessor 处理任务结果
-func (s *Scheduler) resultProcessor() {
-	for result := range s.taskResultQueue {
-		s.mu.Lock()
-		s.taskResults[result.TaskID] = result
-		s.completedTasks[result.TaskID] = true
-		s.mu.Unlock()
-		// 打印结果
-		s.printResult(result)
-		// 检查是否有依赖此任务的任务可以执行
-		s.checkDependentTasks()
+	// 调度器配置
+	opts := []scheduler.Option{
+		scheduler.WithName(*runName),
+		scheduler.WithMaxWorkers(3),
+		scheduler.WithLogger(slog.Default()),
+		scheduler.WithSecrets(secrets),
+		scheduler.WithRunTimeout(*runTimeout),
+		scheduler.WithNotifyStateFile(*notifyState),
+		scheduler.WithProgress(*progress),
 	}
-}
 
-// AddTasks 批量添加任务
-func (s *Scheduler) AddTasks(tasks ...*Task) {
-	for _, task := range tasks {
-		s.AddTask(task)
+	// 通知器
+	events, err := scheduler.ParseNotifyEvents(*notifyOn)
+	if err != nil {
+		fatal("解析通知事件失败", err)
 	}
-}
-
-// Start 启动调度器
-func (s *Scheduler) Start() error {
-	s.mu.Lock()
-	if s.isRunning {
-		s.mu.Unlock()
-		return fmt.Errorf("程序已经在运行")
+	retry := scheduler.RetryPolicy{Attempts: 3, Delay: 2 * time.Second, Timeout: 30 * time.Second}
+	if *notifyWebhook != "" {
+		webhook, err := scheduler.NewWebhookNotifier(*notifyWebhook, *notifyWebhookBody)
+		if err != nil {
+			fatal("创建 webhook 通知器失败", err)
+		}
+		opts = append(opts, scheduler.WithNotifier(webhook, retry, events...))
 	}
-	s.isRunning = true
-	s.mu.Unlock()
-
-	// 启动work
-	for i := 0; i < s.maxWorkers; i++ {
-		s.wg.Add(1)
-		go s.worker(i)
+	if *notifyCommand != "" {
+		opts = append(opts, scheduler.WithNotifier(&scheduler.CommandNotifier{Cmd: *notifyCommand}, scheduler.RetryPolicy{Attempts: 1, Timeout: time.Minute}, events...))
 	}
-
-	// 启动结果处理器
-	go s.resultProcessor()
-
-	// 启动任务调器
-	go s.taskDispatcher()
-
-	log.Printf("调度器启动，最大并发数: %d", s.maxWorkers)
-	return nil
-}
-
-// Stop 停止调度器
-func (s *Scheduler) Stop() {
-	log.Println("停止调度器...")
-	s.cancel()
-	s.wg.Wait()
-	close(s.taskQueue)
-	close(s.taskResultQueue)
-	s.isRunning = false
-	log.Println("调度器已停止")
-}
-
-// GetResults 获取所有任务结果
-func (s *Scheduler) GetResults() map[string]*TaskResult {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	results := make(map[string]*TaskResult)
-	for k, v := range s.taskResults {
-		results[k] = v
+	if *notifySMTP != "" {
+		opts = append(opts, scheduler.WithNotifier(&scheduler.EmailNotifier{
+			Addr:     *notifySMTP,
+			Username: *notifySMTPUser,
+			Password: os.Getenv("NOTIFY_SMTP_PASSWORD"),
+			From:     *notifyEmailFrom,
+			To:       strings.Split(*notifyEmailTo, ","),
+		}, retry, events...))
 	}
-	return results
-}
-
-// PrintSummary 打印汇总报告
-func (s *Scheduler) PrintSummary() {
-	results := s.GetResults()
 
-	fmt.Println("\n" + strings.Repeat("-", 60))
-	fmt.Println("任务执行汇总报告")
-	fmt.Println(strings.Repeat("-", 60))
-
-	var totalTime time.Duration
-	successCount := 0
-	failedCount := 0
-
-	for _, result := range results {
-		totalTime += result.Duration
-		if result.Status == StatusSuccess {
-			successCount++
-		} else {
-			failedCount++
-		}
+	if *cacheDir != "" {
+		opts = append(opts, scheduler.WithCache(scheduler.NewTaskCache(*cacheDir)))
 	}
-
-	fmt.Printf("任务总数: %d\n", len(results))
-	fmt.Printf("成功: %d\n", successCount)
-	fmt.Printf("失败: %d\n", failedCount)
-	fmt.Printf("总耗时: %v\n", totalTime)
-	fmt.Printf("平均耗时: %v\n", totalTime/time.Duration(len(results)))
-
-	// 打印详细结果表格
-	fmt.Println("\n详细结果:")
-	fmt.Println(strings.Repeat("-", 100))
-	fmt.Printf("%-20s %-15s %-12s %-10s %-30s\n", "任务名称", "状态", "耗时", "退出码", "开始时间")
-	fmt.Println(strings.Repeat("-", 100))
-	for _, result := range results {
-		statusStr := result.Status.String()
-		if result.Status == StatusSuccess {
-			statusStr = color.GreenString(statusStr)
-		} else {
-			statusStr = color.RedString(statusStr)
+	if *watch {
+		opts = append(opts, scheduler.WithWatch(scheduler.WatchOptions{Debounce: *watchDebounce, PollInterval: *watchPoll}))
+	}
+	if *historyFile != "" {
+		history, err := scheduler.LoadHistory(*historyFile)
+		if err != nil {
+			fatal("读取历史耗时失败", err)
 		}
-
-		fmt.Printf("%-20s %-15s %-12v %-10d %-30s\n", result.TaskName, statusStr, result.Duration.Round(time.Millisecond), result.ExitCode, result.StartTime.Format(time.DateTime))
-		fmt.Println(strings.Repeat("-", 100))
+		opts = append(opts, scheduler.WithHistory(history))
 	}
-}
 
-func main() {
 	// 创建调度器
-	scheduler := NewScheduler(3)
+	s := scheduler.NewScheduler(opts...)
+
+	// 启动指标服务
+	if *listen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", s.MetricsHandler())
+		s.HandleGates(mux)
+		s.HandleFreeze(mux)
+		s.HandleStatus(mux)
+		go func() {
+			slog.Info("指标服务启动", "url", "http://"+*listen+"/metrics")
+			if err := http.ListenAndServe(*listen, mux); err != nil {
+				slog.Error("指标服务启动失败", "error", err)
+			}
+		}()
+	}
 
 	// 定义任务
-	tasks := []*Task{
+	tasks := []*scheduler.Task{
 		{
 			ID:         "Test A",
 			Name:       "测试脚本A",
@@ -522,6 +237,7 @@ func main() {
 			Timeout:    10 * time.Minute,
 			RetryDelay: 3 * time.Second,
 			RetryCount: 2,
+			Inputs:     []string{"shell/test.sh"},
 		},
 		{
 			ID:         "Test C",
@@ -542,40 +258,112 @@ func main() {
 		},
 	}
 
+	// 源码检出步骤：原来没有依赖的任务都改为在检出之后执行
+	if *gitRepo != "" {
+		checkout := &scheduler.Task{
+			ID:       "checkout",
+			Name:     "检出源码",
+			Executor: scheduler.ExecutorGit,
+			Git: &scheduler.GitCheckout{
+				Repo:     *gitRepo,
+				Ref:      *gitRef,
+				Commit:   *gitCommit,
+				Dir:      *gitDir,
+				CacheDir: *gitCache,
+			},
+		}
+		for _, task := range tasks {
+			if len(task.Dependencies) == 0 {
+				task.Dependencies = []string{checkout.ID}
+			}
+		}
+		tasks = append([]*scheduler.Task{checkout}, tasks...)
+	}
+
 	// 添加任务
-	scheduler.AddTasks(tasks...)
+	s.AddTasks(tasks...)
 
-	// 启动调度
-	if err := scheduler.Start(); err != nil {
-		log.Fatal("启动失败:", err)
+	if *simulate != "" {
+		runSimulation(s, *simulate, reports)
+		return
 	}
 
-	// 等待所有任务完成
-	fmt.Println("调度器运行中, Ctrl+C 停止")
+	// 单实例锁：同名流水线同一时间只能运行一个
+	if *lockName != "" {
+		policy := scheduler.LockPolicy(*onConflict)
+		if policy != scheduler.LockFail && policy != scheduler.LockWait && policy != scheduler.LockCancel {
+			fatal("参数错误", fmt.Errorf("无效的 --on-conflict %q", *onConflict))
+		}
+		socket := filepath.Join(*lockDir, fmt.Sprintf("%s.%d.sock", *lockName, os.Getpid()))
+		control, err := s.ServeControl(socket)
+		if err != nil {
+			fatal("启动控制 socket 失败", err)
+		}
+		defer control.Close()
+		lock, err := scheduler.AcquireLock(scheduler.LockOptions{
+			Dir:     *lockDir,
+			Name:    *lockName,
+			RunID:   s.RunID(),
+			Socket:  socket,
+			Policy:  policy,
+			Timeout: *lockTimeout,
+		})
+		if err != nil {
+			control.Close()
+			fatal("获取流水线锁失败", err)
+		}
+		defer lock.Release()
+	}
 
-	// 监听中断信号
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// 每次运行独立的临时工作区和产物目录，拿到锁之后再创建，避免冲突时留下空目录
+	if *workspaceRoot != "" {
+		ws, err := scheduler.NewWorkspace(*workspaceRoot, s.RunID(), scheduler.WorkspaceKeep(*keepWorkspace))
+		if err != nil {
+			fatal("创建运行目录失败", err)
+		}
+		s.SetWorkspace(ws)
+	}
 
-	// 等待完成或者收到中断信号
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+	// 终端界面：结果显示在界面上，不再逐条打印
+	var dash *dashboard
+	if *tui {
+		if dash, err = newDashboard(s, logOut); err != nil {
+			slog.Warn("无法启动终端界面，使用普通输出", "error", err)
+		} else {
+			s.SetOutput(io.Discard)
+		}
+	}
 
-	for {
-		select {
-		case <-sigChan:
-			fmt.Println("\n接收到中断信号，正在停止...")
-			scheduler.Stop()
-			scheduler.PrintSummary()
-			return
-		case <-ticker.C:
-			// 检查是否所有任务都已完成
-			results := scheduler.GetResults()
-			if len(results) == len(tasks) {
-				scheduler.Stop()
-				scheduler.PrintSummary()
-				return
-			}
+	// 收到中断信号时取消运行
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 启动调度
+	if err := s.Start(ctx); err != nil {
+		if dash != nil {
+			dash.Close()
 		}
+		fatal("启动失败", err)
 	}
+
+	if dash != nil {
+		// 界面在运行结束后保持显示，直到用户按 q 退出
+		dash.Run(ctx.Done())
+		dash.Close()
+		finish(s, reports)
+		return
+	}
+
+	// 等待所有任务完成
+	fmt.Println("调度器运行中, Ctrl+C 停止")
+
+	// 等待完成或者收到中断信号
+	select {
+	case <-ctx.Done():
+		fmt.Println("\n接收到中断信号，正在停止...")
+	case <-s.Aborted():
+		fmt.Println("\n运行被中止，正在停止...")
+	case <-s.Done():
+	}
+	finish(s, reports)
 }
@@ -0,0 +1,308 @@
+package scheduler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TaskCache 基于输入指纹的任务缓存
+//
+// 目录结构：
+//
+//	<dir>/<任务ID>/<指纹>/manifest.json
+//	<dir>/<任务ID>/<指纹>/outputs/...
+//
+// 只有声明了 Inputs 的任务才会参与缓存，没有声明输入的任务无法判断“有没有变化”
+type TaskCache struct {
+	dir string
+}
+
+// cacheManifest 缓存条目的描述信息
+type cacheManifest struct {
+	TaskID      string    `json:"task_id"`
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"created_at"`
+	Outputs     []string  `json:"outputs"`
+}
+
+// NewTaskCache 创建缓存，dir 不存在时会在第一次写入时创建
+func NewTaskCache(dir string) *TaskCache {
+	return &TaskCache{dir: dir}
+}
+
+// SetCache 为调度器启用输入指纹缓存
+func (s *Scheduler) SetCache(cache *TaskCache) {
+	s.mu.Lock()
+	s.cache = cache
+	s.mu.Unlock()
+}
+
+// resolvePath 把任务中声明的相对路径解析到任务的工作目录下
+func resolvePath(task *Task, path string) string {
+	if filepath.IsAbs(path) || task.WorkDir == "" {
+		return path
+	}
+	return filepath.Join(task.WorkDir, path)
+}
+
+// expandInputs 展开任务声明的输入，返回排好序的文件列表
+// 每一项可以是文件、目录（递归包含其中所有文件）或 filepath.Match 风格的 glob
+func expandInputs(task *Task) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	for _, pattern := range task.Inputs {
+		matches, err := filepath.Glob(resolvePath(task, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("无效的输入模式 %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("输入 %q 没有匹配到任何文件", pattern)
+		}
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.Type().IsRegular() && !seen[path] {
+					seen[path] = true
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Fingerprint 计算任务的输入指纹
+// 参与计算的有：执行器、命令、参数、HTTP 请求、环境变量、工作目录、成功的判定规则，以及每个输入文件的路径和内容；
+// 资源限制、运行用户、伪终端等只影响怎样执行、不影响结果的设置不参与
+// runEnv 是运行中导出给任务的变量（例如源码检出后的 GIT_COMMIT），检出的提交不同时指纹也不同；
+// 每次运行都不同的 $WORKSPACE 和秘密不在其中。task 中的路径已经展开，workspace 下的路径
+// 按 $WORKSPACE/... 计算，同一个任务在不同运行的工作区中指纹相同
+func (c *TaskCache) Fingerprint(task *Task, workspace string, runEnv []string) (string, error) {
+	h := sha256.New()
+	executor := task.Executor
+	if executor == "" {
+		executor = ExecutorShell
+	}
+	fmt.Fprintf(h, "executor\x00%s\x00", executor)
+	fmt.Fprintf(h, "cmd\x00%s\x00", task.Cmd)
+	for _, arg := range task.Args {
+		fmt.Fprintf(h, "arg\x00%s\x00", arg)
+	}
+	fmt.Fprintf(h, "script\x00%s\x00shell\x00%s\x00", task.Script, task.Shell)
+	for _, arg := range task.Interpreter {
+		fmt.Fprintf(h, "interp\x00%s\x00", arg)
+	}
+	if task.HTTP != nil {
+		// 请求头是 map，json 按键排序，结果是确定的
+		req, err := json.Marshal(task.HTTP)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "http\x00%s\x00", req)
+	}
+	for _, code := range task.SuccessExitCodes {
+		fmt.Fprintf(h, "exit\x00%d\x00", code)
+	}
+	for _, p := range task.FailOnOutput {
+		fmt.Fprintf(h, "fail\x00%s\x00", p)
+	}
+	for _, p := range task.SucceedOnOutput {
+		fmt.Fprintf(h, "succeed\x00%s\x00", p)
+	}
+	for _, p := range task.WarnOnOutput {
+		fmt.Fprintf(h, "warn\x00%s\x00", p)
+	}
+	env := append([]string(nil), task.Env...)
+	sort.Strings(env)
+	for _, kv := range env {
+		fmt.Fprintf(h, "env\x00%s\x00", kv)
+	}
+	runEnv = slices.Clone(runEnv)
+	sort.Strings(runEnv)
+	for _, kv := range runEnv {
+		fmt.Fprintf(h, "runenv\x00%s\x00", kv)
+	}
+	fmt.Fprintf(h, "dir\x00%s\x00", portablePath(task.WorkDir, workspace))
+
+	files, err := expandInputs(task)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file\x00%s\x00", portablePath(file, workspace))
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// portablePath 把 workspace 下的路径写成 $WORKSPACE/...，其他路径保持原样，统一使用 / 分隔
+func portablePath(path, workspace string) string {
+	if workspace != "" && path != "" {
+		if rel, err := filepath.Rel(workspace, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			path = filepath.Join("$"+workspaceEnv, rel)
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// entryDir 缓存条目所在目录
+// 目录名用任务ID的哈希，ID 中的 ..、路径分隔符等都不会让条目落到缓存目录之外；ID 本身记录在清单中
+func (c *TaskCache) entryDir(taskID, fingerprint string) string {
+	sum := sha256.Sum256([]byte(taskID))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]), fingerprint)
+}
+
+// cacheable 任务能否参与缓存：func 执行器调用的函数无法计算指纹，git 检出的结果取决于远程仓库
+func cacheable(task *Task) bool {
+	return task.Executor != ExecutorFunc && task.Executor != ExecutorGit
+}
+
+// Restore 查找指纹对应的缓存条目，找到时把声明的输出恢复到原位置，$WORKSPACE 下的输出恢复到本次运行的工作区
+func (c *TaskCache) Restore(task *Task, workspace, fingerprint string) (bool, error) {
+	dir := c.entryDir(task.ID, fingerprint)
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var manifest cacheManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return false, fmt.Errorf("缓存清单损坏: %w", err)
+	}
+	for i, output := range manifest.Outputs {
+		src := filepath.Join(dir, "outputs", fmt.Sprint(i))
+		if _, err := os.Lstat(src); os.IsNotExist(err) {
+			// 任务成功时该输出并不存在，恢复时同样保持不存在
+			continue
+		}
+		dst := resolvePath(task, expandWorkspace(filepath.FromSlash(output), workspace))
+		if err := os.RemoveAll(dst); err != nil {
+			return false, err
+		}
+		if err := copyPath(src, dst); err != nil {
+			return false, fmt.Errorf("恢复输出 %s 失败: %w", output, err)
+		}
+	}
+	return true, nil
+}
+
+// Save 任务成功后把声明的输出保存到缓存中，工作区中的输出在清单中记为 $WORKSPACE/...
+// 先写入临时目录再重命名，避免中途失败留下不完整的缓存条目
+func (c *TaskCache) Save(task *Task, workspace, fingerprint string) error {
+	dir := c.entryDir(task.ID, fingerprint)
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	outputs := make([]string, len(task.Outputs))
+	for i, output := range task.Outputs {
+		outputs[i] = portablePath(output, workspace)
+		src := resolvePath(task, output)
+		if _, err := os.Lstat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyPath(src, filepath.Join(tmp, "outputs", fmt.Sprint(i))); err != nil {
+			return fmt.Errorf("保存输出 %s 失败: %w", output, err)
+		}
+	}
+	manifest := cacheManifest{
+		TaskID:      task.ID,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now(),
+		Outputs:     outputs,
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "manifest.json"), data, 0o644); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// copyPath 复制文件或目录，保留文件权限
+func copyPath(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+// copyFile 复制单个文件
+func copyFile(src, dst string, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
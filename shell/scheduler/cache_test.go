@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCacheEntryDir(t *testing.T) {
+	c := NewTaskCache(t.TempDir())
+	seen := make(map[string]string)
+	for _, id := range []string{"..", ".", "../../etc", `a\b`, "a/b", "a_b", "C:x", "build"} {
+		dir := c.entryDir(id, "fp")
+		rel, err := filepath.Rel(c.dir, dir)
+		if err != nil || strings.HasPrefix(rel, "..") || strings.Count(filepath.ToSlash(rel), "/") != 1 {
+			t.Errorf("任务 %q 的缓存目录 %s 不在缓存目录下", id, dir)
+		}
+		if other, ok := seen[dir]; ok {
+			t.Errorf("任务 %q 和 %q 使用了同一个缓存目录", id, other)
+		}
+		seen[dir] = id
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(input, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	base := func() *Task {
+		return &Task{ID: "a", Cmd: "make", Inputs: []string{input}}
+	}
+	c := NewTaskCache(dir)
+	want, err := c.Fingerprint(base(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		change   func(task *Task)
+		runEnv   []string
+		wantSame bool
+	}{
+		{name: "默认执行器就是 shell", change: func(task *Task) { task.Executor = ExecutorShell }, wantSame: true},
+		{name: "资源限制不影响结果", change: func(task *Task) { task.Limits = &ResourceLimits{} }, wantSame: true},
+		{name: "执行器", change: func(task *Task) { task.Executor = ExecutorHTTP }},
+		{name: "HTTP 请求", change: func(task *Task) { task.HTTP = &HTTPRequest{URL: "http://example.com"} }},
+		{name: "成功退出码", change: func(task *Task) { task.SuccessExitCodes = []int{0, 3} }},
+		{name: "失败模式", change: func(task *Task) { task.FailOnOutput = []string{"FAIL"} }},
+		{name: "导出的变量", runEnv: []string{"GIT_COMMIT=abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := base()
+			if tt.change != nil {
+				tt.change(task)
+			}
+			got, err := c.Fingerprint(task, "", tt.runEnv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == want) != tt.wantSame {
+				t.Errorf("指纹相同 = %v，期望 %v", got == want, tt.wantSame)
+			}
+		})
+	}
+}
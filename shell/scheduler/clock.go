@@ -0,0 +1,254 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock 调度器使用的时钟
+//
+// 默认是系统时钟；模拟模式下换成 SimClock，时间只在模拟器推进时前进，
+// 任务耗时、重试延迟、超时、审批超时都按虚拟时间计算，见 Simulation
+type Clock interface {
+	Now() time.Time
+	// AfterFunc d 之后调用 f，返回的函数用于取消，语义与 time.AfterFunc 相同
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+	// WithTimeout 与 context.WithTimeout 相同，截止时间按这个时钟计算
+	WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc)
+	// Sleep 等待 d 或 ctx 结束，ctx 结束时返回 ctx.Err()
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// realClock 系统时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+func (realClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, d)
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetClock 替换调度器的时钟，需要在 Start 之前调用
+func (s *Scheduler) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// SimClock 虚拟时钟，时间只在调用 Advance 或 AdvanceNext 时前进
+//
+// 到期的计时器在推进时钟的 goroutine 中按到期时间依次同步执行，
+// 所以推进返回时，被唤醒的任务已经确定，结果可以复现
+type SimClock struct {
+	mu       sync.Mutex
+	now      time.Time
+	seq      int
+	timers   []*simTimer // 按到期时间排序，同时到期的按创建顺序
+	sleepers map[*simSleeper]bool
+}
+
+type simTimer struct {
+	when time.Time
+	seq  int
+	fn   func()
+}
+
+// simSleeper 一个阻塞在 Sleep 中的 goroutine
+type simSleeper struct {
+	ctx  context.Context
+	wake chan struct{}
+}
+
+// NewSimClock 创建从 start 开始的虚拟时钟
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start, sleepers: make(map[*simSleeper]bool)}
+}
+
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *SimClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &simTimer{when: c.now.Add(d), seq: c.seq, fn: f}
+	i := sort.Search(len(c.timers), func(i int) bool { return c.timers[i].when.After(t.when) })
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, pending := range c.timers {
+			if pending == t {
+				c.timers = append(c.timers[:i], c.timers[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (c *SimClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	dc := &simDeadlineCtx{Context: ctx, deadline: c.Now().Add(d)}
+	if d <= 0 {
+		cancel(context.DeadlineExceeded)
+		return dc, func() { cancel(nil) }
+	}
+	stop := c.AfterFunc(d, func() { cancel(context.DeadlineExceeded) })
+	return dc, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+func (c *SimClock) Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 || ctx.Err() != nil {
+		return ctx.Err()
+	}
+	w := &simSleeper{ctx: ctx, wake: make(chan struct{})}
+	c.mu.Lock()
+	c.sleepers[w] = true
+	c.mu.Unlock()
+	// 到期时由推进时钟的 goroutine 把它移出 sleepers，保证推进返回时计数已经更新
+	stop := c.AfterFunc(d, func() {
+		c.mu.Lock()
+		delete(c.sleepers, w)
+		c.mu.Unlock()
+		close(w.wake)
+	})
+	select {
+	case <-w.wake:
+		return nil
+	case <-ctx.Done():
+		stop()
+		c.mu.Lock()
+		delete(c.sleepers, w)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Sleeping 返回阻塞在 Sleep 中的 goroutine 数量
+func (c *SimClock) Sleeping() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sleepers)
+}
+
+// NextTimer 返回最早的计时器的到期时间，没有计时器时返回 false
+func (c *SimClock) NextTimer() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].when, true
+}
+
+// AdvanceNext 把时钟推进到最早的计时器到期，只执行这一个计时器
+// 同一时刻到期的其他计时器留到下一次调用，调用方可以在两次之间等调度器处理完，
+// 同时发生的事件因此也有确定的先后顺序。没有计时器时返回 false
+func (c *SimClock) AdvanceNext() bool {
+	c.mu.Lock()
+	if len(c.timers) == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	c.mu.Unlock()
+	t.fn()
+	c.pruneSleepers()
+	return true
+}
+
+// Advance 把时钟推进 d，途中到期的计时器按到期时间依次执行
+func (c *SimClock) Advance(d time.Duration) {
+	c.advanceTo(c.Now().Add(d))
+}
+
+func (c *SimClock) advanceTo(target time.Time) {
+	for {
+		c.mu.Lock()
+		if len(c.timers) == 0 || c.timers[0].when.After(target) {
+			if target.After(c.now) {
+				c.now = target
+			}
+			c.mu.Unlock()
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		c.mu.Unlock()
+		// 计时器的回调可能再创建计时器或者取消 ctx，不能持有锁
+		t.fn()
+	}
+	c.pruneSleepers()
+}
+
+// pruneSleepers 把 ctx 已经结束的 sleeper 移出计数
+// 取消在 context 树中是同步传播的，所以计时器或回调取消任务后，这里立即可以看到
+func (c *SimClock) pruneSleepers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for w := range c.sleepers {
+		if w.ctx.Err() != nil {
+			delete(c.sleepers, w)
+		}
+	}
+}
+
+// simDeadlineCtx 按虚拟时间到期的 ctx
+// 到期时以 DeadlineExceeded 为原因取消内部的 ctx，Err 返回 DeadlineExceeded，与 context.WithTimeout 一致；
+// 内部是标准库的 cancelCtx，派生的 ctx 会被同步取消
+type simDeadlineCtx struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c *simDeadlineCtx) Deadline() (time.Time, bool) {
+	if d, ok := c.Context.Deadline(); ok && d.Before(c.deadline) {
+		return d, true
+	}
+	return c.deadline, true
+}
+
+func (c *simDeadlineCtx) Err() error {
+	err := c.Context.Err()
+	if err != nil && errors.Is(context.Cause(c.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
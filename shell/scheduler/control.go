@@ -0,0 +1,443 @@
+package scheduler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// 控制协议：客户端连接后发送一行 JSON 请求（ControlRequest），服务端回复一行或多行 JSON 响应。
+// 普通命令只有一条响应；流式命令（logs -f、run 等待结束）先发送若干条 More 为 true 的中间结果，
+// 最后一条 More 为 false。控制 socket 只允许所属用户访问，权限由文件系统保证；
+// 审批等需要记录身份的命令使用 socket 对端进程的凭据，而不是请求中声明的 By。
+
+// ControlRequest 控制 socket 的请求，每个连接一行 JSON
+type ControlRequest struct {
+	Command  string `json:"command"`
+	By       string `json:"by,omitempty"`       // 发起者，用于日志
+	Run      string `json:"run,omitempty"`      // 守护进程中的运行 ID，为空表示最近一次运行
+	Task     string `json:"task,omitempty"`     // 任务 ID，cancel / retry / logs 使用
+	Pipeline string `json:"pipeline,omitempty"` // run 命令的流水线文件，需要是绝对路径
+	Follow   bool   `json:"follow,omitempty"`   // logs 持续输出直到任务结束；run / retry 等待结束
+	Lines    int    `json:"lines,omitempty"`    // logs 只输出最后多少行，为 0 时输出全部
+	Reason   string `json:"reason,omitempty"`   // freeze 的原因
+	Comment  string `json:"comment,omitempty"`  // approve / reject 的审批意见
+
+	peer string // socket 对端进程的用户，由服务端根据连接的凭据填写，平台不支持时为空
+}
+
+// identity 发起请求的用户，取自 socket 对端的凭据，客户端无法伪造
+func (req ControlRequest) identity() (string, error) {
+	if req.peer == "" {
+		return "", fmt.Errorf("无法识别控制 socket 对端的用户，不能执行 %s", req.Command)
+	}
+	return req.peer, nil
+}
+
+// controlResponse 控制 socket 的响应
+type controlResponse struct {
+	OK    bool            `json:"ok"`
+	More  bool            `json:"more,omitempty"` // 流式响应的中间结果，后面还有响应
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// ControlStatus status 命令返回的内容
+type ControlStatus struct {
+	RunID    string   `json:"run_id"`
+	PID      int      `json:"pid"`
+	Progress Progress `json:"progress"`
+}
+
+// ControlTask tasks 命令返回的任务状态，logs / retry 结束时也返回它
+type ControlTask struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Status       string    `json:"status"` // 见 TaskStatus.Code
+	StartTime    time.Time `json:"start_time,omitzero"`
+	ElapsedMs    int64     `json:"elapsed_ms"`
+	Attempt      int       `json:"attempt"`
+	Dependencies []string  `json:"dependencies,omitempty"`
+	ExpectedMs   int64     `json:"expected_ms,omitempty"` // 历史耗时的中位数，没有记录时为 0
+	Slow         bool      `json:"slow,omitempty"`        // 运行时间超过平时（历史 p95）
+}
+
+// newControlTask 把状态快照转换为响应
+func newControlTask(st TaskState) ControlTask {
+	return ControlTask{
+		ID:           st.ID,
+		Name:         st.Name,
+		Status:       st.Status.Code(),
+		StartTime:    st.StartTime,
+		ElapsedMs:    st.Elapsed.Milliseconds(),
+		Attempt:      st.Attempt,
+		Dependencies: st.Dependencies,
+		ExpectedMs:   st.Expected.Milliseconds(),
+		Slow:         st.Slow,
+	}
+}
+
+// controlHandler 处理一条控制请求
+// 流式命令通过 send 发送中间结果，返回值作为最后一条响应；客户端断开时 ctx 被取消
+type controlHandler func(ctx context.Context, req ControlRequest, send func(any) error) (any, error)
+
+// controlPollInterval 流式命令检查任务状态和输出的间隔
+const controlPollInterval = 200 * time.Millisecond
+
+// ControlServer 调度器的控制 socket，其他进程通过它查询或取消本次运行
+type ControlServer struct {
+	path     string
+	listener net.Listener
+}
+
+// ServeControl 在 path 上监听 Unix socket
+func (s *Scheduler) ServeControl(path string) (*ControlServer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	return serveControl(path, 0o600, s.handleControl)
+}
+
+// serveControl 在 path 上监听 Unix socket，并把 socket 文件的权限设为 mode
+func serveControl(path string, mode os.FileMode, handle controlHandler) (*ControlServer, error) {
+	// 上一次异常退出可能留下了 socket 文件：能连上说明还有进程在使用它，不能抢占；
+	// 只有连接被拒绝时才是残留的文件，可以删除
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("控制 socket %s 正在被其他进程使用", path)
+	} else if connRefused(err) {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("监听控制 socket 失败: %w", err)
+	}
+	// 能连接 socket 就能取消运行，所以只允许有权限的用户访问
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("设置控制 socket 权限失败: %w", err)
+	}
+	cs := &ControlServer{path: path, listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveControlConn(conn, handle)
+		}
+	}()
+	return cs, nil
+}
+
+// Close 停止监听并删除 socket 文件
+func (cs *ControlServer) Close() error {
+	err := cs.listener.Close()
+	os.Remove(cs.path)
+	return err
+}
+
+// Path socket 文件的路径
+func (cs *ControlServer) Path() string {
+	return cs.path
+}
+
+// serveControlConn 处理一个连接上的一条请求
+func serveControlConn(conn net.Conn, handle controlHandler) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	var req ControlRequest
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	enc := json.NewEncoder(conn)
+	if err != nil {
+		enc.Encode(controlResponse{Error: "无效的请求: " + err.Error()})
+		return
+	}
+	req.peer = peerUser(conn)
+
+	// 流式命令可能持续很久，客户端发完请求后不会再写入，读到 EOF 说明它已经断开
+	conn.SetReadDeadline(time.Time{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		reader.ReadByte()
+		cancel()
+	}()
+
+	send := func(v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(controlResponse{OK: true, More: true, Data: data})
+	}
+	var resp controlResponse
+	if v, err := handle(ctx, req, send); err != nil {
+		resp.Error = err.Error()
+	} else if resp.Data, err = json.Marshal(v); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.OK = true
+	}
+	enc.Encode(resp)
+}
+
+// peerUser socket 对端进程的用户名，查不到用户名时为 uid，无法取得凭据时为空
+func peerUser(conn net.Conn) string {
+	uid, err := peerUID(conn)
+	if err != nil {
+		return ""
+	}
+	id := strconv.Itoa(uid)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	return "uid " + id
+}
+
+// sockoptUID 在连接的文件描述符上调用 get 读取对端的用户 ID
+func sockoptUID(conn net.Conn, get func(fd int) (int, error)) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var uid int
+	var getErr error
+	if err := raw.Control(func(fd uintptr) { uid, getErr = get(int(fd)) }); err != nil {
+		return 0, err
+	}
+	return uid, getErr
+}
+
+// handleControl 执行一条控制命令
+func (s *Scheduler) handleControl(ctx context.Context, req ControlRequest, send func(any) error) (any, error) {
+	switch req.Command {
+	case "status":
+		return ControlStatus{RunID: s.runID, PID: os.Getpid(), Progress: s.Progress()}, nil
+	case "tasks", "ps":
+		states := s.Snapshot()
+		tasks := make([]ControlTask, 0, len(states))
+		for _, st := range states {
+			tasks = append(tasks, newControlTask(st))
+		}
+		return tasks, nil
+	case "cancel":
+		if req.Task != "" {
+			s.logger.Warn("通过控制 socket 取消任务", logKeyTaskID, req.Task, "by", req.By)
+			return nil, s.CancelTask(req.Task)
+		}
+		s.Abort(fmt.Sprintf("通过控制 socket 取消 (%s)", req.By))
+		return nil, nil
+	case "retry":
+		if req.Task == "" {
+			return nil, errors.New("需要指定任务")
+		}
+		if err := s.RetryTask(req.Task); err != nil {
+			return nil, err
+		}
+		s.logger.Info("通过控制 socket 重试任务", logKeyTaskID, req.Task, "by", req.By)
+		if !req.Follow {
+			return nil, nil
+		}
+		return s.followTask(ctx, req.Task, nil)
+	case "logs":
+		if req.Task == "" {
+			return nil, errors.New("需要指定任务")
+		}
+		return s.streamLogs(ctx, req, send)
+	case "approve", "reject":
+		if req.Task == "" {
+			return nil, errors.New("需要指定任务")
+		}
+		approver, err := req.identity()
+		if err != nil {
+			return nil, err
+		}
+		if req.Command == "approve" {
+			return nil, s.Approve(req.Task, approver, req.Comment)
+		}
+		return nil, s.Reject(req.Task, approver, req.Comment)
+	case "freeze", "unfreeze":
+		by, err := req.identity()
+		if err != nil {
+			return nil, err
+		}
+		if req.Command == "freeze" {
+			s.Freeze(req.Reason, by)
+		} else {
+			s.Unfreeze(by)
+		}
+		return s.FreezeState(), nil
+	case "freeze-status":
+		return s.FreezeState(), nil
+	default:
+		return nil, fmt.Errorf("未知的命令 %q", req.Command)
+	}
+}
+
+// streamLogs 发送任务的输出，每行一条响应；Follow 时持续发送新的输出直到任务结束
+// 最后返回任务的状态，客户端据此决定退出码
+func (s *Scheduler) streamLogs(ctx context.Context, req ControlRequest, send func(any) error) (any, error) {
+	st, ok := s.TaskState(req.Task)
+	if !ok {
+		return nil, fmt.Errorf("任务 %s 不存在", req.Task)
+	}
+	lines := s.OutputTail(req.Task, math.MaxInt)
+	shown := lines
+	if req.Lines > 0 && len(shown) > req.Lines {
+		shown = shown[len(shown)-req.Lines:]
+	}
+	for _, line := range shown {
+		if err := send(line); err != nil {
+			return nil, err
+		}
+	}
+	if !req.Follow || st.Status.Finished() {
+		return newControlTask(st), nil
+	}
+
+	sent, attempt := len(lines), st.Attempt
+	return s.followTask(ctx, req.Task, func(st TaskState) error {
+		lines := s.OutputTail(req.Task, math.MaxInt)
+		switch {
+		case st.Attempt != attempt:
+			// 重试时输出从头开始
+			sent, attempt = 0, st.Attempt
+		case len(lines) < sent:
+			// 结束后的输出按 MaxOutput 截断过，已经发送的部分不再重复
+			sent = len(lines)
+		}
+		for _, line := range lines[sent:] {
+			if err := send(line); err != nil {
+				return err
+			}
+		}
+		sent = len(lines)
+		return nil
+	})
+}
+
+// followTask 等待任务结束，期间每次检查都调用 poll（可以为 nil），返回任务最终的状态
+// 运行被中止、调度器停止或客户端断开时提前返回
+func (s *Scheduler) followTask(ctx context.Context, id string, poll func(TaskState) error) (any, error) {
+	ticker := time.NewTicker(controlPollInterval)
+	defer ticker.Stop()
+	for {
+		st, ok := s.TaskState(id)
+		if !ok {
+			return nil, fmt.Errorf("任务 %s 不存在", id)
+		}
+		// 任务刚结束时最后一批输出也要发出去，所以先 poll 再判断
+		if poll != nil {
+			if err := poll(st); err != nil {
+				return nil, err
+			}
+		}
+		if st.Status.Finished() {
+			return newControlTask(st), nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.Aborted():
+			return newControlTask(st), nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ControlClient 控制 socket 的客户端，连接调度器或守护进程
+type ControlClient struct {
+	Path string // socket 文件的路径
+}
+
+// Call 发送一条普通命令，把响应的数据解析到 out（可以为 nil）
+func (c ControlClient) Call(req ControlRequest, out any) error {
+	conn, err := net.DialTimeout("unix", c.Path, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return roundTrip(conn, req, nil, out)
+}
+
+// Stream 发送一条流式命令，每条中间结果调用一次 fn，最后的响应解析到 out（可以为 nil）
+// 不设超时，直到服务端结束或 ctx 被取消
+func (c ControlClient) Stream(ctx context.Context, req ControlRequest, fn func(json.RawMessage) error, out any) error {
+	done := make(chan struct{})
+	defer close(done)
+	conn, err := net.DialTimeout("unix", c.Path, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return roundTrip(conn, req, fn, out)
+}
+
+// roundTrip 在连接上发送一条请求并读取所有响应，结束后关闭连接
+func roundTrip(conn net.Conn, req ControlRequest, fn func(json.RawMessage) error, out any) error {
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var resp controlResponse
+		if err := dec.Decode(&resp); err != nil {
+			return err
+		}
+		if !resp.OK {
+			return errors.New(resp.Error)
+		}
+		if resp.More {
+			if fn != nil {
+				if err := fn(resp.Data); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if out != nil && len(resp.Data) > 0 {
+			return json.Unmarshal(resp.Data, out)
+		}
+		return nil
+	}
+}
+
+// Abort 请求中止本次运行，效果与收到中断信号相同：停止调度、输出汇总后退出
+func (s *Scheduler) Abort(reason string) {
+	s.abortOnce.Do(func() {
+		s.logger.Warn("运行被中止", "reason", reason)
+		close(s.aborted)
+	})
+}
+
+// Aborted 返回一个在 Abort 被调用后关闭的 channel
+func (s *Scheduler) Aborted() <-chan struct{} {
+	return s.aborted
+}
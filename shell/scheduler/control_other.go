@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package scheduler
+
+// connRefused 其他平台无法区分残留的 socket 文件，一律不删除，由用户手动清理
+func connRefused(err error) bool {
+	return false
+}
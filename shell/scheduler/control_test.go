@@ -0,0 +1,125 @@
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveTestControl 在临时目录中启动调度器的控制 socket
+// Unix socket 的路径长度有限，所以不使用 t.TempDir() 下较深的目录
+func serveTestControl(t *testing.T, s *Scheduler) ControlClient {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	cs, err := s.ServeControl(filepath.Join(dir, "control.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cs.Close() })
+	return ControlClient{Path: cs.Path()}
+}
+
+func TestControlGateApproverFromPeer(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("当前平台无法取得 socket 对端的凭据")
+	}
+	me, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		command    string
+		wantStatus TaskStatus
+	}{
+		{"通过", "approve", StatusSuccess},
+		{"拒绝", "reject", StatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheduler(WithMaxWorkers(1))
+			if err := s.AddTask(&Task{ID: "release", Kind: KindGate, Gate: &Gate{}}); err != nil {
+				t.Fatal(err)
+			}
+			ctl := serveTestControl(t, s)
+			if err := ctl.Call(ControlRequest{Command: tt.command, Task: "release"}, nil); err == nil {
+				t.Fatal("节点还没有开始等待时不应该能审批")
+			}
+			if err := s.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Stop()
+			for len(s.PendingGates()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			// 请求中声明的 By 不能冒充审批人
+			req := ControlRequest{Command: tt.command, Task: "release", By: "mallory", Comment: "ok"}
+			if err := ctl.Call(req, nil); err != nil {
+				t.Fatalf("%s 失败: %v", tt.command, err)
+			}
+			select {
+			case <-s.Done():
+			case <-time.After(10 * time.Second):
+				t.Fatal("运行没有结束")
+			}
+			r := s.GetResults()["release"]
+			if r.Status != tt.wantStatus {
+				t.Errorf("状态 = %s，期望 %s", r.Status, tt.wantStatus)
+			}
+			if r.Approval == nil || r.Approval.Approver != me.Username || r.Approval.Comment != "ok" {
+				t.Errorf("审批 = %+v，期望审批人为 %s", r.Approval, me.Username)
+			}
+			if err := ctl.Call(ControlRequest{Command: tt.command}, nil); err == nil || !strings.Contains(err.Error(), "需要指定任务") {
+				t.Errorf("没有指定任务时的错误 = %v", err)
+			}
+		})
+	}
+}
+
+func TestControlFreezeByFromPeer(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("当前平台无法取得 socket 对端的凭据")
+	}
+	me, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestScheduler()
+	ctl := serveTestControl(t, s)
+
+	var state FreezeState
+	if err := ctl.Call(ControlRequest{Command: "freeze", Reason: "发布窗口", By: "mallory"}, &state); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Frozen || state.By != me.Username || state.Reason != "发布窗口" {
+		t.Errorf("冻结状态 = %+v，期望由 %s 开启", state, me.Username)
+	}
+
+	// HTTP API 只能查看，不能修改冻结状态
+	mux := http.NewServeMux()
+	s.HandleFreeze(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("DELETE", "/freeze", nil))
+	if rec.Code != http.StatusMethodNotAllowed || !s.FreezeState().Frozen {
+		t.Errorf("DELETE /freeze 返回 %d，冻结状态 %+v", rec.Code, s.FreezeState())
+	}
+
+	if err := ctl.Call(ControlRequest{Command: "unfreeze"}, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Frozen {
+		t.Errorf("解除后的冻结状态 = %+v", state)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package scheduler
+
+import (
+	"errors"
+	"syscall"
+)
+
+// connRefused 连接 socket 时是否被拒绝，说明 socket 文件还在但已经没有进程监听
+func connRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
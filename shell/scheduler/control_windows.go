@@ -0,0 +1,14 @@
+package scheduler
+
+import (
+	"errors"
+	"syscall"
+)
+
+// wsaeConnRefused Windows 上连接被拒绝的错误码 WSAECONNREFUSED，syscall 包没有导出
+const wsaeConnRefused = syscall.Errno(10061)
+
+// connRefused 连接 socket 时是否被拒绝，说明 socket 文件还在但已经没有进程监听
+func connRefused(err error) bool {
+	return errors.Is(err, wsaeConnRefused)
+}
@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// maxWarnings 每个任务最多记录的警告行数
+const maxWarnings = 20
+
+// errOutputFailure 输出中出现了失败模式
+var errOutputFailure = errors.New("输出匹配失败模式")
+
+// outputCriteria 任务声明的成功/失败判定规则（已编译）
+type outputCriteria struct {
+	successCodes []int
+	fail         []*regexp.Regexp
+	succeed      []*regexp.Regexp
+	warn         []*regexp.Regexp
+}
+
+// compilePatterns 编译一组正则，错误信息中带上字段名方便定位
+func compilePatterns(field string, patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s 中的正则 %q 无效: %w", field, p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// compileCriteria 编译任务的判定规则
+func compileCriteria(task *Task) (*outputCriteria, error) {
+	c := &outputCriteria{successCodes: task.SuccessExitCodes}
+	if len(c.successCodes) == 0 {
+		c.successCodes = []int{0}
+	}
+	var err error
+	if c.fail, err = compilePatterns("FailOnOutput", task.FailOnOutput); err != nil {
+		return nil, err
+	}
+	if c.succeed, err = compilePatterns("SucceedOnOutput", task.SucceedOnOutput); err != nil {
+		return nil, err
+	}
+	if c.warn, err = compilePatterns("WarnOnOutput", task.WarnOnOutput); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// outputMatcher 在输出流上逐行匹配判定规则，每次尝试前需要 reset
+type outputMatcher struct {
+	criteria *outputCriteria
+
+	mu        sync.Mutex
+	failLine  string
+	succeeded bool
+	warnings  []string
+	onFail    func() // 匹配到失败模式时调用，用于提前结束本次执行
+}
+
+func newOutputMatcher(c *outputCriteria) *outputMatcher {
+	return &outputMatcher{criteria: c}
+}
+
+// reset 开始新的一次尝试
+func (m *outputMatcher) reset(onFail func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failLine = ""
+	m.succeeded = false
+	m.warnings = nil
+	m.onFail = onFail
+}
+
+// match 处理一行输出，注册为 taskOutput 的逐行回调
+func (m *outputMatcher) match(prefix, line string) {
+	m.mu.Lock()
+	var onFail func()
+	if m.failLine == "" {
+		for _, re := range m.criteria.fail {
+			if re.MatchString(line) {
+				m.failLine = line
+				onFail = m.onFail
+				break
+			}
+		}
+	}
+	if !m.succeeded {
+		for _, re := range m.criteria.succeed {
+			if re.MatchString(line) {
+				m.succeeded = true
+				break
+			}
+		}
+	}
+	if len(m.warnings) < maxWarnings {
+		for _, re := range m.criteria.warn {
+			if re.MatchString(line) {
+				m.warnings = append(m.warnings, line)
+				break
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	// 失败已成定局，不必等命令自己结束
+	if onFail != nil {
+		onFail()
+	}
+}
+
+// evaluate 结合退出码和输出给出本次尝试的最终判定
+// 返回 nil 表示成功；warnings 非空时任务应标记为警告
+func (m *outputMatcher) evaluate(exitCode int, err error) (warnings []string, _ error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.criteria
+
+	if m.failLine != "" {
+		return nil, fmt.Errorf("%w: %s", errOutputFailure, m.failLine)
+	}
+
+	// 超时、资源限制、启动失败等错误与退出码无关，直接失败；
+	// 只有“进程正常退出但退出码非 0”才参与 SuccessExitCodes 的判断
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		if !slices.Contains(c.successCodes, exitCode) {
+			return nil, fmt.Errorf("退出码 %d 不在允许的列表 %v 中", exitCode, c.successCodes)
+		}
+	case errors.As(err, &exitErr) && exitErr.Exited() && !errors.Is(err, errTaskTimeout):
+		if !slices.Contains(c.successCodes, exitCode) {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if len(c.succeed) > 0 && !m.succeeded {
+		return nil, errors.New("输出中没有出现要求的成功标志")
+	}
+	return slices.Clone(m.warnings), nil
+}
@@ -0,0 +1,432 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 运行的整体状态，见 ControlRun
+const (
+	RunRunning   = "running"
+	RunSuccess   = "success"
+	RunFailed    = "failed"
+	RunCancelled = "cancelled"
+)
+
+// ControlRun 守护进程中一次运行的状态，ps 命令返回它的列表，run / wait 结束时返回它
+type ControlRun struct {
+	ID        string    `json:"id"`
+	Pipeline  string    `json:"pipeline"`
+	Status    string    `json:"status"` // running、success、failed 或 cancelled
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time,omitzero"`
+	Total     int       `json:"total"`
+	Finished  int       `json:"finished"`
+	Failed    int       `json:"failed"`
+	Percent   float64   `json:"percent"` // 见 Progress
+	ETAMs     int64     `json:"eta_ms"`
+}
+
+// Daemon 常驻的调度服务：通过控制 socket 接收流水线文件，每次运行创建一个独立的调度器
+//
+// 结束的运行仍保留在内存中，可以继续查询状态、查看输出、重试单个任务，
+// 超过 Keep 个时最早结束的运行被清理
+type Daemon struct {
+	Keep       int         // 最多保留多少次运行，默认 20
+	SocketMode os.FileMode // 控制 socket 的权限，默认 0600 只允许同一用户访问
+
+	opts   []Option
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	runs   []*daemonRun // 按提交顺序
+	freeze FreezeState  // 全局冻结，对所有运行生效，包括之后提交的
+}
+
+// daemonRun 守护进程中的一次运行
+type daemonRun struct {
+	pipeline  string
+	s         *Scheduler
+	startTime time.Time
+	finished  chan struct{} // 所有任务结束或运行被取消后关闭
+
+	mu        sync.Mutex
+	endTime   time.Time
+	cancelled bool
+	stopped   bool // 调度器已经停止，不能再重试任务
+}
+
+// NewDaemon 创建守护进程，opts 用于每次运行创建的调度器
+func NewDaemon(opts ...Option) *Daemon {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Daemon{
+		Keep:       20,
+		SocketMode: 0o600,
+		opts:       opts,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// DefaultDaemonSocket 守护进程默认的控制 socket：$XDG_RUNTIME_DIR/shelld.sock，
+// 没有设置 XDG_RUNTIME_DIR 时放在临时目录下按用户区分的子目录中
+func DefaultDaemonSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "shelld.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("shelld-%d", os.Getuid()), "shelld.sock")
+}
+
+// Serve 在 path 上监听控制 socket，目录不存在时创建为只有当前用户可以访问
+func (d *Daemon) Serve(path string) (*ControlServer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return serveControl(path, d.SocketMode, d.handleControl)
+}
+
+// Submit 读取流水线文件并开始一次新的运行，返回运行 ID
+func (d *Daemon) Submit(path string) (string, error) {
+	if d.ctx.Err() != nil {
+		return "", errors.New("守护进程正在退出")
+	}
+	p, err := LoadPipelineFile(path)
+	if err != nil {
+		return "", err
+	}
+	s := NewScheduler(d.opts...)
+	s.SetName(p.Name)
+	if err := s.AddPipeline(p); err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	freeze := d.freeze
+	d.mu.Unlock()
+	if freeze.Frozen {
+		s.Freeze(freeze.Reason, freeze.By)
+	}
+	if err := s.Start(d.ctx); err != nil {
+		return "", err
+	}
+	run := &daemonRun{
+		pipeline:  path,
+		s:         s,
+		startTime: time.Now(),
+		finished:  make(chan struct{}),
+	}
+	go d.watchRun(run)
+
+	d.mu.Lock()
+	d.runs = append(d.runs, run)
+	evicted := d.evictLocked()
+	d.mu.Unlock()
+	for _, old := range evicted {
+		old.stop()
+	}
+	slog.Info("开始运行流水线", logKeyRunID, s.RunID(), "pipeline", path)
+	return s.RunID(), nil
+}
+
+// evictLocked 运行数超过 Keep 时，从最早的开始移除已经结束的运行，调用方需要持有 d.mu
+func (d *Daemon) evictLocked() []*daemonRun {
+	var evicted []*daemonRun
+	kept := d.runs[:0]
+	excess := len(d.runs) - max(d.Keep, 1)
+	for _, run := range d.runs {
+		if excess > 0 && run.done() {
+			evicted = append(evicted, run)
+			excess--
+			continue
+		}
+		kept = append(kept, run)
+	}
+	d.runs = kept
+	return evicted
+}
+
+// watchRun 等待运行结束；被取消的运行立即停止调度器，正常结束的运行保留调度器以便重试任务
+func (d *Daemon) watchRun(run *daemonRun) {
+	select {
+	case <-run.s.Done():
+		if err := run.s.SaveHistory(); err != nil {
+			slog.Error("保存历史耗时失败", logKeyRunID, run.s.RunID(), "error", err)
+		}
+	case <-run.s.Aborted():
+		run.mu.Lock()
+		run.cancelled = true
+		run.mu.Unlock()
+		run.stop()
+	}
+	run.mu.Lock()
+	run.endTime = time.Now()
+	run.mu.Unlock()
+	close(run.finished)
+
+	status := run.status()
+	slog.Info("流水线运行结束", logKeyRunID, run.s.RunID(), logKeyStatus, status.Status, "failed", status.Failed)
+
+	// 提交时超出的部分可能还没有结束，现在再清理一次
+	d.mu.Lock()
+	evicted := d.evictLocked()
+	d.mu.Unlock()
+	for _, old := range evicted {
+		old.stop()
+	}
+}
+
+// done 运行是否已经结束
+func (run *daemonRun) done() bool {
+	select {
+	case <-run.finished:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop 停止运行的调度器，只执行一次
+func (run *daemonRun) stop() {
+	run.mu.Lock()
+	if run.stopped {
+		run.mu.Unlock()
+		return
+	}
+	run.stopped = true
+	run.mu.Unlock()
+	run.s.Stop()
+}
+
+// retryTask 重试运行中的一个任务，调度器已经停止时返回错误
+func (run *daemonRun) retryTask(id string) error {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.stopped {
+		return errors.New("运行已经停止，不能重试单个任务，可以重试整个运行")
+	}
+	return run.s.RetryTask(id)
+}
+
+// status 根据任务的当前状态汇总运行状态，结束后重试的任务也会反映在其中
+func (run *daemonRun) status() ControlRun {
+	states := run.s.Snapshot()
+	progress := run.s.Progress()
+	run.mu.Lock()
+	cr := ControlRun{
+		ID:        run.s.RunID(),
+		Pipeline:  run.pipeline,
+		Status:    RunSuccess,
+		StartTime: run.startTime,
+		EndTime:   run.endTime,
+		Total:     len(states),
+		Percent:   progress.Percent,
+		ETAMs:     progress.ETAMs,
+	}
+	cancelled := run.cancelled
+	run.mu.Unlock()
+
+	for _, st := range states {
+		if !st.Status.Finished() {
+			continue
+		}
+		cr.Finished++
+		if !st.Status.Succeeded() && st.Status != StatusSkipped {
+			cr.Failed++
+		}
+	}
+	switch {
+	case cancelled:
+		cr.Status = RunCancelled
+	case !run.done() || cr.Finished < cr.Total:
+		cr.Status = RunRunning
+	case cr.Failed > 0:
+		cr.Status = RunFailed
+	}
+	return cr
+}
+
+// findRun 按 ID 查找运行，id 为空时返回最近提交的运行
+func (d *Daemon) findRun(id string) (*daemonRun, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id == "" {
+		if len(d.runs) == 0 {
+			return nil, errors.New("还没有任何运行")
+		}
+		return d.runs[len(d.runs)-1], nil
+	}
+	for _, run := range d.runs {
+		if run.s.RunID() == id {
+			return run, nil
+		}
+	}
+	return nil, fmt.Errorf("运行 %s 不存在", id)
+}
+
+// Runs 返回所有保留的运行，按提交顺序
+func (d *Daemon) Runs() []ControlRun {
+	d.mu.Lock()
+	runs := append([]*daemonRun(nil), d.runs...)
+	d.mu.Unlock()
+	result := make([]ControlRun, 0, len(runs))
+	for _, run := range runs {
+		result = append(result, run.status())
+	}
+	return result
+}
+
+// Freeze 对所有保留的运行和之后提交的运行开启全局冻结
+func (d *Daemon) Freeze(reason, by string) FreezeState {
+	d.mu.Lock()
+	if !d.freeze.Frozen {
+		d.freeze = FreezeState{Frozen: true, Reason: reason, By: by, Since: time.Now()}
+	}
+	state := d.freeze
+	runs := append([]*daemonRun(nil), d.runs...)
+	d.mu.Unlock()
+	for _, run := range runs {
+		run.s.Freeze(state.Reason, state.By)
+	}
+	return state
+}
+
+// Unfreeze 解除全局冻结，各运行中等待的任务重新检查时间窗口
+func (d *Daemon) Unfreeze(by string) FreezeState {
+	d.mu.Lock()
+	d.freeze = FreezeState{}
+	runs := append([]*daemonRun(nil), d.runs...)
+	d.mu.Unlock()
+	for _, run := range runs {
+		run.s.Unfreeze(by)
+	}
+	return FreezeState{}
+}
+
+// FreezeState 返回守护进程的冻结状态
+func (d *Daemon) FreezeState() FreezeState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.freeze
+}
+
+// Close 取消所有还在执行的运行并停止全部调度器
+func (d *Daemon) Close() {
+	d.cancel()
+	d.mu.Lock()
+	runs := d.runs
+	d.runs = nil
+	d.mu.Unlock()
+	for _, run := range runs {
+		run.s.Abort("守护进程退出")
+		run.stop()
+	}
+}
+
+// handleControl 守护进程的控制命令：run、ps、wait 和冻结相关的命令由守护进程处理，
+// 其他命令（logs、cancel、retry 等）转给 req.Run 指定的运行
+func (d *Daemon) handleControl(ctx context.Context, req ControlRequest, send func(any) error) (any, error) {
+	switch req.Command {
+	case "run":
+		if !filepath.IsAbs(req.Pipeline) {
+			return nil, errors.New("流水线文件需要是绝对路径")
+		}
+		return d.submit(ctx, req.Pipeline, req.Follow, send)
+	case "ps":
+		if req.Run == "" {
+			return d.Runs(), nil
+		}
+		run, err := d.findRun(req.Run)
+		if err != nil {
+			return nil, err
+		}
+		return run.s.handleControl(ctx, ControlRequest{Command: "tasks"}, send)
+	case "freeze", "unfreeze":
+		by, err := req.identity()
+		if err != nil {
+			return nil, err
+		}
+		if req.Command == "freeze" {
+			slog.Warn("通过控制 socket 开启全局冻结", "reason", req.Reason, "by", by)
+			return d.Freeze(req.Reason, by), nil
+		}
+		slog.Info("通过控制 socket 解除全局冻结", "by", by)
+		return d.Unfreeze(by), nil
+	case "freeze-status":
+		return d.FreezeState(), nil
+	}
+
+	run, err := d.findRun(req.Run)
+	if err != nil {
+		return nil, err
+	}
+	switch req.Command {
+	case "wait":
+		return d.follow(ctx, run, send)
+	case "retry":
+		if req.Task == "" {
+			// 重试整个运行：重新读取流水线文件，作为一次新的运行提交
+			return d.submit(ctx, run.pipeline, req.Follow, send)
+		}
+		if err := run.retryTask(req.Task); err != nil {
+			return nil, err
+		}
+		slog.Info("通过控制 socket 重试任务", logKeyRunID, run.s.RunID(), logKeyTaskID, req.Task, "by", req.By)
+		if !req.Follow {
+			return nil, nil
+		}
+		return run.s.followTask(ctx, req.Task, nil)
+	default:
+		return run.s.handleControl(ctx, req, send)
+	}
+}
+
+// submit 提交一次运行，follow 时等待运行结束
+func (d *Daemon) submit(ctx context.Context, path string, follow bool, send func(any) error) (any, error) {
+	id, err := d.Submit(path)
+	if err != nil {
+		return nil, err
+	}
+	run, err := d.findRun(id)
+	if err != nil {
+		return nil, err
+	}
+	if !follow {
+		return run.status(), nil
+	}
+	return d.follow(ctx, run, send)
+}
+
+// follow 每个任务结束时发送它的状态，直到运行结束，最后返回运行的状态
+func (d *Daemon) follow(ctx context.Context, run *daemonRun, send func(any) error) (any, error) {
+	ticker := time.NewTicker(controlPollInterval)
+	defer ticker.Stop()
+	reported := make(map[string]string)
+	for {
+		// 先判断是否结束再发送，保证结束前的最后一批任务也会发出去
+		finished := run.done()
+		for _, st := range run.s.Snapshot() {
+			if !st.Status.Finished() || reported[st.ID] == st.Status.Code() {
+				continue
+			}
+			reported[st.ID] = st.Status.Code()
+			if err := send(newControlTask(st)); err != nil {
+				return nil, err
+			}
+		}
+		if finished {
+			return run.status(), nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-run.finished:
+		case <-ticker.C:
+		}
+	}
+}
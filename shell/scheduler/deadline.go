@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// 超过总时长限制时的原因，记录在 TaskResult.FailureReason 中
+const (
+	FailureTotalTimeout = "total_timeout" // 任务所有重试加起来超过了 Task.TotalTimeout
+	FailureRunDeadline  = "run_deadline"  // 整个运行超过了截止时间
+)
+
+// SetRunTimeout 设置整个运行的时长限制，从 Start 开始计时，需要在 Start 之前调用
+// 到期后正在执行的任务被终止、不再重试，还没开始的任务直接记为超时
+func (s *Scheduler) SetRunTimeout(d time.Duration) {
+	s.runTimeout = d
+}
+
+// startRunDeadline 在 Start 时创建带截止时间的运行 ctx，所有任务的 ctx 都从它派生
+func (s *Scheduler) startRunDeadline() {
+	if s.runTimeout <= 0 {
+		return
+	}
+	s.runCtx, s.runCancel = s.clock.WithTimeout(s.ctx, s.runTimeout)
+	go func() {
+		<-s.runCtx.Done()
+		if s.runCtx.Err() == context.DeadlineExceeded {
+			s.logger.Error("整个运行超过截止时间，停止执行剩余任务", "run_timeout", s.runTimeout)
+		}
+	}()
+}
+
+// runDeadlineExceeded 整个运行是否已经超过截止时间（调度器被主动停止不算）
+func (s *Scheduler) runDeadlineExceeded() bool {
+	return s.runCtx.Err() == context.DeadlineExceeded
+}
+
+// deadlineError 任务的总时长或整个运行的截止时间已到时，返回失败原因和错误
+// 两者都没到（包括调度器被主动停止）时返回空
+func (s *Scheduler) deadlineError(taskCtx context.Context, task *Task) (string, error) {
+	switch {
+	case taskCtx.Err() == nil:
+		return "", nil
+	case s.runDeadlineExceeded():
+		return FailureRunDeadline, fmt.Errorf("%w(整个运行限时: %v)", errTaskTimeout, s.runTimeout)
+	case taskCtx.Err() == context.DeadlineExceeded:
+		return FailureTotalTimeout, fmt.Errorf("%w(总限时: %v)", errTaskTimeout, task.TotalTimeout)
+	default:
+		return "", nil
+	}
+}
+
+// takeDeadlineResult worker 取出任务时，如果整个运行已经超过截止时间，直接记为超时而不执行
+func (s *Scheduler) takeDeadlineResult(task *Task) *TaskResult {
+	if !s.runDeadlineExceeded() {
+		return nil
+	}
+	now := s.clock.Now()
+	return &TaskResult{
+		TaskID:        task.ID,
+		TaskName:      task.Name,
+		Status:        StatusTimeout,
+		StartTime:     now,
+		EndTime:       now,
+		FailureReason: FailureRunDeadline,
+		Error:         fmt.Errorf("%w(整个运行限时: %v，任务没有开始)", errTaskTimeout, s.runTimeout),
+	}
+}
@@ -0,0 +1,329 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内置执行器的名称，Task.Executor 为空时使用 shell
+const (
+	ExecutorShell = "shell"
+	ExecutorHTTP  = "http"
+	ExecutorFunc  = "func"
+)
+
+// Executor 任务执行器
+//
+// 调度器只关心“执行一次任务”这件事：给定超时的 ctx，把输出写到 stdout/stderr，
+// 返回退出码和错误。重试、超时判定、缓存、结果记录都由调度器统一处理，
+// 执行器不需要、也不应该关心这些。
+type Executor interface {
+	Execute(ctx context.Context, task *Task, stdout, stderr io.Writer) (int, error)
+}
+
+// RegisterExecutor 注册（或替换）一个执行器
+func (s *Scheduler) RegisterExecutor(name string, executor Executor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.executors[name] = executor
+}
+
+// executorFor 根据任务声明的类型找到执行器
+func (s *Scheduler) executorFor(task *Task) (Executor, error) {
+	name := task.Executor
+	if name == "" {
+		name = ExecutorShell
+	}
+	s.mu.Lock()
+	executor, ok := s.executors[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("任务 %s 使用了未注册的执行器 %q", task.ID, name)
+	}
+	return executor, nil
+}
+
+// errTaskTimeout 单次执行超时，executeTask 据此把任务标记为超时而不是普通失败
+var errTaskTimeout = errors.New("任务执行超时")
+
+// errTaskCancelled 任务被手动取消
+var errTaskCancelled = errors.New("任务被手动取消")
+
+// errRunStopped 任务因为调度器停止而被中断
+var errRunStopped = errors.New("调度器已停止，任务被中断")
+
+// runAttempt 执行一次任务（不含重试），taskCtx 带有任务总时长和整个运行的截止时间
+// 输出匹配到失败模式时，matcher 会取消本次执行的 ctx，命令被提前结束
+func (s *Scheduler) runAttempt(taskCtx context.Context, task *Task, output *taskOutput, matcher *outputMatcher, rt *runningTask) (int, error) {
+	executor, err := s.executorFor(task)
+	if err != nil {
+		return -1, err
+	}
+
+	ctx, cancel := s.clock.WithTimeout(taskCtx, task.Timeout)
+	defer cancel()
+	matcher.reset(cancel)
+	s.setCancel(rt, cancel)
+
+	stdout, stderr := output.Stream("STDOUT"), output.Stream("STDERR")
+	t, err := s.withRunEnv(task)
+	if err != nil {
+		return -1, err
+	}
+	exitCode, err := executor.Execute(ctx, t, stdout, stderr)
+	stdout.Flush()
+	stderr.Flush()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return exitCode, fmt.Errorf("%w(限时: %v)", errTaskTimeout, task.Timeout)
+	}
+	return exitCode, err
+}
+
+// taskOutput 收集一次执行的输出
+// stdout 和 stderr 由不同的 goroutine 写入，所以缓冲区需要加锁
+type taskOutput struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	logger  *slog.Logger
+	secrets *SecretStore                // 写入前遮盖秘密
+	hooks   []func(prefix, line string) // 每收到一行输出都会调用，用于日志探针等实时匹配
+}
+
+// newTaskOutput logger 需要已经带上 task_id 等属性，secrets 可以为 nil
+func newTaskOutput(logger *slog.Logger, secrets *SecretStore) *taskOutput {
+	return &taskOutput{logger: logger, secrets: secrets}
+}
+
+// OnLine 注册一个逐行回调，需要在开始写入之前注册
+func (o *taskOutput) OnLine(fn func(prefix, line string)) {
+	o.hooks = append(o.hooks, fn)
+}
+
+// Stream 返回一个按行写入的 writer，prefix 用于区分实时日志中的来源
+func (o *taskOutput) Stream(prefix string) *lineWriter {
+	return &lineWriter{out: o, prefix: prefix}
+}
+
+// writeLine 记录一行输出，并作为一条单独的 DEBUG 日志输出
+func (o *taskOutput) writeLine(prefix, line string) {
+	line = o.secrets.Mask(line)
+	o.mu.Lock()
+	o.buf.WriteString(line)
+	o.buf.WriteByte('\n')
+	o.mu.Unlock()
+	o.logger.Debug("任务输出", logKeyStream, strings.ToLower(prefix), logKeyLine, line)
+	for _, fn := range o.hooks {
+		fn(prefix, line)
+	}
+}
+
+func (o *taskOutput) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf.Reset()
+}
+
+func (o *taskOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// lineWriter 把任意分块的写入整理成完整的行
+// 最后一行如果没有换行符，会保留在 partial 中，直到 Flush
+type lineWriter struct {
+	out     *taskOutput
+	prefix  string
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	data := append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		w.out.writeLine(w.prefix, strings.TrimSuffix(string(data[:i]), "\r"))
+		data = data[i+1:]
+	}
+	w.partial = append(w.partial[:0], data...)
+	return len(p), nil
+}
+
+// Flush 输出最后不完整的一行
+func (w *lineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.out.writeLine(w.prefix, string(w.partial))
+		w.partial = w.partial[:0]
+	}
+}
+
+// ShellExecutor 通过 exec.Command 执行命令，也是调度器最初唯一的执行方式
+// 有 Script 时写入临时文件交给解释器执行；有 Args 时直接执行 Cmd，否则交给 sh -c 解释
+type ShellExecutor struct{}
+
+func (ShellExecutor) Execute(ctx context.Context, task *Task, stdout, stderr io.Writer) (int, error) {
+	// 创建命令
+	var cmd *exec.Cmd
+	switch {
+	case task.Script != "":
+		name, args, cleanup, err := scriptCommand(task)
+		if err != nil {
+			return -1, err
+		}
+		defer cleanup()
+		cmd = exec.CommandContext(ctx, name, args...)
+	case len(task.Args) > 0:
+		cmd = exec.CommandContext(ctx, task.Cmd, task.Args...)
+	default:
+		cmd = exec.CommandContext(ctx, "sh", "-c", task.Cmd)
+	}
+
+	// 设置工作目录
+	if task.WorkDir != "" {
+		cmd.Dir = task.WorkDir
+	}
+
+	// 设置环境变量
+	if len(task.Env) > 0 {
+		cmd.Env = append(os.Environ(), task.Env...)
+	}
+
+	// 设置输出
+	// 不是 *os.File 的 writer 会由 exec 包自动创建管道并拷贝，Wait 会等待拷贝结束。
+	// 子进程被杀掉后，如果孙进程还持有管道，最多再等 WaitDelay 就强制返回
+	cmd.WaitDelay = 5 * time.Second
+	var pty *ptyState
+	if task.TTY {
+		var err error
+		if pty, err = openPTY(cmd, task.TTYSize); err != nil {
+			return -1, err
+		}
+		if !task.KeepANSI {
+			stdout = newANSIStripper(stdout)
+		}
+	} else {
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+	}
+	useProcessGroup(cmd)
+
+	// 资源限制和运行用户
+	limits, err := applyLimits(cmd, task)
+	if err != nil {
+		if pty != nil {
+			pty.close()
+		}
+		return -1, err
+	}
+
+	// 启动并等待命令完成
+	if err := cmd.Start(); err != nil {
+		limits.finish(nil)
+		if pty != nil {
+			pty.close()
+		}
+		return -1, err
+	}
+	limits.started()
+	if pty != nil {
+		pty.started(stdout)
+	}
+	err = cmd.Wait()
+	if pty != nil {
+		pty.finish(cmd.WaitDelay)
+	}
+	if reason := limits.finish(cmd.ProcessState); reason != "" && err != nil {
+		err = &LimitExceededError{Reason: reason, Err: err}
+	}
+	return cmd.ProcessState.ExitCode(), err
+}
+
+// HTTPRequest HTTP 执行器的请求定义
+type HTTPRequest struct {
+	Method       string            // 请求方法，默认 GET
+	URL          string            // 请求地址
+	Headers      map[string]string // 请求头
+	Body         string            // 请求体
+	ExpectStatus []int             // 期望的状态码，为空时接受任意 2xx
+}
+
+// HTTPExecutor 发送一个 HTTP 请求，响应的状态行和响应体作为任务输出
+//
+// 退出码约定：状态码符合预期时为 0，不符合时为响应的状态码，请求本身失败时为 -1
+type HTTPExecutor struct {
+	Client *http.Client
+}
+
+func (e HTTPExecutor) Execute(ctx context.Context, task *Task, stdout, stderr io.Writer) (int, error) {
+	spec := task.HTTP
+	if spec == nil {
+		return -1, fmt.Errorf("任务 %s 没有定义 HTTP 请求", task.ID)
+	}
+	method := spec.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, spec.URL, strings.NewReader(spec.Body))
+	if err != nil {
+		return -1, err
+	}
+	for k, v := range spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	fmt.Fprintf(stdout, "%s %s\n", resp.Proto, resp.Status)
+	if _, err := io.Copy(stdout, resp.Body); err != nil {
+		return -1, fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	expected := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if len(spec.ExpectStatus) > 0 {
+		expected = slices.Contains(spec.ExpectStatus, resp.StatusCode)
+	}
+	if !expected {
+		return resp.StatusCode, fmt.Errorf("HTTP 状态码 %d 不符合预期", resp.StatusCode)
+	}
+	return 0, nil
+}
+
+// TaskFunc 在进程内执行的任务函数，写入 output 的内容会成为任务输出
+type TaskFunc func(ctx context.Context, output io.Writer) error
+
+// FuncExecutor 直接在调度器进程内调用 Task.Func
+// 不需要启动任何进程，适合测试调度逻辑或者执行纯 Go 的步骤
+type FuncExecutor struct{}
+
+func (FuncExecutor) Execute(ctx context.Context, task *Task, stdout, stderr io.Writer) (int, error) {
+	if task.Func == nil {
+		return -1, fmt.Errorf("任务 %s 没有定义 Func", task.ID)
+	}
+	if err := task.Func(ctx, stdout); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,282 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// GateTimeoutAction 审批超时后的处理方式
+type GateTimeoutAction string
+
+const (
+	// GateTimeoutFail 超时视为失败，依赖它的任务不会执行（默认）
+	GateTimeoutFail GateTimeoutAction = "fail"
+	// GateTimeoutApprove 超时自动通过
+	GateTimeoutApprove GateTimeoutAction = "approve"
+)
+
+// 审批没有通过时的原因，记录在 TaskResult.FailureReason 中
+const (
+	FailureRejected        = "rejected"
+	FailureApprovalTimeout = "approval_timeout"
+)
+
+// Gate 人工审批节点的配置
+//
+// 审批节点不执行任何命令，只是等待有人通过或拒绝：
+// 通过后依赖它的任务才会执行；拒绝或超时失败时，依赖它的任务全部取消
+type Gate struct {
+	Message   string            // 提示审批人的说明，例如“确认发布到生产环境”
+	Timeout   time.Duration     // 等待审批的最长时间，为 0 时一直等待
+	OnTimeout GateTimeoutAction // 超时后的处理方式，默认 fail
+}
+
+// Approval 一次审批的结果
+type Approval struct {
+	Approved bool      `json:"approved"`
+	Approver string    `json:"approver"`
+	Comment  string    `json:"comment,omitempty"`
+	Time     time.Time `json:"time"`
+	Auto     bool      `json:"auto,omitempty"` // 由超时策略自动做出的决定
+}
+
+// pendingGate 一个正在等待审批的节点
+type pendingGate struct {
+	task     *Task
+	since    time.Time
+	decision chan *Approval // 容量为 1，只接受第一次决定
+}
+
+// GateInfo 等待审批的节点，用于 API 展示
+type GateInfo struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Message string    `json:"message,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+// startGate 登记审批节点并在后台等待决定，worker 立即被释放
+func (s *Scheduler) startGate(workerID int, task *Task) {
+	var gate Gate
+	if task.Gate != nil {
+		gate = *task.Gate
+	}
+	result := &TaskResult{
+		TaskID:    task.ID,
+		TaskName:  task.Name,
+		Status:    StatusWaitingApproval,
+		StartTime: s.clock.Now(),
+	}
+
+	output := newTaskOutput(s.logger.With(logKeyTaskID, task.ID), s.secrets)
+	rt := s.trackRunning(task, output)
+	ctx, cancel := context.WithCancel(s.runCtx)
+	pending := &pendingGate{task: task, since: result.StartTime, decision: make(chan *Approval, 1)}
+	// 手动取消时送一个空的决定唤醒等待，与审批、超时走同一条路径
+	s.setCancel(rt, func() {
+		s.wakeGate(pending, nil)
+		cancel()
+	})
+
+	s.mu.Lock()
+	s.gates[task.ID] = pending
+	s.mu.Unlock()
+
+	s.logger.Warn("等待人工审批", logKeyTaskID, task.ID, logKeyWorker, workerID, "message", gate.Message, "timeout", gate.Timeout)
+
+	s.gateWG.Add(1)
+	go func() {
+		defer s.gateWG.Done()
+		defer cancel()
+
+		if gate.Timeout > 0 {
+			stop := s.clock.AfterFunc(gate.Timeout, func() {
+				s.wakeGate(pending, &Approval{
+					Approved: gate.OnTimeout == GateTimeoutApprove,
+					Approver: "timeout",
+					Comment:  fmt.Sprintf("%v 内没有人审批", gate.Timeout),
+					Time:     s.clock.Now(),
+					Auto:     true,
+				})
+			})
+			defer stop()
+		}
+
+		// 通过 wakeGate 送来的决定计入了 inflight，处理完后扣除
+		var approval *Approval
+		woken := false
+		defer func() {
+			if woken {
+				s.inflight.Add(-1)
+			}
+		}()
+		timeoutReason := FailureApprovalTimeout
+		select {
+		case approval = <-pending.decision:
+			woken = true
+		case <-ctx.Done():
+			select {
+			case approval = <-pending.decision:
+				woken = true
+			default:
+			}
+			if approval == nil && s.runDeadlineExceeded() {
+				timeoutReason = FailureRunDeadline
+				approval = &Approval{
+					Approver: "run_deadline",
+					Comment:  fmt.Sprintf("整个运行超过截止时间 %v，仍没有人审批", s.runTimeout),
+					Time:     s.clock.Now(),
+					Auto:     true,
+				}
+			}
+		}
+
+		s.mu.Lock()
+		cancelled := rt.cancelled
+		if approval == nil && !cancelled {
+			// 调度器停止时仍未审批，不上报结果，保留登记，汇总中显示为“等待审批”
+			s.mu.Unlock()
+			return
+		}
+		delete(s.gates, task.ID)
+		s.mu.Unlock()
+
+		result.EndTime = s.clock.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		result.Approval = approval
+		switch {
+		case approval != nil && approval.Approved:
+			result.Status = StatusSuccess
+			fmt.Fprintf(output.Stream("STDOUT"), "%s 通过审批: %s\n", approval.Approver, approval.Comment)
+		case approval != nil && approval.Auto:
+			result.Status = StatusTimeout
+			result.FailureReason = timeoutReason
+			result.Error = errors.New(approval.Comment)
+		case approval != nil:
+			result.Status = StatusFailed
+			result.FailureReason = FailureRejected
+			result.Error = fmt.Errorf("%s 拒绝了审批: %s", approval.Approver, approval.Comment)
+		default:
+			result.Status = StatusCancelled
+			result.Error = errTaskCancelled
+		}
+		result.Output = output.String()
+		s.metrics.taskFinished(result)
+		s.sendResult(result)
+	}()
+}
+
+// wakeGate 把决定交给等待中的节点，已经有决定时返回 false
+// 决定在被处理之前计入 inflight，模拟器不会在节点处理决定的过程中推进时间
+func (s *Scheduler) wakeGate(pending *pendingGate, approval *Approval) bool {
+	s.inflight.Add(1)
+	select {
+	case pending.decision <- approval:
+		return true
+	default:
+		s.inflight.Add(-1)
+		return false
+	}
+}
+
+// Approve 通过审批
+func (s *Scheduler) Approve(id, approver, comment string) error {
+	return s.decideGate(id, &Approval{Approved: true, Approver: approver, Comment: comment, Time: s.clock.Now()})
+}
+
+// Reject 拒绝审批，依赖该节点的任务都会被取消
+func (s *Scheduler) Reject(id, approver, comment string) error {
+	return s.decideGate(id, &Approval{Approved: false, Approver: approver, Comment: comment, Time: s.clock.Now()})
+}
+
+// decideGate 把审批决定交给等待中的节点
+func (s *Scheduler) decideGate(id string, approval *Approval) error {
+	if approval.Approver == "" {
+		return errors.New("必须提供审批人")
+	}
+	s.mu.Lock()
+	pending, ok := s.gates[id]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("任务 %s 不在等待审批", id)
+	}
+	if !s.wakeGate(pending, approval) {
+		return fmt.Errorf("任务 %s 已经有审批结果", id)
+	}
+	s.logger.Info("收到审批", logKeyTaskID, id, "approved", approval.Approved, "approver", approval.Approver, "comment", approval.Comment)
+	return nil
+}
+
+// PendingGates 返回正在等待审批的节点
+func (s *Scheduler) PendingGates() []GateInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gates := make([]GateInfo, 0, len(s.gates))
+	for id, p := range s.gates {
+		info := GateInfo{ID: id, Name: p.task.Name, Since: p.since}
+		if p.task.Gate != nil {
+			info.Message = p.task.Gate.Message
+		}
+		gates = append(gates, info)
+	}
+	sort.Slice(gates, func(i, j int) bool { return gates[i].ID < gates[j].ID })
+	return gates
+}
+
+// blocksDependents 审批节点没有通过时，依赖它的任务不能执行
+func blocksDependents(task *Task, result *TaskResult) bool {
+	return task.Kind == KindGate && !result.Status.Succeeded()
+}
+
+// cancelDependents 取消所有直接或间接依赖 id 的任务，返回它们的结果
+// 调用方需要持有 s.mu
+func (s *Scheduler) cancelDependents(id string) []*TaskResult {
+	var results []*TaskResult
+	blocked := map[string]bool{id: true}
+	for changed := true; changed; {
+		changed = false
+		for _, task := range s.tasks {
+			if s.scheduled[task.ID] || blocked[task.ID] {
+				continue
+			}
+			for _, dep := range task.Dependencies {
+				if !blocked[dep] {
+					continue
+				}
+				blocked[task.ID] = true
+				s.scheduled[task.ID] = true
+				now := s.clock.Now()
+				result := &TaskResult{
+					TaskID:    task.ID,
+					TaskName:  task.Name,
+					Status:    StatusCancelled,
+					StartTime: now,
+					EndTime:   now,
+					Error:     fmt.Errorf("依赖的任务 %s 没有通过", dep),
+				}
+				s.taskResults[task.ID] = result
+				results = append(results, result)
+				changed = true
+				break
+			}
+		}
+	}
+	return results
+}
+
+// HandleGates 在 mux 上注册只读的审批 API
+//
+//	GET /gates  列出等待审批的节点
+//
+// 通过和拒绝只能经由控制 socket（approve / reject 命令），审批人取自 socket 对端的凭据
+func (s *Scheduler) HandleGates(mux *http.ServeMux) {
+	mux.HandleFunc("GET /gates", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.PendingGates())
+	})
+}
@@ -0,0 +1,190 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ExecutorGit 内置的源码检出执行器
+const ExecutorGit = "git"
+
+// GitCheckout 源码检出步骤的定义
+//
+// 仓库先镜像到 CacheDir 中（之后的运行只做增量 fetch），再从本地镜像检出到 Dir，
+// 成功后 GIT_COMMIT、GIT_BRANCH 会加入之后所有任务的环境变量
+type GitCheckout struct {
+	Repo     string // 仓库地址，可以是远程 URL，也可以是本地仓库或裸仓库的路径
+	Ref      string // 分支或标签，默认为仓库的默认分支
+	Commit   string // 指定提交，设置后优先于 Ref，Ref 只用于 GIT_BRANCH
+	Dir      string // 检出目录，相对路径基于 $WORKSPACE（没有工作区时基于任务工作目录），默认 src
+	CacheDir string // 镜像缓存目录，默认 .shell-cache/git
+}
+
+// GitExecutor 执行 Task.Git 定义的检出步骤
+type GitExecutor struct {
+	scheduler *Scheduler
+}
+
+func (e *GitExecutor) Execute(ctx context.Context, task *Task, stdout, stderr io.Writer) (int, error) {
+	spec := task.Git
+	if spec == nil || spec.Repo == "" {
+		return -1, fmt.Errorf("任务 %s 没有定义 Git 仓库", task.ID)
+	}
+	g := &gitRunner{ctx: ctx, stdout: stdout, stderr: stderr}
+
+	mirror, err := g.updateMirror(spec)
+	if err != nil {
+		return g.exitCode(err), err
+	}
+
+	// 确定要检出的提交和分支
+	ref := spec.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	rev := ref
+	if spec.Commit != "" {
+		rev = spec.Commit
+	}
+	commit, err := g.output(mirror, "rev-parse", "--verify", rev+"^{commit}")
+	if err != nil {
+		return g.exitCode(err), fmt.Errorf("找不到 %s: %w", rev, err)
+	}
+	var branch string
+	if spec.Ref != "" || spec.Commit == "" {
+		var full string
+		if ref == "HEAD" {
+			full, _ = g.output(mirror, "symbolic-ref", "HEAD")
+		} else {
+			full, _ = g.output(mirror, "rev-parse", "--symbolic-full-name", ref)
+		}
+		branch, _ = strings.CutPrefix(full, "refs/heads/")
+		if branch == full {
+			branch = "" // 标签或其他引用
+		}
+	}
+
+	dir := spec.Dir
+	if dir == "" {
+		dir = "src"
+	}
+	if !filepath.IsAbs(dir) {
+		base := task.WorkDir
+		if ws := e.scheduler.workspace; ws != nil {
+			base = ws.Dir
+		}
+		dir = filepath.Join(base, dir)
+	}
+	if err := g.checkout(mirror, dir, commit); err != nil {
+		return g.exitCode(err), err
+	}
+
+	fmt.Fprintf(stdout, "已检出 %s %s@%s 到 %s\n", spec.Repo, branch, commit, dir)
+	e.scheduler.setRunEnv("GIT_COMMIT", commit)
+	e.scheduler.setRunEnv("GIT_BRANCH", branch)
+	return 0, nil
+}
+
+// gitRunner 执行 git 命令，命令行和错误输出写入任务输出
+type gitRunner struct {
+	ctx            context.Context
+	stdout, stderr io.Writer
+}
+
+// run 执行 git 命令，标准输出也写入任务输出
+func (g *gitRunner) run(dir string, args ...string) error {
+	return g.exec(dir, g.stdout, args...)
+}
+
+// output 执行 git 命令并返回去掉首尾空白的标准输出
+func (g *gitRunner) output(dir string, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := g.exec(dir, &out, args...)
+	return strings.TrimSpace(out.String()), err
+}
+
+func (g *gitRunner) exec(dir string, stdout io.Writer, args ...string) error {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	fmt.Fprintf(g.stdout, "$ git %s\n", strings.Join(args, " "))
+	cmd := exec.CommandContext(g.ctx, "git", args...)
+	// 不允许交互式地询问凭据，否则任务会一直挂起
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdout = stdout
+	cmd.Stderr = g.stderr
+	return cmd.Run()
+}
+
+// exitCode git 失败时的退出码，没有启动成功时为 -1
+func (g *gitRunner) exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// updateMirror 创建或更新仓库在缓存目录中的镜像，返回镜像的路径
+func (g *gitRunner) updateMirror(spec *GitCheckout) (string, error) {
+	cacheDir := spec.CacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(".shell-cache", "git")
+	}
+	cacheDir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return "", err
+	}
+
+	// 本地仓库使用绝对路径，镜像的 origin 不受工作目录影响
+	repo := spec.Repo
+	if _, err := os.Stat(repo); err == nil {
+		if repo, err = filepath.Abs(repo); err != nil {
+			return "", err
+		}
+	}
+	mirror := filepath.Join(cacheDir, fmt.Sprintf("%x", sha256.Sum256([]byte(repo)))[:16]+".git")
+
+	if _, err := os.Stat(filepath.Join(mirror, "HEAD")); err == nil {
+		return mirror, g.run(mirror, "fetch", "--prune", "--tags", "origin")
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", err
+	}
+	// 先克隆到临时目录，中途失败不会留下残缺的镜像
+	tmp, err := os.MkdirTemp(cacheDir, "clone-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := g.run("", "clone", "--mirror", "--quiet", repo, tmp); err != nil {
+		return "", err
+	}
+	return mirror, os.Rename(tmp, mirror)
+}
+
+// checkout 把提交检出到 dir：已经是仓库时更新，否则从镜像克隆
+func (g *gitRunner) checkout(mirror, dir, commit string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		if err := g.run(dir, "fetch", "--prune", "--quiet", mirror, "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"); err != nil {
+			return err
+		}
+		if err := g.run(dir, "checkout", "--force", "--quiet", "--detach", commit); err != nil {
+			return err
+		}
+		// 清掉上一次留下的未跟踪文件，保证和提交的内容完全一致
+		return g.run(dir, "clean", "-ffdxq")
+	}
+	if err := g.run("", "clone", "--no-checkout", "--quiet", mirror, dir); err != nil {
+		return err
+	}
+	return g.run(dir, "checkout", "--quiet", "--detach", commit)
+}
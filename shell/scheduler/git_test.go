@@ -0,0 +1,219 @@
+package scheduler
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRepo 本地的裸仓库和用来提交的工作副本
+type testRepo struct {
+	t    *testing.T
+	bare string
+	work string
+}
+
+// newTestRepo 创建裸仓库，默认分支为 main
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("没有安装 git")
+	}
+	dir := t.TempDir()
+	r := &testRepo{t: t, bare: filepath.Join(dir, "repo.git"), work: filepath.Join(dir, "work")}
+	r.git("", "init", "--quiet", "--bare", "--initial-branch=main", r.bare)
+	r.git("", "clone", "--quiet", r.bare, r.work)
+	r.git(r.work, "checkout", "--quiet", "-B", "main")
+	return r
+}
+
+// git 执行 git 命令并返回去掉首尾空白的输出
+func (r *testRepo) git(dir string, args ...string) string {
+	r.t.Helper()
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit 在 branch 上提交 version 文件并推送，返回提交号
+func (r *testRepo) commit(branch, content string) string {
+	r.t.Helper()
+	r.git(r.work, "checkout", "--quiet", "-B", branch)
+	if err := os.WriteFile(filepath.Join(r.work, "version"), []byte(content), 0o644); err != nil {
+		r.t.Fatal(err)
+	}
+	r.git(r.work, "add", "version")
+	r.git(r.work, "commit", "--quiet", "-m", content)
+	r.git(r.work, "push", "--quiet", "origin", branch)
+	return r.git(r.work, "rev-parse", "HEAD")
+}
+
+// runCheckout 运行一个检出任务和一个打印 GIT_COMMIT、GIT_BRANCH 的任务
+func runCheckout(t *testing.T, spec *GitCheckout) map[string]*TaskResult {
+	t.Helper()
+	s := newTestScheduler(WithMaxWorkers(1))
+	s.AddTasks(
+		&Task{ID: "checkout", Executor: ExecutorGit, Git: spec},
+		&Task{ID: "env", Cmd: "sh", Args: []string{"-c", `echo "commit=$GIT_COMMIT branch=$GIT_BRANCH"`}, Dependencies: []string{"checkout"}},
+	)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(time.Minute):
+		t.Fatal("运行没有结束")
+	}
+	s.Stop()
+	return s.GetResults()
+}
+
+func TestGitExecutor(t *testing.T) {
+	repo := newTestRepo(t)
+	first := repo.commit("main", "v1")
+	repo.git(repo.work, "tag", "v1")
+	repo.git(repo.work, "push", "--quiet", "origin", "v1")
+	second := repo.commit("main", "v2")
+	feature := repo.commit("feature", "feature")
+
+	tests := []struct {
+		name        string
+		ref         string
+		commit      string
+		wantFail    bool
+		wantCommit  string
+		wantBranch  string
+		wantContent string
+	}{
+		{name: "默认分支", wantCommit: second, wantBranch: "main", wantContent: "v2"},
+		{name: "指定分支", ref: "feature", wantCommit: feature, wantBranch: "feature", wantContent: "feature"},
+		{name: "标签", ref: "v1", wantCommit: first, wantBranch: "", wantContent: "v1"},
+		{name: "指定提交", ref: "main", commit: first, wantCommit: first, wantBranch: "main", wantContent: "v1"},
+		{name: "不存在的分支", ref: "missing", wantFail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			results := runCheckout(t, &GitCheckout{
+				Repo:     repo.bare,
+				Ref:      tt.ref,
+				Commit:   tt.commit,
+				Dir:      filepath.Join(dir, "src"),
+				CacheDir: filepath.Join(dir, "cache"),
+			})
+			checkout := results["checkout"]
+			if tt.wantFail {
+				if checkout.Status != StatusFailed {
+					t.Errorf("状态 = %s，期望 %s", checkout.Status, StatusFailed)
+				}
+				return
+			}
+			if checkout.Status != StatusSuccess {
+				t.Fatalf("检出失败: %v\n%s", checkout.Error, checkout.Output)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "src", "version"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.wantContent {
+				t.Errorf("检出的内容 = %q，期望 %q", data, tt.wantContent)
+			}
+			want := "commit=" + tt.wantCommit + " branch=" + tt.wantBranch
+			if got := strings.TrimSpace(results["env"].Output); !strings.Contains(got, want) {
+				t.Errorf("之后任务的环境变量 = %q，期望包含 %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGitExecutorUpdatesExistingCheckout(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.commit("main", "v1")
+	dir := t.TempDir()
+	spec := &GitCheckout{Repo: repo.bare, Dir: filepath.Join(dir, "src"), CacheDir: filepath.Join(dir, "cache")}
+	if r := runCheckout(t, spec)["checkout"]; r.Status != StatusSuccess {
+		t.Fatalf("第一次检出失败: %v\n%s", r.Error, r.Output)
+	}
+
+	// 上一次运行留下的未跟踪文件和修改都应该被清掉
+	stray := filepath.Join(dir, "src", "stray.txt")
+	os.WriteFile(stray, []byte("x"), 0o644)
+	os.WriteFile(filepath.Join(dir, "src", "version"), []byte("modified"), 0o644)
+	second := repo.commit("main", "v2")
+
+	results := runCheckout(t, spec)
+	if r := results["checkout"]; r.Status != StatusSuccess {
+		t.Fatalf("第二次检出失败: %v\n%s", r.Error, r.Output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "src", "version")); string(data) != "v2" {
+		t.Errorf("检出的内容 = %q，期望 v2", data)
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("未跟踪的文件没有被清掉: %v", err)
+	}
+	if got := results["env"].Output; !strings.Contains(got, "commit="+second) {
+		t.Errorf("GIT_COMMIT 不是最新的提交: %q", got)
+	}
+}
+
+func TestGitCommitInvalidatesCache(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.commit("main", "v1")
+	dir := t.TempDir()
+	// 输入文件不在检出目录中，只有 GIT_COMMIT 会变化
+	input := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(input, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewTaskCache(filepath.Join(dir, "task-cache"))
+	run := func() *TaskResult {
+		t.Helper()
+		s := newTestScheduler(WithMaxWorkers(1), WithCache(cache))
+		s.AddTasks(
+			&Task{ID: "checkout", Executor: ExecutorGit, Git: &GitCheckout{
+				Repo: repo.bare, Dir: filepath.Join(dir, "src"), CacheDir: filepath.Join(dir, "cache"),
+			}},
+			&Task{ID: "build", Cmd: "sh", Args: []string{"-c", `echo "$GIT_COMMIT"`}, Inputs: []string{input}, Dependencies: []string{"checkout"}},
+		)
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-s.Done():
+		case <-time.After(time.Minute):
+			t.Fatal("运行没有结束")
+		}
+		s.Stop()
+		return s.GetResults()["build"]
+	}
+
+	if r := run(); r.Status != StatusSuccess {
+		t.Fatalf("第一次运行 = %s，期望 %s", r.Status, StatusSuccess)
+	}
+	if r := run(); r.Status != StatusCached {
+		t.Errorf("提交没有变化时 = %s，期望 %s", r.Status, StatusCached)
+	}
+	second := repo.commit("main", "v2")
+	r := run()
+	if r.Status != StatusSuccess {
+		t.Errorf("检出新的提交后 = %s，期望重新执行", r.Status)
+	}
+	if !strings.Contains(r.Output, second) {
+		t.Errorf("输出 = %q，期望包含新的提交 %s", r.Output, second)
+	}
+}
@@ -0,0 +1,138 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// historySize 每个任务最多保留的历史耗时数量
+	historySize = 50
+	// historyMinSamples 样本少于这个数量时不计算 p95，避免刚开始就误报
+	historyMinSamples = 5
+)
+
+// RunHistory 各任务最近几次成功执行的耗时，保存在一个 JSON 文件中
+// 用于 SLA 检查：本次耗时超过历史 p95 时给出警告；中位数用于估算运行的进度和剩余时间
+type RunHistory struct {
+	path string
+
+	mu        sync.Mutex
+	Durations map[string][]time.Duration `json:"durations"` // 按时间先后排列，最新的在最后
+}
+
+// LoadHistory 读取历史记录，文件不存在时返回空记录
+func LoadHistory(path string) (*RunHistory, error) {
+	h := &RunHistory{path: path, Durations: make(map[string][]time.Duration)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("解析历史记录 %s 失败: %w", path, err)
+	}
+	if h.Durations == nil {
+		h.Durations = make(map[string][]time.Duration)
+	}
+	return h, nil
+}
+
+// P95 返回任务历史耗时的 p95，样本不足时返回 0
+func (h *RunHistory) P95(taskID string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := h.Durations[taskID]
+	if len(samples) < historyMinSamples {
+		return 0
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	// 最近秩法：第 ceil(0.95*n) 个样本
+	i := (len(sorted)*95+99)/100 - 1
+	return sorted[i]
+}
+
+// Median 返回任务历史耗时的中位数，用作预计耗时，没有记录时返回 0
+func (h *RunHistory) Median(taskID string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := h.Durations[taskID]
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// Record 记录一次成功执行的耗时
+func (h *RunHistory) Record(taskID string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := append(h.Durations[taskID], d)
+	if len(samples) > historySize {
+		samples = samples[len(samples)-historySize:]
+	}
+	h.Durations[taskID] = samples
+}
+
+// Save 写回历史记录文件，守护进程中多个运行共用一份记录，写文件时也持有锁
+func (h *RunHistory) Save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.path, data, 0o644)
+}
+
+// SetHistory 设置历史耗时记录，用于 SLA 检查
+func (s *Scheduler) SetHistory(h *RunHistory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = h
+}
+
+// checkSLA 任务耗时超过历史 p95 时在结果中标记，并记录一条警告
+// 只检查真正执行过的成功任务，缓存命中、失败的任务耗时没有可比性
+func (s *Scheduler) checkSLA(result *TaskResult) {
+	if s.history == nil || result.Status == StatusCached || !result.Status.Succeeded() {
+		return
+	}
+	p95 := s.history.P95(result.TaskID)
+	if p95 == 0 {
+		return
+	}
+	result.P95 = p95
+	if result.Duration > p95 {
+		result.SLAExceeded = true
+		s.logger.Warn("任务耗时超过历史 p95", logKeyTaskID, result.TaskID, logKeyDuration, result.Duration, "p95", p95)
+	}
+}
+
+// SaveHistory 把本次运行中成功任务的耗时写入历史记录
+func (s *Scheduler) SaveHistory() error {
+	if s.history == nil {
+		return nil
+	}
+	for id, result := range s.GetResults() {
+		if result.Status == StatusCached || !result.Status.Succeeded() {
+			continue
+		}
+		if task := s.tasks[id]; task != nil && task.Kind != KindJob {
+			// 服务和审批节点的耗时取决于外部，不参与统计
+			continue
+		}
+		s.history.Record(id, result.Duration)
+	}
+	return s.history.Save()
+}
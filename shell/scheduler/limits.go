@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResourceLimits 单个 shell 任务的资源限制
+//
+// 前四项通过 rlimit 实现，对子进程及其派生的进程生效；
+// MemoryMax 和 CPUMax 需要 cgroup v2，不可用时会打印警告并忽略
+type ResourceLimits struct {
+	CPUTime      time.Duration // CPU 时间上限（RLIMIT_CPU），按秒取整
+	AddressSpace uint64        // 虚拟地址空间上限，单位字节（RLIMIT_AS）
+	OpenFiles    uint64        // 打开文件数上限（RLIMIT_NOFILE）
+	MaxProcs     uint64        // 进程数上限（RLIMIT_NPROC，按用户统计）
+	MemoryMax    uint64        // cgroup v2 memory.max，单位字节
+	CPUMax       float64       // cgroup v2 cpu.max，单位为 CPU 核数，例如 0.5
+}
+
+// RunAs 以指定的 uid/gid 运行任务，调度器本身需要有切换用户的权限
+type RunAs struct {
+	UID uint32
+	GID uint32
+}
+
+// 资源限制导致任务失败时的原因，记录在 TaskResult.FailureReason 中
+const (
+	FailureCPUTime      = "cpu_time_limit"
+	FailureAddressSpace = "address_space_limit"
+	FailureMemory       = "memory_limit"
+)
+
+// LimitExceededError 任务因触发资源限制而被终止
+type LimitExceededError struct {
+	Reason string
+	Err    error
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("触发资源限制 %s: %v", e.Reason, e.Err)
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,267 @@
+//go:build linux
+
+package scheduler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// rlimitHelperEnv 设置了这个环境变量时，当前进程只充当“设置 rlimit 后 exec 目标命令”的跳板
+//
+// Go 的 exec 包不支持直接给子进程设置 rlimit，而在 Start 之后再用 prlimit 调整又存在竞态：
+// 子进程可能在限制生效前就已经跑完了关键逻辑。所以这里让调度器以自身为跳板启动任务：
+// 跳板进程先对自己调用 setrlimit，再 exec 成真正的命令，限制会被目标命令继承。
+const rlimitHelperEnv = "GO_LAB_SHELL_RLIMITS"
+
+// rlimitNPROC syscall 包没有导出 RLIMIT_NPROC，Linux 上它的值是 6
+const rlimitNPROC = 0x6
+
+// cgroupRoot cgroup v2 的挂载点，任务的 cgroup 创建在 cgroupRoot/cgroupParent 下面
+const (
+	cgroupRoot   = "/sys/fs/cgroup"
+	cgroupParent = "go-lab-shell"
+)
+
+// rlimitSpec 传给跳板进程的参数
+type rlimitSpec struct {
+	Path   string `json:"path"`
+	CPU    uint64 `json:"cpu,omitempty"`
+	AS     uint64 `json:"as,omitempty"`
+	NOFILE uint64 `json:"nofile,omitempty"`
+	NPROC  uint64 `json:"nproc,omitempty"`
+}
+
+func init() {
+	if raw := os.Getenv(rlimitHelperEnv); raw != "" {
+		runRlimitHelper(raw)
+	}
+}
+
+// runRlimitHelper 跳板进程的全部逻辑，成功时不会返回
+func runRlimitHelper(raw string) {
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, "rlimit helper: "+format+"\n", args...)
+		os.Exit(126)
+	}
+
+	var spec rlimitSpec
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		fail("解析参数失败: %v", err)
+	}
+	set := func(name string, resource int, cur, max uint64) {
+		if cur == 0 {
+			return
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: cur, Max: max}); err != nil {
+			fail("设置 %s 失败: %v", name, err)
+		}
+	}
+	// CPU 的硬限制比软限制多一秒：先收到 SIGXCPU，仍不退出再被 SIGKILL
+	set("RLIMIT_CPU", syscall.RLIMIT_CPU, spec.CPU, spec.CPU+1)
+	set("RLIMIT_AS", syscall.RLIMIT_AS, spec.AS, spec.AS)
+	set("RLIMIT_NOFILE", syscall.RLIMIT_NOFILE, spec.NOFILE, spec.NOFILE)
+	set("RLIMIT_NPROC", rlimitNPROC, spec.NPROC, spec.NPROC)
+
+	env := make([]string, 0, len(os.Environ()))
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, rlimitHelperEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	err := syscall.Exec(spec.Path, os.Args, env)
+	fail("执行 %s 失败: %v", spec.Path, err)
+}
+
+// limitState 一次执行中与资源限制相关的状态
+type limitState struct {
+	limits    *ResourceLimits
+	cgroupDir string
+	cgroupFD  int
+}
+
+// applyLimits 根据任务配置修改 cmd，使其在资源限制和指定用户下运行
+// 没有任何限制时返回 nil，limitState 的方法都可以在 nil 上调用
+func applyLimits(cmd *exec.Cmd, task *Task) (*limitState, error) {
+	if task.Limits == nil && task.RunAs == nil {
+		return nil, nil
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if task.RunAs != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: task.RunAs.UID, Gid: task.RunAs.GID}
+	}
+
+	state := &limitState{limits: task.Limits, cgroupFD: -1}
+	limits := task.Limits
+	if limits == nil {
+		return state, nil
+	}
+
+	// rlimit：以自身为跳板启动
+	if limits.CPUTime > 0 || limits.AddressSpace > 0 || limits.OpenFiles > 0 || limits.MaxProcs > 0 {
+		if cmd.Err != nil {
+			return nil, cmd.Err
+		}
+		self, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("无法定位调度器自身，不能设置 rlimit: %w", err)
+		}
+		spec := rlimitSpec{
+			Path:   cmd.Path,
+			AS:     limits.AddressSpace,
+			NOFILE: limits.OpenFiles,
+			NPROC:  limits.MaxProcs,
+		}
+		if limits.CPUTime > 0 {
+			spec.CPU = uint64((limits.CPUTime + time.Second - 1) / time.Second)
+		}
+		data, err := json.Marshal(spec)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Env = append(cmd.Environ(), rlimitHelperEnv+"="+string(data))
+		cmd.Path = self
+	}
+
+	// cgroup v2：内存和 CPU 配额
+	if limits.MemoryMax > 0 || limits.CPUMax > 0 {
+		if err := state.setupCgroup(task); err != nil {
+			slog.Warn("无法使用 cgroup v2，忽略内存/CPU 配额", logKeyTaskID, task.ID, "error", err)
+		} else {
+			cmd.SysProcAttr.UseCgroupFD = true
+			cmd.SysProcAttr.CgroupFD = state.cgroupFD
+		}
+	}
+	return state, nil
+}
+
+// setupCgroup 为本次执行创建独立的 cgroup 并写入配额
+func (l *limitState) setupCgroup(task *Task) error {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("%s 不是 cgroup v2 挂载点", cgroupRoot)
+	}
+	parent := filepath.Join(cgroupRoot, cgroupParent)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return err
+	}
+	// 在根和父 cgroup 上开启 memory、cpu 控制器，已开启时写入也不会报错
+	for _, dir := range []string{cgroupRoot, parent} {
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+memory +cpu"), 0o644); err != nil {
+			return fmt.Errorf("开启 cgroup 控制器失败: %w", err)
+		}
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, task.ID)
+	dir := filepath.Join(parent, fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return err
+	}
+	l.cgroupDir = dir
+
+	if l.limits.MemoryMax > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatUint(l.limits.MemoryMax, 10)), 0o644); err != nil {
+			l.removeCgroup()
+			return err
+		}
+	}
+	if l.limits.CPUMax > 0 {
+		const period = 100000
+		quota := int(l.limits.CPUMax * period)
+		if err := os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(fmt.Sprintf("%d %d", quota, period)), 0o644); err != nil {
+			l.removeCgroup()
+			return err
+		}
+	}
+
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		l.removeCgroup()
+		return err
+	}
+	l.cgroupFD = fd
+	return nil
+}
+
+// started 子进程已经启动，cgroup 目录的 fd 不再需要
+func (l *limitState) started() {
+	if l != nil && l.cgroupFD >= 0 {
+		syscall.Close(l.cgroupFD)
+		l.cgroupFD = -1
+	}
+}
+
+// finish 进程退出后判断是否因资源限制被终止，并清理 cgroup
+// 返回空字符串表示不是资源限制导致的
+func (l *limitState) finish(ps *os.ProcessState) string {
+	if l == nil {
+		return ""
+	}
+	l.started()
+	defer l.removeCgroup()
+
+	if l.cgroupDir != "" && cgroupOOMKilled(l.cgroupDir) {
+		return FailureMemory
+	}
+	if ps == nil || l.limits == nil {
+		return ""
+	}
+	status, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	switch sig := status.Signal(); {
+	case sig == syscall.SIGXCPU:
+		return FailureCPUTime
+	case sig == syscall.SIGKILL && l.limits.CPUTime > 0 && ps.UserTime()+ps.SystemTime() >= l.limits.CPUTime:
+		return FailureCPUTime
+	case l.limits.AddressSpace > 0 && (sig == syscall.SIGSEGV || sig == syscall.SIGABRT || sig == syscall.SIGBUS):
+		// 地址空间耗尽时 malloc 失败，大多数程序会以这几个信号崩溃，这里只能做推断
+		return FailureAddressSpace
+	}
+	return ""
+}
+
+// removeCgroup 删除本次执行创建的 cgroup，进程全部退出后目录才能删除
+func (l *limitState) removeCgroup() {
+	if l.cgroupDir == "" {
+		return
+	}
+	if err := os.Remove(l.cgroupDir); err != nil {
+		slog.Warn("删除 cgroup 失败", "dir", l.cgroupDir, "error", err)
+	}
+	l.cgroupDir = ""
+}
+
+// cgroupOOMKilled 读取 memory.events，判断 cgroup 中是否有进程被 OOM killer 杀死
+func cgroupOOMKilled(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		if key == "oom_kill" {
+			n, _ := strconv.Atoi(value)
+			return n > 0
+		}
+	}
+	return false
+}
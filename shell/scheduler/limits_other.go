@@ -0,0 +1,26 @@
+//go:build !linux
+
+package scheduler
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// limitState 非 Linux 平台上不支持资源限制，保留同样的方法以便调用方无需区分平台
+type limitState struct{}
+
+// applyLimits 任务声明了资源限制或运行用户时直接报错，而不是悄悄忽略
+func applyLimits(cmd *exec.Cmd, task *Task) (*limitState, error) {
+	if task.Limits != nil || task.RunAs != nil {
+		return nil, errors.New("资源限制和 RunAs 仅支持 Linux")
+	}
+	return nil, nil
+}
+
+func (l *limitState) started() {}
+
+func (l *limitState) finish(ps *os.ProcessState) string {
+	return ""
+}
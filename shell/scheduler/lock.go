@@ -0,0 +1,144 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockPolicy 流水线已经在运行时的处理方式
+type LockPolicy string
+
+const (
+	LockFail   LockPolicy = "fail"   // 立即失败（默认）
+	LockWait   LockPolicy = "wait"   // 等待对方结束，最多等 Timeout
+	LockCancel LockPolicy = "cancel" // 通过对方的控制 socket 取消它，然后等待它退出
+)
+
+// lockInfo 锁文件的内容，记录持有者以便判断是否过期、以及如何联系它
+type lockInfo struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	RunID     string    `json:"run_id"`
+	StartedAt time.Time `json:"started_at"`
+	Socket    string    `json:"socket,omitempty"` // 持有者的控制 socket
+}
+
+func (l *lockInfo) String() string {
+	return fmt.Sprintf("pid %d@%s，运行 %s，开始于 %s", l.PID, l.Host, l.RunID, l.StartedAt.Format(time.DateTime))
+}
+
+// PipelineLock 一条流水线的单实例锁
+type PipelineLock struct {
+	path string
+	info lockInfo
+	file *os.File // 持有 flock 的锁文件，仅 Unix
+}
+
+// LockOptions 获取锁的参数
+type LockOptions struct {
+	Dir     string        // 锁文件所在目录
+	Name    string        // 流水线名称，锁文件为 <Dir>/<Name>.lock
+	RunID   string        // 本次运行的 ID
+	Socket  string        // 本进程的控制 socket，写入锁文件供其他实例取消本次运行
+	Policy  LockPolicy    // 冲突时的处理方式
+	Timeout time.Duration // wait/cancel 策略下最长等待时间
+}
+
+// ErrLocked 流水线已经在运行
+var ErrLocked = errors.New("流水线已经在运行")
+
+// AcquireLock 获取流水线锁
+//
+// Unix 上用 flock 保证互斥，持有者退出（包括崩溃）后锁自动失效；
+// 其他平台用硬链接原子地创建锁文件，持有者进程已经不存在时视为过期锁清理，见各平台的 tryAcquire
+func AcquireLock(opts LockOptions) (*PipelineLock, error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	lock := &PipelineLock{
+		path: filepath.Join(opts.Dir, opts.Name+".lock"),
+		info: lockInfo{PID: os.Getpid(), Host: host, RunID: opts.RunID, StartedAt: time.Now(), Socket: opts.Socket},
+	}
+
+	var deadline time.Time
+	if opts.Timeout > 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+	cancelSent := false
+	for {
+		holder, err := lock.tryAcquire()
+		if err != nil || holder == nil {
+			return lock, err
+		}
+
+		switch opts.Policy {
+		case LockWait:
+		case LockCancel:
+			if !cancelSent {
+				if err := cancelHolder(holder, host, opts.RunID); err != nil {
+					return nil, fmt.Errorf("%w (%s)，取消失败: %v", ErrLocked, holder, err)
+				}
+				cancelSent = true
+			}
+		default:
+			return nil, fmt.Errorf("%w (%s)", ErrLocked, holder)
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w (%s)，等待 %v 后仍未释放", ErrLocked, holder, opts.Timeout)
+		}
+		slog.Info("流水线正在运行，等待锁释放", "lock", lock.path, "holder_pid", holder.PID, "holder_run_id", holder.RunID)
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// readLock 读取锁文件
+func readLock(path string) (*lockInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info lockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// LockSocket 返回正在持有 <dir>/<name>.lock 的运行的控制 socket
+func LockSocket(dir, name string) (string, error) {
+	holder, err := readLock(filepath.Join(dir, name+".lock"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("流水线 %s 没有在运行", name)
+		}
+		return "", err
+	}
+	if holder.Socket == "" {
+		return "", fmt.Errorf("运行 %s 没有控制 socket", holder.RunID)
+	}
+	return holder.Socket, nil
+}
+
+// cancelHolder 通过控制 socket 请求持有者取消运行，只能取消同一主机上的实例
+func cancelHolder(holder *lockInfo, host, runID string) error {
+	if holder.Host != host {
+		return fmt.Errorf("持有者在另一台主机 %s 上", holder.Host)
+	}
+	if holder.Socket == "" {
+		return errors.New("持有者没有控制 socket")
+	}
+	slog.Warn("请求取消正在运行的流水线", "holder_pid", holder.PID, "holder_run_id", holder.RunID)
+	return ControlClient{Path: holder.Socket}.Call(ControlRequest{Command: "cancel", By: "run " + runID}, nil)
+}
+
+// Release 释放锁
+func (l *PipelineLock) Release() {
+	l.release()
+}
@@ -0,0 +1,102 @@
+//go:build !unix
+
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// tryAcquire 尝试获取一次锁，被占用时返回持有者
+//
+// 锁文件先完整写入临时文件，再用硬链接放到目标位置：链接是原子的，
+// 其他实例要么看不到锁文件，要么看到完整的内容。
+// 持有者与本机同一主机且进程已经不存在时，视为过期锁清理
+func (l *PipelineLock) tryAcquire() (*lockInfo, error) {
+	data, err := json.Marshal(l.info)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for range 2 {
+		err := os.Link(tmp.Name(), l.path)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		holder, err := readLock(l.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // 对方刚刚释放
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取锁文件 %s 失败: %w", l.path, err)
+		}
+		if holder.Host != l.info.Host || processAlive(holder.PID) {
+			return holder, nil
+		}
+		if err := l.removeStale(holder); err != nil {
+			return nil, err
+		}
+	}
+	// 清理过期锁后又被别人抢先拿到
+	holder, err := readLock(l.path)
+	if err != nil {
+		return nil, err
+	}
+	return holder, nil
+}
+
+// removeStale 清理过期锁
+//
+// 直接删除有竞争：两个实例可能同时看到同一个过期锁，一个删除后重新获取，另一个随后把这个有效的锁也删掉。
+// 所以先把锁文件改名到只属于自己的位置，再确认改名的正是刚才看到的过期锁；
+// 不是的话说明别人已经重新获取，把它放回原处
+func (l *PipelineLock) removeStale(stale *lockInfo) error {
+	aside := fmt.Sprintf("%s.stale.%d", l.path, os.Getpid())
+	if err := os.Rename(l.path, aside); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer os.Remove(aside)
+	moved, err := readLock(aside)
+	if err == nil && moved.PID == stale.PID && moved.RunID == stale.RunID {
+		slog.Warn("清理过期的流水线锁", "lock", l.path, "holder_pid", stale.PID, "holder_run_id", stale.RunID)
+		return nil
+	}
+	if err := os.Link(aside, l.path); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("恢复锁文件 %s 失败: %w", l.path, err)
+	}
+	return nil
+}
+
+// release 释放锁，锁已经不属于自己（例如被当作过期锁清理后由别人获取）时不做任何事
+func (l *PipelineLock) release() {
+	holder, err := readLock(l.path)
+	if err != nil || holder.PID != l.info.PID || holder.RunID != l.info.RunID {
+		return
+	}
+	if err := os.Remove(l.path); err != nil {
+		slog.Warn("释放流水线锁失败", "lock", l.path, "error", err)
+	}
+}
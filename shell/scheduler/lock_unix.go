@@ -0,0 +1,86 @@
+//go:build unix
+
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+)
+
+// tryAcquire 尝试获取一次锁，被占用时返回持有者
+//
+// 互斥由锁文件上的 flock 保证：检查和获取是同一个原子操作，不会出现两个实例同时认为自己持有锁。
+// flock 随文件描述符释放，持有者崩溃后锁自动失效，留下的锁文件不影响下一次获取
+func (l *PipelineLock) tryAcquire() (*lockInfo, error) {
+	for {
+		f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if !errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, err
+			}
+			holder, err := readHolder(l.path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue // 对方刚刚释放
+			}
+			return holder, err
+		}
+
+		// 上一个持有者释放时会先删除锁文件，拿到的锁可能在已经被删除的文件上，需要重新打开
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if cur, err := os.Stat(l.path); err != nil || !os.SameFile(fi, cur) {
+			f.Close()
+			continue
+		}
+
+		data, err := json.Marshal(l.info)
+		if err == nil {
+			err = f.Truncate(0)
+		}
+		if err == nil {
+			_, err = f.WriteAt(data, 0)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		l.file = f
+		return nil, nil
+	}
+}
+
+// readHolder 读取持有者信息；持有者可能正在写入，内容不完整时稍后重读
+func readHolder(path string) (*lockInfo, error) {
+	var err error
+	for range 10 {
+		var holder *lockInfo
+		if holder, err = readLock(path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return holder, err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil, err
+}
+
+// release 先删除锁文件再释放 flock，等待中的实例拿到锁后会发现文件已被删除并重新打开
+func (l *PipelineLock) release() {
+	if l.file == nil {
+		return
+	}
+	if err := os.Remove(l.path); err != nil {
+		slog.Warn("释放流水线锁失败", "lock", l.path, "error", err)
+	}
+	l.file.Close()
+	l.file = nil
+}
@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// 日志记录中统一使用的属性名
+const (
+	logKeyRunID    = "run_id"
+	logKeyTaskID   = "task_id"
+	logKeyWorker   = "worker"
+	logKeyAttempt  = "attempt"
+	logKeyExitCode = "exit_code"
+	logKeyDuration = "duration"
+	logKeyStatus   = "status"
+	logKeyStream   = "stream"
+	logKeyLine     = "line"
+)
+
+// NewLogHandler 按格式（text 或 json）和级别创建日志 handler
+func NewLogHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("无效的日志级别 %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text", "":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("无效的日志格式 %q，可选 text 或 json", format)
+	}
+}
+
+// newRunID 生成本次运行的 ID：启动时间加随机后缀，便于在日志平台中按运行聚合
+func newRunID() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,184 @@
+package scheduler
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// durationBuckets 任务耗时直方图的桶边界（秒）
+// shell 任务从几百毫秒到几十分钟不等，所以桶的跨度比较大
+var durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// histogram 一个最小化的 Prometheus 直方图
+// counts[i] 记录落在 (buckets[i-1], buckets[i]] 区间的次数，输出时再累加
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range durationBuckets {
+		if v <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Metrics 调度器的运行指标
+//
+// 这里没有引入 prometheus/client_golang，
+// 调度器需要的指标类型很少，直接按文本格式输出就足够了。
+// 所有字段都由 mu 保护，写入频率很低（每个任务一次），锁的开销可以忽略。
+type Metrics struct {
+	mu          sync.Mutex
+	busyWorkers int
+	durations   map[string]*histogram // 按任务ID区分的耗时直方图
+	statuses    map[string]uint64     // 按最终状态统计的任务数
+	retries     map[string]uint64     // 按任务ID统计的重试次数
+	timeouts    map[string]uint64     // 按任务ID统计的超时次数
+}
+
+// NewMetrics 创建指标集合
+func NewMetrics() *Metrics {
+	return &Metrics{
+		durations: make(map[string]*histogram),
+		statuses:  make(map[string]uint64),
+		retries:   make(map[string]uint64),
+		timeouts:  make(map[string]uint64),
+	}
+}
+
+// workerBusy 记录 worker 开始或结束执行任务
+func (m *Metrics) workerBusy(busy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if busy {
+		m.busyWorkers++
+	} else {
+		m.busyWorkers--
+	}
+}
+
+// taskRetried 记录一次重试
+func (m *Metrics) taskRetried(taskID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries[taskID]++
+}
+
+// taskTimedOut 记录一次超时（每次尝试单独计数）
+func (m *Metrics) taskTimedOut(taskID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeouts[taskID]++
+}
+
+// taskFinished 记录任务的最终结果
+func (m *Metrics) taskFinished(result *TaskResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.durations[result.TaskID]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[result.TaskID] = h
+	}
+	h.observe(result.Duration.Seconds())
+	m.statuses[result.Status.Code()]++
+}
+
+// escapeLabelValue 按 exposition 格式转义标签值：反斜杠、双引号和换行
+func escapeLabelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return strings.ReplaceAll(v, "\n", `\n`)
+}
+
+// formatFloat 按 Prometheus 的习惯格式化数值
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys 返回 map 的有序 key，保证每次输出顺序一致
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeHeader 输出指标的 HELP 和 TYPE 行
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// WriteTo 以 Prometheus 文本格式（0.0.4）输出当前指标
+// queueDepth 和 maxWorkers 由调度器在抓取时提供，它们是瞬时值，不需要在这里存储
+func (m *Metrics) WriteTo(w io.Writer, queueDepth, maxWorkers int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "shell_scheduler_queue_depth", "gauge", "Number of tasks waiting in the queue.")
+	fmt.Fprintf(w, "shell_scheduler_queue_depth %d\n", queueDepth)
+
+	writeHeader(w, "shell_scheduler_workers", "gauge", "Number of workers by state.")
+	fmt.Fprintf(w, "shell_scheduler_workers{state=\"busy\"} %d\n", m.busyWorkers)
+	fmt.Fprintf(w, "shell_scheduler_workers{state=\"idle\"} %d\n", maxWorkers-m.busyWorkers)
+
+	writeHeader(w, "shell_scheduler_task_duration_seconds", "histogram", "Task duration including retries.")
+	for _, id := range sortedKeys(m.durations) {
+		h := m.durations[id]
+		label := escapeLabelValue(id)
+		var cumulative uint64
+		for i, upper := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "shell_scheduler_task_duration_seconds_bucket{task_id=\"%s\",le=\"%s\"} %d\n", label, formatFloat(upper), cumulative)
+		}
+		fmt.Fprintf(w, "shell_scheduler_task_duration_seconds_bucket{task_id=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "shell_scheduler_task_duration_seconds_sum{task_id=\"%s\"} %s\n", label, formatFloat(h.sum))
+		fmt.Fprintf(w, "shell_scheduler_task_duration_seconds_count{task_id=\"%s\"} %d\n", label, h.count)
+	}
+
+	writeHeader(w, "shell_scheduler_tasks_total", "counter", "Finished tasks by final status.")
+	for _, status := range sortedKeys(m.statuses) {
+		fmt.Fprintf(w, "shell_scheduler_tasks_total{status=\"%s\"} %d\n", escapeLabelValue(status), m.statuses[status])
+	}
+
+	writeHeader(w, "shell_scheduler_task_retries_total", "counter", "Task retry attempts.")
+	for _, id := range sortedKeys(m.retries) {
+		fmt.Fprintf(w, "shell_scheduler_task_retries_total{task_id=\"%s\"} %d\n", escapeLabelValue(id), m.retries[id])
+	}
+
+	writeHeader(w, "shell_scheduler_task_timeouts_total", "counter", "Task attempts that hit their timeout.")
+	for _, id := range sortedKeys(m.timeouts) {
+		fmt.Fprintf(w, "shell_scheduler_task_timeouts_total{task_id=\"%s\"} %d\n", escapeLabelValue(id), m.timeouts[id])
+	}
+}
+
+// MetricsHandler 返回 /metrics 的 HTTP 处理函数
+func (s *Scheduler) MetricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.metrics.WriteTo(w, len(s.taskQueue), s.maxWorkers)
+	}
+}
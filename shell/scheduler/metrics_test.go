@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleLine exposition 格式中的一行样本：指标名、可选的标签和数值
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{([a-zA-Z_][a-zA-Z0-9_]*="(\\.|[^"\\])*",?)*\})? (\S+)$`)
+
+func TestMetricsExpositionFormat(t *testing.T) {
+	m := NewMetrics()
+	m.workerBusy(true)
+	m.taskRetried("build")
+	m.taskRetried("build")
+	m.taskTimedOut("test")
+	m.taskFinished(&TaskResult{TaskID: "build", Status: StatusSuccess, Duration: 3 * time.Second})
+	m.taskFinished(&TaskResult{TaskID: "test", Status: StatusTimeout, Duration: 20 * time.Minute})
+	m.taskFinished(&TaskResult{TaskID: "say \"hi\"\\\n", Status: StatusFailed, Duration: time.Millisecond})
+
+	var b strings.Builder
+	m.WriteTo(&b, 2, 4)
+	out := b.String()
+
+	// 每一行都必须是注释或者合法的样本，每个指标在样本之前声明类型
+	typed := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			parts := strings.Fields(name)
+			if len(parts) != 2 {
+				t.Fatalf("无效的 TYPE 行: %q", line)
+			}
+			typed[parts[0]] = parts[1]
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		match := sampleLine.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("无效的样本行: %q", line)
+		}
+		name := match[1]
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if base, ok := strings.CutSuffix(name, suffix); ok && typed[base] == "histogram" {
+				name = base
+			}
+		}
+		if _, ok := typed[name]; !ok {
+			t.Errorf("指标 %s 在样本之前没有声明 TYPE", match[1])
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"队列长度", "shell_scheduler_queue_depth 2\n"},
+		{"忙碌的 worker", `shell_scheduler_workers{state="busy"} 1` + "\n"},
+		{"空闲的 worker", `shell_scheduler_workers{state="idle"} 3` + "\n"},
+		{"桶是累计的", `shell_scheduler_task_duration_seconds_bucket{task_id="build",le="5"} 1` + "\n"},
+		{"+Inf 桶等于总数", `shell_scheduler_task_duration_seconds_bucket{task_id="test",le="+Inf"} 1` + "\n"},
+		{"耗时总和", `shell_scheduler_task_duration_seconds_sum{task_id="test"} 1200` + "\n"},
+		{"按状态计数", `shell_scheduler_tasks_total{status="timeout"} 1` + "\n"},
+		{"重试次数", `shell_scheduler_task_retries_total{task_id="build"} 2` + "\n"},
+		{"超时次数", `shell_scheduler_task_timeouts_total{task_id="test"} 1` + "\n"},
+		{"标签值转义", `task_id="say \"hi\"\\\n"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("输出中没有 %q:\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	s := newTestScheduler(WithMaxWorkers(3))
+	rec := httptest.NewRecorder()
+	s.MetricsHandler()(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `shell_scheduler_workers{state="idle"} 3`) {
+		t.Errorf("缺少空闲 worker 数:\n%s", body)
+	}
+}
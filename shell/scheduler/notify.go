@@ -0,0 +1,365 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// NotifyEvent 触发通知的事件类型
+type NotifyEvent string
+
+const (
+	EventRunFailed    NotifyEvent = "run_failed"    // 整个运行中存在失败的任务
+	EventTaskFailed   NotifyEvent = "task_failed"   // 单个任务在用尽重试后仍然失败
+	EventRunRecovered NotifyEvent = "run_recovered" // 上一次运行失败，这一次全部成功
+)
+
+// ParseNotifyEvents 解析逗号分隔的事件列表
+func ParseNotifyEvents(value string) ([]NotifyEvent, error) {
+	var events []NotifyEvent
+	for _, part := range strings.Split(value, ",") {
+		switch e := NotifyEvent(strings.TrimSpace(part)); e {
+		case "":
+		case EventRunFailed, EventTaskFailed, EventRunRecovered:
+			events = append(events, e)
+		default:
+			return nil, fmt.Errorf("未知的通知事件 %q", part)
+		}
+	}
+	return events, nil
+}
+
+// Notification 发送给通知器的内容
+// 运行级事件带有完整的 Report，任务级事件只带有对应的 Task
+type Notification struct {
+	Event  NotifyEvent `json:"event"`
+	Run    string      `json:"run"`
+	Time   time.Time   `json:"time"`
+	Task   *TaskReport `json:"task,omitempty"`
+	Report *RunReport  `json:"report,omitempty"`
+}
+
+// Subject 一行话描述这次通知，用于邮件标题和默认消息
+func (n *Notification) Subject() string {
+	switch n.Event {
+	case EventTaskFailed:
+		return fmt.Sprintf("[%s] 任务 %s 失败 (%s)", n.Run, n.Task.Name, n.Task.Status)
+	case EventRunFailed:
+		return fmt.Sprintf("[%s] 运行失败: %d/%d 个任务失败", n.Run, n.Report.Failed, n.Report.Total)
+	case EventRunRecovered:
+		return fmt.Sprintf("[%s] 运行已恢复: %d 个任务全部成功", n.Run, n.Report.Total)
+	default:
+		return fmt.Sprintf("[%s] %s", n.Run, n.Event)
+	}
+}
+
+// Notifier 通知器插件
+// Notify 只负责发送一次，重试由调度器根据 RetryPolicy 统一处理
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// RetryPolicy 通知发送失败时的重试策略
+type RetryPolicy struct {
+	Attempts int           // 最多尝试次数，至少为 1
+	Delay    time.Duration // 首次重试前的等待时间，之后每次翻倍
+	Timeout  time.Duration // 单次发送的超时时间
+}
+
+// notifierEntry 已注册的通知器及其触发条件
+type notifierEntry struct {
+	notifier Notifier
+	retry    RetryPolicy
+	events   map[NotifyEvent]bool
+}
+
+// AddNotifier 注册通知器，只有 events 中列出的事件才会触发它
+func (s *Scheduler) AddNotifier(n Notifier, retry RetryPolicy, events ...NotifyEvent) {
+	if retry.Attempts < 1 {
+		retry.Attempts = 1
+	}
+	if retry.Timeout == 0 {
+		retry.Timeout = 30 * time.Second
+	}
+	entry := &notifierEntry{notifier: n, retry: retry, events: make(map[NotifyEvent]bool)}
+	for _, e := range events {
+		entry.events[e] = true
+	}
+	s.mu.Lock()
+	s.notifiers = append(s.notifiers, entry)
+	s.mu.Unlock()
+}
+
+// SetNotifyStateFile 设置记录上一次运行结果的文件，用于判断 run_recovered
+func (s *Scheduler) SetNotifyStateFile(path string) {
+	s.mu.Lock()
+	s.notifyStatePath = path
+	s.mu.Unlock()
+}
+
+// send 按重试策略发送一次通知
+func (e *notifierEntry) send(logger *slog.Logger, n *Notification) {
+	delay := e.retry.Delay
+	var err error
+	for attempt := 1; attempt <= e.retry.Attempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), e.retry.Timeout)
+		err = e.notifier.Notify(ctx, n)
+		cancel()
+		if err == nil {
+			return
+		}
+		logger.Warn("发送通知失败", "notifier", e.notifier.Name(), "event", n.Event, logKeyAttempt, attempt, "max_attempts", e.retry.Attempts, "error", err)
+		if attempt < e.retry.Attempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// notify 异步地把通知发给所有关心该事件的通知器
+// 调度器在运行结束前会等待这些发送全部完成
+func (s *Scheduler) notify(n *Notification) {
+	s.mu.Lock()
+	entries := make([]*notifierEntry, 0, len(s.notifiers))
+	for _, e := range s.notifiers {
+		if e.events[n.Event] {
+			entries = append(entries, e)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, e := range entries {
+		s.notifyWG.Add(1)
+		go func(e *notifierEntry) {
+			defer s.notifyWG.Done()
+			e.send(s.logger, n)
+		}(e)
+	}
+}
+
+// notifyTaskFinished 任务结束时检查是否需要发送 task_failed，
+// 按时间窗口策略跳过的任务、手动取消的任务和因为调度器停止而中断的任务不算失败
+func (s *Scheduler) notifyTaskFinished(result *TaskResult) {
+	if result.Status.Succeeded() || result.Status == StatusSkipped ||
+		errors.Is(result.Error, errTaskCancelled) || errors.Is(result.Error, errRunStopped) {
+		return
+	}
+	task := &TaskReport{
+		ID:            result.TaskID,
+		Name:          result.TaskName,
+		Status:        result.Status.Code(),
+		StartTime:     result.StartTime,
+		EndTime:       result.EndTime,
+		DurationMs:    result.Duration.Milliseconds(),
+		ExitCode:      result.ExitCode,
+		RetryCount:    result.RetryCount,
+		Output:        result.Output,
+		FailureReason: result.FailureReason,
+		Warnings:      result.Warnings,
+		status:        result.Status,
+		duration:      result.Duration,
+	}
+	if result.Error != nil {
+		task.Error = result.Error.Error()
+	}
+	s.notify(&Notification{Event: EventTaskFailed, Run: s.name, Time: s.clock.Now(), Task: task})
+}
+
+// notifyState 持久化的上一次运行结果
+type notifyState struct {
+	LastRunFailed bool      `json:"last_run_failed"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// notifyRunFinished 整个运行结束时发送 run_failed 或 run_recovered，并更新状态文件
+func (s *Scheduler) notifyRunFinished() {
+	report := s.BuildReport(s.name)
+	// 按时间窗口策略跳过的任务（OutsideWindow）不算失败
+	failed := report.Failed > 0 || report.Skipped > 0
+
+	s.mu.Lock()
+	statePath := s.notifyStatePath
+	s.mu.Unlock()
+
+	var previous notifyState
+	if statePath != "" {
+		if data, err := os.ReadFile(statePath); err == nil {
+			if err := json.Unmarshal(data, &previous); err != nil {
+				s.logger.Warn("读取通知状态文件失败", "path", statePath, "error", err)
+			}
+		}
+		data, _ := json.Marshal(notifyState{LastRunFailed: failed, UpdatedAt: time.Now()})
+		if err := os.WriteFile(statePath, data, 0o644); err != nil {
+			s.logger.Warn("写入通知状态文件失败", "path", statePath, "error", err)
+		}
+	}
+
+	switch {
+	case failed:
+		s.notify(&Notification{Event: EventRunFailed, Run: report.Name, Time: s.clock.Now(), Report: report})
+	case previous.LastRunFailed:
+		s.notify(&Notification{Event: EventRunRecovered, Run: report.Name, Time: s.clock.Now(), Report: report})
+	}
+}
+
+// notificationTemplateFuncs 模板中可用的函数
+// json 会把任意值编码成合法的 JSON 字面量，拼接 JSON 请求体时用它来转义字符串
+var notificationTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// defaultWebhookBody 默认的 webhook 请求体
+const defaultWebhookBody = `{"event": {{json .Event}}, "run": {{json .Run}}, "text": {{json .Subject}}, "time": {{json .Time}}{{if .Task}}, "task": {{json .Task}}{{end}}{{if .Report}}, "report": {{json .Report}}{{end}}}`
+
+// WebhookNotifier 通过 HTTP 请求发送通知
+// Body 是 text/template 模板，渲染结果必须是合法的 JSON
+type WebhookNotifier struct {
+	URL     string
+	Method  string
+	Headers map[string]string
+	Body    string
+
+	tmpl *template.Template
+}
+
+// NewWebhookNotifier 创建 webhook 通知器，body 为空时使用默认模板
+func NewWebhookNotifier(url, body string) (*WebhookNotifier, error) {
+	if body == "" {
+		body = defaultWebhookBody
+	}
+	tmpl, err := template.New("webhook").Funcs(notificationTemplateFuncs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("解析 webhook 模板失败: %w", err)
+	}
+	return &WebhookNotifier{URL: url, Method: http.MethodPost, Body: body, tmpl: tmpl}, nil
+}
+
+func (w *WebhookNotifier) Name() string { return "webhook" }
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	var body bytes.Buffer
+	if err := w.tmpl.Execute(&body, n); err != nil {
+		return fmt.Errorf("渲染 webhook 模板失败: %w", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return errors.New("webhook 模板渲染结果不是合法的 JSON")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.Method, w.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook 返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// EmailNotifier 通过 SMTP 发送通知邮件
+// Username 为空时不做认证，适合本机的 MTA
+type EmailNotifier struct {
+	Addr     string // SMTP 服务器地址，例如 smtp.example.com:587
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e *EmailNotifier) Name() string { return "email" }
+
+func (e *EmailNotifier) Notify(ctx context.Context, n *Notification) error {
+	var body strings.Builder
+	body.WriteString(n.Subject() + "\r\n\r\n")
+	if n.Report != nil {
+		writeMarkdownReport(&body, n.Report)
+	}
+	if n.Task != nil {
+		fmt.Fprintf(&body, "任务: %s (%s)\r\n状态: %s\r\n退出码: %d\r\n", n.Task.Name, n.Task.ID, n.Task.Status, n.Task.ExitCode)
+		if n.Task.Error != "" {
+			fmt.Fprintf(&body, "错误: %s\r\n", n.Task.Error)
+		}
+		if n.Task.Output != "" {
+			fmt.Fprintf(&body, "\r\n输出:\r\n%s\r\n", n.Task.Output)
+		}
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject()))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body.String(), "\r\n", "\n"), "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := strings.Cut(e.Addr, ":")
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+
+	// smtp.SendMail 不支持 context，这里放到 goroutine 中以便超时返回
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(e.Addr, auth, e.From, e.To, []byte(msg.String()))
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CommandNotifier 执行本地命令发送通知
+// 通知内容以 JSON 形式写入命令的标准输入，同时通过环境变量传递关键字段
+type CommandNotifier struct {
+	Cmd string // 通过 sh -c 执行
+}
+
+func (c *CommandNotifier) Name() string { return "command" }
+
+func (c *CommandNotifier) Notify(ctx context.Context, n *Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Cmd)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"NOTIFY_EVENT="+string(n.Event),
+		"NOTIFY_RUN="+n.Run,
+		"NOTIFY_SUBJECT="+n.Subject(),
+	)
+	if n.Task != nil {
+		cmd.Env = append(cmd.Env, "NOTIFY_TASK_ID="+n.Task.ID, "NOTIFY_TASK_STATUS="+n.Task.Status)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
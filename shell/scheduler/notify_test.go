@@ -0,0 +1,509 @@
+package scheduler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder 记录收到的 webhook 请求，前 failures 次返回 500
+type webhookRecorder struct {
+	mu       sync.Mutex
+	failures int
+	bodies   [][]byte
+}
+
+func (wr *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.bodies = append(wr.bodies, body)
+	if len(wr.bodies) <= wr.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// webhookPayload 默认模板的请求体中测试关心的字段
+type webhookPayload struct {
+	Event NotifyEvent `json:"event"`
+	Run   string      `json:"run"`
+	Time  time.Time   `json:"time"`
+}
+
+// payloads 收到的请求体，按收到的顺序
+func (wr *webhookRecorder) payloads(t *testing.T) []webhookPayload {
+	t.Helper()
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	var payloads []webhookPayload
+	for _, body := range wr.bodies {
+		var payload webhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("请求体不是合法的 JSON: %v\n%s", err, body)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	n := &Notification{
+		Event: EventTaskFailed,
+		Run:   "build",
+		Time:  time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC),
+		Task:  &TaskReport{ID: "test", Name: "单元测试 \"quoted\"", Status: "failed", ExitCode: 2},
+	}
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		wantErr  bool
+		wantBody map[string]any
+	}{
+		{
+			name:     "默认模板",
+			status:   http.StatusOK,
+			wantBody: map[string]any{"event": "task_failed", "run": "build", "text": n.Subject()},
+		},
+		{
+			name:     "自定义模板转义字符串",
+			body:     `{"msg": {{json .Task.Name}}, "code": {{.Task.ExitCode}}}`,
+			status:   http.StatusNoContent,
+			wantBody: map[string]any{"msg": "单元测试 \"quoted\"", "code": float64(2)},
+		},
+		{
+			name:    "非 2xx 视为失败",
+			status:  http.StatusBadGateway,
+			wantErr: true,
+		},
+		{
+			name:    "渲染结果不是 JSON",
+			body:    `{"msg": {{.Task.Name}}}`,
+			status:  http.StatusOK,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			var header http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = io.ReadAll(r.Body)
+				header = r.Header
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			w, err := NewWebhookNotifier(srv.URL, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Headers = map[string]string{"Authorization": "Bearer token"}
+			err = w.Notify(context.Background(), n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() 错误 = %v，期望出错 %v", err, tt.wantErr)
+			}
+			if tt.wantBody == nil {
+				return
+			}
+			if ct := header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if auth := header.Get("Authorization"); auth != "Bearer token" {
+				t.Errorf("Authorization = %q", auth)
+			}
+			var payload map[string]any
+			if err := json.Unmarshal(got, &payload); err != nil {
+				t.Fatalf("请求体不是合法的 JSON: %v\n%s", err, got)
+			}
+			for k, want := range tt.wantBody {
+				if payload[k] != want {
+					t.Errorf("%s = %v，期望 %v", k, payload[k], want)
+				}
+			}
+		})
+	}
+}
+
+// smtpMessage SMTP 替身收到的一封邮件
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP 一个最小的 SMTP 服务器，只支持不带认证和 TLS 的投递
+func fakeSMTP(t *testing.T) (string, <-chan smtpMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages := make(chan smtpMessage, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+		reply("220 localhost ESMTP")
+		var msg smtpMessage
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				msg.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 end with .")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				msg.data = data.String()
+				reply("250 OK")
+				messages <- msg
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func TestEmailNotifier(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           *Notification
+		wantSubject string
+		wantBody    []string
+	}{
+		{
+			name: "任务失败",
+			n: &Notification{
+				Event: EventTaskFailed,
+				Run:   "build",
+				Task:  &TaskReport{ID: "test", Name: "测试", Status: "failed", ExitCode: 2, Error: "exit status 2", Output: "FAIL: TestX"},
+			},
+			wantSubject: "[build] 任务 测试 失败 (failed)",
+			wantBody:    []string{"任务: 测试 (test)", "退出码: 2", "错误: exit status 2", "FAIL: TestX"},
+		},
+		{
+			name: "运行失败带报告",
+			n: &Notification{
+				Event:  EventRunFailed,
+				Run:    "build",
+				Report: &RunReport{Name: "build", Total: 2, Success: 1, Failed: 1},
+			},
+			wantSubject: "[build] 运行失败: 1/2 个任务失败",
+			wantBody:    []string{"## build", "**1** failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, messages := fakeSMTP(t)
+			e := &EmailNotifier{Addr: addr, From: "ci@example.com", To: []string{"a@example.com", "b@example.com"}}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := e.Notify(ctx, tt.n); err != nil {
+				t.Fatalf("Notify() 错误: %v", err)
+			}
+			msg := <-messages
+			if msg.from != e.From || !slices.Equal(msg.to, e.To) {
+				t.Errorf("信封 = %s -> %v，期望 %s -> %v", msg.from, msg.to, e.From, e.To)
+			}
+			header, body, _ := strings.Cut(msg.data, "\r\n\r\n")
+			var subject string
+			for _, line := range strings.Split(header, "\r\n") {
+				if v, ok := strings.CutPrefix(line, "Subject: "); ok {
+					subject, _ = new(mime.WordDecoder).DecodeHeader(v)
+				}
+			}
+			if subject != tt.wantSubject {
+				t.Errorf("标题 = %q，期望 %q", subject, tt.wantSubject)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("正文中没有 %q:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestEmailNotifierTimeout(t *testing.T) {
+	// 接受连接但从不应答的服务器
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+	e := &EmailNotifier{Addr: ln.Addr().String(), From: "ci@example.com", To: []string{"a@example.com"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	n := &Notification{Event: EventRunRecovered, Run: "build", Report: &RunReport{Total: 1}}
+	if err := e.Notify(ctx, n); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Notify() 错误 = %v，期望超时", err)
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("没有 sh")
+	}
+	n := &Notification{
+		Event: EventTaskFailed,
+		Run:   "build",
+		Time:  time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC),
+		Task:  &TaskReport{ID: "test", Name: "单元测试", Status: "failed", ExitCode: 2},
+	}
+	tests := []struct {
+		name         string
+		failures     int // 命令前几次以非零状态退出
+		attempts     int
+		wantAttempts int
+	}{
+		{"一次成功", 0, 3, 1},
+		{"失败后重试成功", 2, 3, 3},
+		{"重试用尽", 5, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// 每次执行都记录标准输入和环境变量，前 failures 次以非零状态退出
+			script := fmt.Sprintf(`n=$(ls %[1]s | grep -c '^stdin') || true
+n=$((n + 1))
+cat > %[1]s/stdin.$n
+env | grep '^NOTIFY_' | sort > %[1]s/env.$n
+[ $n -gt %[2]d ] || { echo "attempt $n failed"; exit 3; }`, dir, tt.failures)
+			c := &CommandNotifier{Cmd: script}
+			e := &notifierEntry{notifier: c, retry: RetryPolicy{Attempts: tt.attempts, Delay: time.Millisecond, Timeout: 10 * time.Second}}
+			var logs strings.Builder
+			e.send(slog.New(slog.NewTextHandler(&logs, nil)), n)
+			// 每次失败都记录一条带命令输出的警告
+			if got, want := strings.Count(logs.String(), "发送通知失败"), min(tt.failures, tt.attempts); got != want {
+				t.Errorf("记录了 %d 次发送失败，期望 %d 次:\n%s", got, want, logs.String())
+			}
+			if tt.failures > 0 && !strings.Contains(logs.String(), "attempt 1 failed") {
+				t.Errorf("日志中没有命令的输出:\n%s", logs.String())
+			}
+
+			stdins, _ := filepath.Glob(filepath.Join(dir, "stdin.*"))
+			if len(stdins) != tt.wantAttempts {
+				t.Fatalf("执行了 %d 次，期望 %d 次", len(stdins), tt.wantAttempts)
+			}
+			data, err := os.ReadFile(stdins[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got Notification
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("标准输入不是合法的 JSON: %v\n%s", err, data)
+			}
+			if got.Event != n.Event || got.Run != n.Run || !got.Time.Equal(n.Time) || got.Task == nil || got.Task.ExitCode != 2 {
+				t.Errorf("标准输入 = %+v，期望 %+v", got, n)
+			}
+			env, err := os.ReadFile(filepath.Join(dir, "env.1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{
+				"NOTIFY_EVENT=task_failed",
+				"NOTIFY_RUN=build",
+				"NOTIFY_SUBJECT=" + n.Subject(),
+				"NOTIFY_TASK_ID=test",
+				"NOTIFY_TASK_STATUS=failed",
+			} {
+				if !slices.Contains(strings.Split(strings.TrimSpace(string(env)), "\n"), want) {
+					t.Errorf("环境变量中没有 %q:\n%s", want, env)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerNotifications(t *testing.T) {
+	failing := func(ctx context.Context, w io.Writer) error { return errors.New("boom") }
+	passing := func(ctx context.Context, w io.Writer) error { return nil }
+	tests := []struct {
+		name         string
+		fn           TaskFunc
+		lastFailed   bool
+		failures     int // webhook 前几次返回 500
+		want         []NotifyEvent
+		wantRequests int
+		wantFailed   bool // 状态文件中记录的结果
+	}{
+		{"任务失败", failing, false, 0, []NotifyEvent{EventTaskFailed, EventRunFailed}, 2, true},
+		{"连续成功不通知", passing, false, 0, nil, 0, false},
+		{"从失败中恢复", passing, true, 0, []NotifyEvent{EventRunRecovered}, 1, false},
+		{"发送失败时重试", passing, true, 2, []NotifyEvent{EventRunRecovered, EventRunRecovered, EventRunRecovered}, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &webhookRecorder{failures: tt.failures}
+			srv := httptest.NewServer(rec)
+			defer srv.Close()
+
+			statePath := filepath.Join(t.TempDir(), "notify.json")
+			data, _ := json.Marshal(notifyState{LastRunFailed: tt.lastFailed})
+			if err := os.WriteFile(statePath, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			w, err := NewWebhookNotifier(srv.URL, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newTestScheduler(
+				WithName("nightly"),
+				WithClock(NewSimClock(simStart)),
+				WithNotifier(w, RetryPolicy{Attempts: 3, Delay: time.Millisecond}, EventTaskFailed, EventRunFailed, EventRunRecovered),
+				WithNotifyStateFile(statePath),
+			)
+			if err := s.AddTask(&Task{ID: "a", Executor: ExecutorFunc, Func: tt.fn}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-s.Done():
+			case <-time.After(10 * time.Second):
+				t.Fatal("运行没有结束")
+			}
+			s.Stop()
+
+			var got []NotifyEvent
+			for _, p := range rec.payloads(t) {
+				got = append(got, p.Event)
+				// 通知带上运行的名称和调度器时钟的时间
+				if p.Run != "nightly" || !p.Time.Equal(simStart) {
+					t.Errorf("%s 通知的运行 = %q，时间 = %v；期望 nightly，%v", p.Event, p.Run, p.Time, simStart)
+				}
+			}
+			if len(got) != tt.wantRequests || !sameEvents(got, tt.want) {
+				t.Errorf("收到的事件 = %v，期望 %v", got, tt.want)
+			}
+			var state notifyState
+			data, _ = os.ReadFile(statePath)
+			if err := json.Unmarshal(data, &state); err != nil {
+				t.Fatal(err)
+			}
+			if state.LastRunFailed != tt.wantFailed {
+				t.Errorf("状态文件记录的失败 = %v，期望 %v", state.LastRunFailed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestCancelledTaskNotNotified(t *testing.T) {
+	tests := []struct {
+		name   string
+		before bool // 在任务开始之前取消
+	}{
+		{"运行中取消", false},
+		{"开始前取消", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &webhookRecorder{}
+			srv := httptest.NewServer(rec)
+			defer srv.Close()
+			w, err := NewWebhookNotifier(srv.URL, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			started := make(chan struct{})
+			s := newTestScheduler(WithMaxWorkers(1), WithNotifier(w, RetryPolicy{Attempts: 1}, EventTaskFailed))
+			s.AddTasks(
+				&Task{ID: "a", Executor: ExecutorFunc, Func: func(ctx context.Context, w io.Writer) error {
+					close(started)
+					<-ctx.Done()
+					return ctx.Err()
+				}},
+				&Task{ID: "b", Executor: ExecutorFunc, Func: func(ctx context.Context, w io.Writer) error { return nil }, Dependencies: []string{"a"}},
+			)
+			if err := s.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			<-started
+			target := "a"
+			if tt.before {
+				target = "b"
+			}
+			if err := s.CancelTask(target); err != nil {
+				t.Fatal(err)
+			}
+			if tt.before {
+				s.CancelTask("a")
+			}
+			select {
+			case <-s.Done():
+			case <-time.After(10 * time.Second):
+				t.Fatal("运行没有结束")
+			}
+			s.Stop()
+
+			if r := s.GetResults()[target]; r.Status != StatusCancelled {
+				t.Fatalf("任务 %s 状态 = %s，期望 %s", target, r.Status, StatusCancelled)
+			}
+			if got := rec.payloads(t); len(got) != 0 {
+				t.Errorf("手动取消的任务不应发送 task_failed，收到 %v", got)
+			}
+		})
+	}
+}
+
+// sameEvents 两组事件是否相同，不考虑顺序：任务级和运行级的通知是并发发送的
+func sameEvents(a, b []NotifyEvent) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
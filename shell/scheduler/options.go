@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Option 创建调度器时的配置，传给 NewScheduler
+//
+// 每个选项都对应一个 Set 方法。依赖调度器本身的配置（例如用 RunID 命名的工作区）
+// 可以在创建之后、Start 之前用 Set 方法设置
+type Option func(*Scheduler)
+
+// WithName 运行的名称，见 SetName
+func WithName(name string) Option {
+	return func(s *Scheduler) { s.SetName(name) }
+}
+
+// WithMaxWorkers 最大并发数，默认为 CPU 核数
+func WithMaxWorkers(n int) Option {
+	return func(s *Scheduler) {
+		if n > 0 {
+			s.maxWorkers = n
+		}
+	}
+}
+
+// WithLogger 日志记录器，默认为 slog.Default()，见 SetLogger
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Scheduler) { s.SetLogger(logger) }
+}
+
+// WithOutput 任务结果的输出位置，默认为标准输出，见 SetOutput
+func WithOutput(w io.Writer) Option {
+	return func(s *Scheduler) { s.SetOutput(w) }
+}
+
+// WithCache 输入指纹缓存，见 SetCache
+func WithCache(cache *TaskCache) Option {
+	return func(s *Scheduler) { s.SetCache(cache) }
+}
+
+// WithHistory 历史耗时，用于 SLA 检查，见 SetHistory
+func WithHistory(h *RunHistory) Option {
+	return func(s *Scheduler) { s.SetHistory(h) }
+}
+
+// WithWorkspace 临时工作区和产物目录，见 SetWorkspace
+func WithWorkspace(ws *Workspace) Option {
+	return func(s *Scheduler) { s.SetWorkspace(ws) }
+}
+
+// WithSecrets 秘密存储，见 SetSecrets
+func WithSecrets(store *SecretStore) Option {
+	return func(s *Scheduler) { s.SetSecrets(store) }
+}
+
+// WithRunTimeout 整个运行的时长限制，见 SetRunTimeout
+func WithRunTimeout(d time.Duration) Option {
+	return func(s *Scheduler) { s.SetRunTimeout(d) }
+}
+
+// WithProgress 定期输出运行进度，见 SetProgress
+func WithProgress(interval time.Duration) Option {
+	return func(s *Scheduler) { s.SetProgress(interval) }
+}
+
+// WithWatch 开启监听模式，见 SetWatch
+func WithWatch(opts WatchOptions) Option {
+	return func(s *Scheduler) { s.SetWatch(opts) }
+}
+
+// WithClock 替换时钟，见 SetClock
+func WithClock(clock Clock) Option {
+	return func(s *Scheduler) { s.SetClock(clock) }
+}
+
+// WithExecutor 注册（或替换）一个执行器，见 RegisterExecutor
+func WithExecutor(name string, executor Executor) Option {
+	return func(s *Scheduler) { s.RegisterExecutor(name, executor) }
+}
+
+// WithNotifier 添加通知器，见 AddNotifier
+func WithNotifier(n Notifier, retry RetryPolicy, events ...NotifyEvent) Option {
+	return func(s *Scheduler) { s.AddNotifier(n, retry, events...) }
+}
+
+// WithNotifyStateFile 上一次运行结果的记录文件，见 SetNotifyStateFile
+func WithNotifyStateFile(path string) Option {
+	return func(s *Scheduler) { s.SetNotifyStateFile(path) }
+}
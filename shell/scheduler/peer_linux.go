@@ -0,0 +1,18 @@
+package scheduler
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerUID 通过 SO_PEERCRED 取得 Unix socket 对端进程的用户 ID
+func peerUID(conn net.Conn) (int, error) {
+	return sockoptUID(conn, func(fd int) (int, error) {
+		cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+		if err != nil {
+			return 0, err
+		}
+		return int(cred.Uid), nil
+	})
+}
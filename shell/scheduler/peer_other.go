@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package scheduler
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID 其他平台无法取得 socket 对端的凭据
+func peerUID(conn net.Conn) (int, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,241 @@
+package scheduler
+
+import (
+	"fmt"
+)
+
+// KindPipeline 子流水线节点：不执行命令，把整条子流水线作为父流水线中的一个节点，
+// 状态由子任务汇总而来，见 Pipeline
+const KindPipeline TaskKind = "pipeline"
+
+// pipelineSeparator 子任务 ID 的命名空间分隔符，例如 build/lint
+const pipelineSeparator = "/"
+
+// Pipeline 一组任务定义，可以引入其他流水线，也可以作为子流水线嵌入到父流水线中
+//
+// Include 中的流水线直接展开到当前流水线里，任务 ID 不变，适合 setup、lint、teardown
+// 这类在多条流水线中共用的步骤。
+//
+// 作为子流水线嵌入时（Task{Kind: KindPipeline, Pipeline: p}），子任务的 ID 加上节点 ID
+// 作为前缀，例如节点 build 中的 lint 变为 build/lint，避免与其他任务冲突：
+//   - 子任务的依赖优先在同一条子流水线中查找，找不到时依次到外层查找，
+//     因此子任务可以直接依赖父流水线中的任务
+//   - 父流水线中的任务可以依赖整个节点（build），也可以依赖其中某个子任务（build/lint）
+//   - 节点自身的依赖和时间窗口会加到每个子任务上，节点在所有子任务结束后汇总状态
+//
+// 同一个 Pipeline 可以被引入或嵌入多次，展开时复制任务定义，不会修改原来的 Task
+type Pipeline struct {
+	Name    string           // 流水线名称，只用于错误信息
+	Tasks   []*Task          // 任务定义
+	Include []*Pipeline      // 引入的流水线，其任务展开到当前流水线中
+	Window  *ExecutionWindow // 流水线中没有设置 Window 的任务都使用它，包括引入的任务
+}
+
+// FailureChildFailed 子流水线中有任务没有成功
+const FailureChildFailed = "child_failed"
+
+// pipelineScope 展开子流水线时的命名空间，用于解析子任务的依赖
+type pipelineScope struct {
+	prefix string          // 本层任务 ID 的前缀，最外层为空
+	ids    map[string]bool // 本层的任务 ID（未加前缀），包括嵌套子流水线中的 a/b
+	parent *pipelineScope
+}
+
+// resolve 把依赖解析为完整的任务 ID：从内到外查找，都找不到时视为最外层的任务 ID
+func (sc *pipelineScope) resolve(dep string) string {
+	for scope := sc; scope != nil; scope = scope.parent {
+		if scope.ids[dep] {
+			return scope.prefix + dep
+		}
+	}
+	return dep
+}
+
+// AddPipeline 添加一条流水线中的全部任务，包括引入的流水线
+func (s *Scheduler) AddPipeline(p *Pipeline) error {
+	tasks, err := flattenPipeline(p, nil)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, def := range tasks {
+		task := *def
+		if err := s.addNode(&task, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flattenPipeline 展开 Include，返回流水线自身及引入的全部任务
+// stack 为正在展开的流水线，用于发现循环引入
+func flattenPipeline(p *Pipeline, stack []*Pipeline) ([]*Task, error) {
+	for _, q := range stack {
+		if q == p {
+			return nil, fmt.Errorf("流水线 %s 被循环引入", p.Name)
+		}
+	}
+	stack = append(stack, p)
+
+	var tasks []*Task
+	for _, inc := range p.Include {
+		included, err := flattenPipeline(inc, stack)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, included...)
+	}
+	tasks = append(tasks, p.Tasks...)
+	if p.Window == nil {
+		return tasks, nil
+	}
+	// 没有自己的时间窗口的任务继承流水线的，复制一份以免修改原来的定义
+	for i, task := range tasks {
+		if task.Window == nil {
+			copied := *task
+			copied.Window = p.Window
+			tasks[i] = &copied
+		}
+	}
+	return tasks, nil
+}
+
+// pipelineIDs 收集流水线中所有任务的相对 ID，嵌套子流水线中的任务记为 节点ID/子任务ID
+func pipelineIDs(tasks []*Task, ids map[string]bool, prefix string) error {
+	for _, task := range tasks {
+		ids[prefix+task.ID] = true
+		if task.Kind != KindPipeline || task.Pipeline == nil {
+			continue
+		}
+		children, err := flattenPipeline(task.Pipeline, nil)
+		if err != nil {
+			return err
+		}
+		if err := pipelineIDs(children, ids, prefix+task.ID+pipelineSeparator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNode 添加一个任务，子流水线节点先展开
+// 调用方需要持有 s.mu
+func (s *Scheduler) addNode(task *Task, scope *pipelineScope) error {
+	if task.Kind == KindPipeline {
+		return s.addPipelineNode(task, scope)
+	}
+	return s.addTask(task)
+}
+
+// addPipelineNode 展开子流水线节点：添加加上前缀的子任务，节点依赖全部子任务
+// 调用方需要持有 s.mu，node 的 ID 已经是完整的 ID
+func (s *Scheduler) addPipelineNode(node *Task, parent *pipelineScope) error {
+	if node.ID == "" {
+		return fmt.Errorf("子流水线节点没有设置 ID")
+	}
+	if node.Pipeline == nil {
+		return fmt.Errorf("子流水线节点 %s 没有设置 Pipeline", node.ID)
+	}
+	if node.Cmd != "" || node.Script != "" || len(node.Args) > 0 {
+		return fmt.Errorf("子流水线节点 %s 不能设置 Cmd/Args/Script", node.ID)
+	}
+	children, err := flattenPipeline(node.Pipeline, nil)
+	if err != nil {
+		return err
+	}
+	scope := &pipelineScope{prefix: node.ID + pipelineSeparator, ids: make(map[string]bool), parent: parent}
+	if err := pipelineIDs(children, scope.ids, ""); err != nil {
+		return err
+	}
+
+	// 节点自身的依赖由子任务继承，节点只等待子任务
+	inherited := node.Dependencies
+	node.Dependencies = nil
+	node.children = nil
+	if len(children) == 0 {
+		node.Dependencies = inherited
+	}
+	for _, def := range children {
+		if def.ID == "" {
+			return fmt.Errorf("子流水线 %s 中有任务没有设置 ID", node.ID)
+		}
+		child := *def
+		child.ID = scope.prefix + def.ID
+		child.Dependencies = make([]string, 0, len(def.Dependencies)+len(inherited))
+		for _, dep := range def.Dependencies {
+			child.Dependencies = append(child.Dependencies, scope.resolve(dep))
+		}
+		child.Dependencies = append(child.Dependencies, inherited...)
+		if child.Window == nil {
+			child.Window = node.Window
+		}
+
+		if err := s.addNode(&child, scope); err != nil {
+			return fmt.Errorf("子流水线 %s: %w", node.ID, err)
+		}
+		node.children = append(node.children, child.ID)
+		node.Dependencies = append(node.Dependencies, child.ID)
+	}
+	return s.addTask(node)
+}
+
+// pipelineResult 汇总子流水线节点的状态：
+// 任意子任务失败或超时则节点失败，否则有取消则取消，有跳过则跳过，有警告则警告，全部缓存命中则为缓存命中
+func (s *Scheduler) pipelineResult(task *Task) *TaskResult {
+	result := &TaskResult{
+		TaskID:   task.ID,
+		TaskName: task.Name,
+		Status:   StatusSuccess,
+	}
+
+	s.mu.Lock()
+	children := make([]*TaskResult, 0, len(task.children))
+	for _, id := range task.children {
+		if r := s.taskResults[id]; r != nil {
+			children = append(children, r)
+		}
+	}
+	s.mu.Unlock()
+
+	rank := map[TaskStatus]int{
+		StatusCached:    0,
+		StatusSuccess:   1,
+		StatusWarning:   2,
+		StatusSkipped:   3,
+		StatusCancelled: 4,
+		StatusTimeout:   5,
+		StatusFailed:    6,
+	}
+	if len(children) > 0 {
+		result.Status = StatusCached
+	}
+	var failed []string
+	for _, r := range children {
+		if result.StartTime.IsZero() || r.StartTime.Before(result.StartTime) {
+			result.StartTime = r.StartTime
+		}
+		if r.EndTime.After(result.EndTime) {
+			result.EndTime = r.EndTime
+		}
+		if rank[r.Status] > rank[result.Status] {
+			result.Status = r.Status
+		}
+		for _, w := range r.Warnings {
+			result.Warnings = append(result.Warnings, r.TaskID+": "+w)
+		}
+		if !r.Status.Succeeded() && r.Status != StatusSkipped {
+			failed = append(failed, r.TaskID)
+		}
+	}
+	if result.StartTime.IsZero() {
+		result.StartTime = s.clock.Now()
+		result.EndTime = result.StartTime
+	}
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	if len(failed) > 0 {
+		result.FailureReason = FailureChildFailed
+		result.Error = fmt.Errorf("子任务没有成功: %v", failed)
+	}
+	return result
+}
@@ -0,0 +1,359 @@
+package scheduler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pipelineFile 流水线文件的格式，例如：
+//
+//	name: build
+//	include: [common.yaml]
+//	tasks:
+//	  - id: lint
+//	    cmd: go vet ./...
+//	  - id: test
+//	    cmd: go test ./...
+//	    timeout: 10m
+//	    retry_count: 2
+//	    dependencies: [lint]
+//	  - id: db
+//	    kind: service
+//	    cmd: postgres -D data
+//	    probe:
+//	      tcp: 127.0.0.1:5432
+//	      timeout: 30s
+//	    limits:
+//	      memory_max: 1073741824
+//	  - id: integration
+//	    pipeline: integration.yaml
+//	    dependencies: [test, db]
+//	  - id: deploy
+//	    cmd: ./deploy.sh
+//	    dependencies: [integration]
+//	    window:
+//	      timezone: Asia/Shanghai
+//	      allowed:
+//	        - days: [mon, tue, wed, thu]
+//	          start: "22:00"
+//	          end: "06:00"
+//	      blackouts:
+//	        - date: 2026-10-01..2026-10-07
+//	          reason: 国庆
+//	      calendar: blackouts.txt
+//
+// include、pipeline、calendar 中的相对路径、以及任务的工作目录，都相对于流水线文件所在的目录
+type pipelineFile struct {
+	Name    string          `yaml:"name"`
+	Include []string        `yaml:"include"`
+	Window  *pipelineWindow `yaml:"window"` // 流水线中没有设置 window 的任务都使用它
+	Tasks   []pipelineTask  `yaml:"tasks"`
+}
+
+// pipelineTask 流水线文件中的一个任务，字段含义见 Task
+type pipelineTask struct {
+	ID           string          `yaml:"id"`
+	Name         string          `yaml:"name"`
+	Cmd          string          `yaml:"cmd"`
+	Args         []string        `yaml:"args"`
+	Script       string          `yaml:"script"`
+	Shell        string          `yaml:"shell"`
+	Interpreter  []string        `yaml:"interpreter"`
+	TTY          bool            `yaml:"tty"`
+	Timeout      time.Duration   `yaml:"timeout"`
+	TotalTimeout time.Duration   `yaml:"total_timeout"`
+	RetryCount   int             `yaml:"retry_count"`
+	RetryDelay   time.Duration   `yaml:"retry_delay"`
+	MaxOutput    int             `yaml:"max_output"`
+	Env          []string        `yaml:"env"`
+	Secrets      []string        `yaml:"secrets"`
+	WorkDir      string          `yaml:"workdir"`
+	Dependencies []string        `yaml:"dependencies"`
+	Inputs       []string        `yaml:"inputs"`
+	Outputs      []string        `yaml:"outputs"`
+	Artifacts    []string        `yaml:"artifacts"`
+	Limits       *pipelineLimits `yaml:"limits"`
+	Kind         TaskKind        `yaml:"kind"`
+	Probe        *pipelineProbe  `yaml:"probe"` // 服务任务的探针，仅 kind 为 service 时使用
+	Gate         *pipelineGate   `yaml:"gate"`
+	Pipeline     string          `yaml:"pipeline"` // 子流水线文件，设置后 kind 为 pipeline
+	Window       *pipelineWindow `yaml:"window"`
+
+	SuccessExitCodes []int    `yaml:"success_exit_codes"`
+	FailOnOutput     []string `yaml:"fail_on_output"`
+	SucceedOnOutput  []string `yaml:"succeed_on_output"`
+	WarnOnOutput     []string `yaml:"warn_on_output"`
+}
+
+// pipelineGate 审批节点的配置，见 Gate
+type pipelineGate struct {
+	Message   string            `yaml:"message"`
+	Timeout   time.Duration     `yaml:"timeout"`
+	OnTimeout GateTimeoutAction `yaml:"on_timeout"`
+}
+
+// pipelineProbe 服务任务的就绪/健康探针，见 Probe
+type pipelineProbe struct {
+	TCP            string        `yaml:"tcp"`
+	HTTP           string        `yaml:"http"`
+	LogPattern     string        `yaml:"log_pattern"`
+	Interval       time.Duration `yaml:"interval"`
+	Timeout        time.Duration `yaml:"timeout"`
+	HealthInterval time.Duration `yaml:"health_interval"`
+	HealthFailures int           `yaml:"health_failures"`
+}
+
+// pipelineLimits 资源限制，见 ResourceLimits，大小的单位都是字节
+type pipelineLimits struct {
+	CPUTime      time.Duration `yaml:"cpu_time"`
+	AddressSpace uint64        `yaml:"address_space"`
+	OpenFiles    uint64        `yaml:"open_files"`
+	MaxProcs     uint64        `yaml:"max_procs"`
+	MemoryMax    uint64        `yaml:"memory_max"`
+	CPUMax       float64       `yaml:"cpu_max"`
+}
+
+// pipelineWindow 时间窗口的配置，见 ExecutionWindow
+type pipelineWindow struct {
+	Timezone  string             `yaml:"timezone"`
+	Allowed   []pipelineTimeSlot `yaml:"allowed"`
+	Blackouts []pipelineBlackout `yaml:"blackouts"`
+	Calendar  string             `yaml:"calendar"` // 禁止时段日历文件，格式见 LoadBlackoutCalendar
+	Policy    WindowPolicy       `yaml:"policy"`
+}
+
+// pipelineTimeSlot 允许执行的时段，days 为 mon、tue 等星期的缩写
+type pipelineTimeSlot struct {
+	Days  []string `yaml:"days"`
+	Start string   `yaml:"start"`
+	End   string   `yaml:"end"`
+}
+
+// pipelineBlackout 禁止执行的时段，date 为整天（可以是 2026-10-01..2026-10-07 这样的范围），
+// 否则使用 start 和 end，格式为 RFC 3339 或 "2006-01-02 15:04"（按 timezone 解释）
+type pipelineBlackout struct {
+	Date   string `yaml:"date"`
+	Start  string `yaml:"start"`
+	End    string `yaml:"end"`
+	Reason string `yaml:"reason"`
+}
+
+// weekdays 星期的缩写和全称
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// executionWindow 把配置转换为 ExecutionWindow，resolve 用于解析日历文件的相对路径
+func (pw *pipelineWindow) executionWindow(resolve func(string) string) (*ExecutionWindow, error) {
+	if pw == nil {
+		return nil, nil
+	}
+	w := &ExecutionWindow{Policy: pw.Policy}
+	loc := time.Local
+	if pw.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(pw.Timezone); err != nil {
+			return nil, fmt.Errorf("无效的时区 %q: %w", pw.Timezone, err)
+		}
+		w.Location = loc
+	}
+	for _, slot := range pw.Allowed {
+		tw := TimeWindow{Start: slot.Start, End: slot.End}
+		for _, d := range slot.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return nil, fmt.Errorf("无效的星期 %q", d)
+			}
+			tw.Days = append(tw.Days, day)
+		}
+		w.Allowed = append(w.Allowed, tw)
+	}
+	for _, b := range pw.Blackouts {
+		blackout, err := b.blackout(loc)
+		if err != nil {
+			return nil, err
+		}
+		w.Blackouts = append(w.Blackouts, blackout)
+	}
+	if pw.Calendar != "" {
+		blackouts, err := LoadBlackoutCalendar(resolve(pw.Calendar), loc)
+		if err != nil {
+			return nil, fmt.Errorf("读取禁止时段日历失败: %w", err)
+		}
+		w.Blackouts = append(w.Blackouts, blackouts...)
+	}
+	return w, nil
+}
+
+// blackout 解析一个禁止时段，没有时区的时间按 loc 解释
+func (b pipelineBlackout) blackout(loc *time.Location) (Blackout, error) {
+	if b.Date != "" {
+		blackout, err := dateBlackout(b.Date, loc)
+		blackout.Reason = b.Reason
+		return blackout, err
+	}
+	parse := func(s string) (time.Time, error) {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, nil
+		}
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("无效的时间 %q", s)
+		}
+		return t, nil
+	}
+	start, err := parse(b.Start)
+	if err != nil {
+		return Blackout{}, err
+	}
+	end, err := parse(b.End)
+	if err != nil {
+		return Blackout{}, err
+	}
+	return Blackout{Start: start, End: end, Reason: b.Reason}, nil
+}
+
+// LoadPipelineFile 读取 YAML 格式的流水线文件，包括它引入和嵌入的其他文件
+func LoadPipelineFile(path string) (*Pipeline, error) {
+	return loadPipelineFile(path, nil)
+}
+
+// loadPipelineFile stack 为正在加载的文件，用于发现循环引用
+func loadPipelineFile(path string, stack []string) (*Pipeline, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range stack {
+		if p == path {
+			return nil, fmt.Errorf("流水线文件循环引用: %s", path)
+		}
+	}
+	stack = append(stack, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file pipelineFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	// 拼错的字段直接报错，而不是被悄悄忽略
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("解析流水线文件 %s 失败: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		// $WORKSPACE 开头的路径在运行时展开，见 withWorkspacePaths
+		if p == "" || filepath.IsAbs(p) || inWorkspace(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	p := &Pipeline{Name: file.Name}
+	if p.Name == "" {
+		p.Name = filepath.Base(path)
+	}
+	if p.Window, err = file.Window.executionWindow(resolve); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, inc := range file.Include {
+		included, err := loadPipelineFile(resolve(inc), stack)
+		if err != nil {
+			return nil, err
+		}
+		p.Include = append(p.Include, included)
+	}
+	for i, spec := range file.Tasks {
+		if spec.ID == "" {
+			return nil, fmt.Errorf("%s: 第 %d 个任务没有 id", path, i+1)
+		}
+		task := &Task{
+			ID:               spec.ID,
+			Name:             spec.Name,
+			Cmd:              spec.Cmd,
+			Args:             spec.Args,
+			Script:           spec.Script,
+			Shell:            spec.Shell,
+			Interpreter:      spec.Interpreter,
+			TTY:              spec.TTY,
+			Timeout:          spec.Timeout,
+			TotalTimeout:     spec.TotalTimeout,
+			RetryCount:       spec.RetryCount,
+			RetryDelay:       spec.RetryDelay,
+			MaxOutput:        spec.MaxOutput,
+			Env:              spec.Env,
+			Secrets:          spec.Secrets,
+			WorkDir:          resolve(spec.WorkDir),
+			Dependencies:     spec.Dependencies,
+			Inputs:           spec.Inputs,
+			Outputs:          spec.Outputs,
+			Artifacts:        spec.Artifacts,
+			Kind:             spec.Kind,
+			SuccessExitCodes: spec.SuccessExitCodes,
+			FailOnOutput:     spec.FailOnOutput,
+			SucceedOnOutput:  spec.SucceedOnOutput,
+			WarnOnOutput:     spec.WarnOnOutput,
+		}
+		if task.Name == "" {
+			task.Name = task.ID
+		}
+		// 没有指定工作目录时在流水线文件所在的目录执行，和从哪里提交的运行无关
+		if task.WorkDir == "" {
+			task.WorkDir = dir
+		}
+		if task.Window, err = spec.Window.executionWindow(resolve); err != nil {
+			return nil, fmt.Errorf("%s: 任务 %s: %w", path, spec.ID, err)
+		}
+		if spec.Probe != nil {
+			if spec.Kind != KindService {
+				return nil, fmt.Errorf("%s: 任务 %s: 只有 kind 为 service 的任务可以设置 probe", path, spec.ID)
+			}
+			task.Probe = &Probe{
+				TCP:            spec.Probe.TCP,
+				HTTP:           spec.Probe.HTTP,
+				LogPattern:     spec.Probe.LogPattern,
+				Interval:       spec.Probe.Interval,
+				Timeout:        spec.Probe.Timeout,
+				HealthInterval: spec.Probe.HealthInterval,
+				HealthFailures: spec.Probe.HealthFailures,
+			}
+		}
+		if l := spec.Limits; l != nil {
+			task.Limits = &ResourceLimits{
+				CPUTime:      l.CPUTime,
+				AddressSpace: l.AddressSpace,
+				OpenFiles:    l.OpenFiles,
+				MaxProcs:     l.MaxProcs,
+				MemoryMax:    l.MemoryMax,
+				CPUMax:       l.CPUMax,
+			}
+		}
+		if spec.Gate != nil {
+			task.Gate = &Gate{Message: spec.Gate.Message, Timeout: spec.Gate.Timeout, OnTimeout: spec.Gate.OnTimeout}
+		}
+		if spec.Pipeline != "" {
+			child, err := loadPipelineFile(resolve(spec.Pipeline), stack)
+			if err != nil {
+				return nil, err
+			}
+			task.Kind = KindPipeline
+			task.Pipeline = child
+		}
+		p.Tasks = append(p.Tasks, task)
+	}
+	return p, nil
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package scheduler
+
+import (
+	"os"
+	"os/exec"
+)
+
+// useProcessGroup 非 Unix 平台没有进程组，保持 exec 包默认的取消行为
+func useProcessGroup(cmd *exec.Cmd) {}
+
+// processAlive 判断本机上的进程是否还存在
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
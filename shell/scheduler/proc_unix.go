@@ -0,0 +1,37 @@
+//go:build unix
+
+package scheduler
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// useProcessGroup 让命令运行在独立的进程组中，取消时结束整个进程组
+//
+// sh -c 启动的脚本通常还会派生子进程，只杀 sh 本身时子进程会继续运行，
+// 并且一直持有输出管道，导致 Wait 要等到 WaitDelay 才能返回。
+// 取消时先向进程组发送 SIGTERM，让数据库等服务有机会正常退出，WaitDelay 之后仍未退出再 SIGKILL
+func useProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// 伪终端模式已经通过 Setsid 创建了新会话，新会话本身就是新的进程组，
+	// 此时再设置 Setpgid 会导致启动失败
+	if !cmd.SysProcAttr.Setsid {
+		cmd.SysProcAttr.Setpgid = true
+	}
+	cmd.Cancel = func() error {
+		pgid := -cmd.Process.Pid
+		if cmd.WaitDelay <= 0 {
+			return syscall.Kill(pgid, syscall.SIGKILL)
+		}
+		// WaitDelay 到期时 exec 包只会杀掉直接启动的进程，进程组中的其他进程由这里杀掉；
+		// 进程组已经全部退出时 Kill 只会返回 ESRCH
+		time.AfterFunc(cmd.WaitDelay, func() {
+			syscall.Kill(pgid, syscall.SIGKILL)
+		})
+		return syscall.Kill(pgid, syscall.SIGTERM)
+	}
+}
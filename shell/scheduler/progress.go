@@ -0,0 +1,237 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Progress 运行的整体进度，根据各任务历史耗时的中位数估算
+//
+// 没有历史记录的任务按有记录任务的平均耗时估算，此时 Estimated 为 false，ETA 仅供参考；
+// 所有任务都没有记录时按结束的任务数计算百分比，ETA 为 0
+type Progress struct {
+	Total        int       `json:"total"`
+	Finished     int       `json:"finished"`
+	Running      int       `json:"running"`
+	Percent      float64   `json:"percent"` // 0 到 100，按预计耗时加权
+	ElapsedMs    int64     `json:"elapsed_ms"`
+	ETAMs        int64     `json:"eta_ms"`                 // 剩余关键路径的预计耗时
+	EstimatedEnd time.Time `json:"estimated_end,omitzero"` // 预计结束的时间
+	Estimated    bool      `json:"estimated"`              // 还没结束的任务是否都有历史记录
+	Slow         []string  `json:"slow,omitempty"`         // 运行时间超过平时（历史 p95）的任务
+
+	eta       time.Duration
+	estimable bool // 至少有一个任务有历史记录
+}
+
+// ETA 剩余关键路径的预计耗时
+func (p Progress) ETA() time.Duration {
+	return p.eta
+}
+
+// Bar 宽度为 width 的进度条，例如 [#######-------]
+func (p Progress) Bar(width int) string {
+	width = max(width, 1)
+	filled := min(int(p.Percent/100*float64(width)), width)
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
+
+// String 一行进度，例如 [#####-----] 50%  3/6 已结束  预计剩余 1m20s
+func (p Progress) String() string {
+	s := fmt.Sprintf("%s %3.0f%%  %d/%d 已结束", p.Bar(20), p.Percent, p.Finished, p.Total)
+	eta := "预计剩余不到 1s"
+	if p.eta >= time.Second {
+		eta = "预计剩余 " + p.eta.Round(time.Second).String()
+	}
+	switch {
+	case p.Finished == p.Total:
+	case !p.estimable:
+		s += "  没有历史记录，无法估算剩余时间"
+	case p.Estimated:
+		s += "  " + eta
+	default:
+		s += "  " + eta + "（部分任务没有历史记录，仅供参考）"
+	}
+	if len(p.Slow) > 0 {
+		s += "  比平时慢: " + strings.Join(p.Slow, ", ")
+	}
+	return s
+}
+
+// Progress 计算当前的整体进度
+// ETA 是剩余关键路径的长度：每个任务的剩余耗时加上它依赖的任务中最晚结束的那个，不考虑并发数的限制
+func (s *Scheduler) Progress() Progress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	states := make(map[string]TaskState, len(s.tasks))
+	var known time.Duration
+	knownCount := 0
+	for id, task := range s.tasks {
+		st := s.stateLocked(id, task, now)
+		states[id] = st
+		if st.Expected > 0 {
+			known += st.Expected
+			knownCount++
+		}
+	}
+	var fallback time.Duration
+	if knownCount > 0 {
+		fallback = known / time.Duration(knownCount)
+	}
+
+	p := Progress{Total: len(states), Estimated: true}
+	if !s.startTime.IsZero() {
+		p.ElapsedMs = now.Sub(s.startTime).Milliseconds()
+	}
+	// expected 任务预计的总耗时，子流水线节点、服务和审批节点本身不计
+	expected := func(st TaskState) time.Duration {
+		if st.Kind != KindJob {
+			return 0
+		}
+		if st.Expected > 0 {
+			return st.Expected
+		}
+		if !st.Status.Finished() {
+			p.Estimated = false
+		}
+		return fallback
+	}
+	var total, done time.Duration
+	remaining := make(map[string]time.Duration, len(states))
+	for _, id := range slices.Sorted(maps.Keys(states)) {
+		st := states[id]
+		d := expected(st)
+		total += d
+		switch {
+		case st.Status.Finished():
+			p.Finished++
+			done += d
+		case st.Status == StatusRunning || st.Status == StatusWaitingApproval:
+			p.Running++
+			done += min(st.Elapsed, d)
+			remaining[id] = max(d-st.Elapsed, 0)
+			if st.Slow {
+				p.Slow = append(p.Slow, id)
+			}
+		default:
+			remaining[id] = d
+		}
+	}
+
+	switch {
+	case p.Finished == p.Total:
+		p.Percent = 100
+	case total > 0:
+		// 还有任务没结束时不显示 100%，例如任务都超过了预计耗时
+		p.Percent = min(float64(done)/float64(total)*100, 99)
+	case p.Total > 0:
+		p.Percent = float64(p.Finished) / float64(p.Total) * 100
+	}
+
+	// 每个任务预计结束的时间（相对现在），依赖已经结束的部分为 0
+	finishAt := make(map[string]time.Duration, len(states))
+	visiting := make(map[string]bool)
+	var finish func(id string) time.Duration
+	finish = func(id string) time.Duration {
+		if d, ok := finishAt[id]; ok {
+			return d
+		}
+		task, ok := s.tasks[id]
+		if !ok || visiting[id] {
+			// 依赖不存在或出现环，交给 checkDependencies 报错，这里只要不死循环
+			return 0
+		}
+		visiting[id] = true
+		var before time.Duration
+		for _, dep := range task.Dependencies {
+			before = max(before, finish(dep))
+		}
+		// 子流水线节点在所有子任务结束后才结束
+		for _, child := range task.children {
+			before = max(before, finish(child))
+		}
+		visiting[id] = false
+		d := before + remaining[id]
+		finishAt[id] = d
+		return d
+	}
+	for id := range states {
+		p.eta = max(p.eta, finish(id))
+	}
+	p.estimable = total > 0
+	if !p.estimable {
+		p.eta = 0
+	}
+	p.ETAMs = p.eta.Milliseconds()
+	if p.eta > 0 {
+		p.EstimatedEnd = now.Add(p.eta)
+	}
+	return p
+}
+
+// SetProgress 运行期间每隔 interval 输出一行进度，并在任务运行时间超过历史 p95 时提示一次，为 0 时不输出
+// 需要在 Start 之前调用
+func (s *Scheduler) SetProgress(interval time.Duration) {
+	s.progressEvery = interval
+}
+
+// progressLoop 定期输出进度，运行结束或调度器停止后退出
+func (s *Scheduler) progressLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.progressEvery)
+	defer ticker.Stop()
+	warned := make(map[string]bool)
+	last := ""
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		p := s.Progress()
+		for _, id := range p.Slow {
+			if warned[id] {
+				continue
+			}
+			warned[id] = true
+			if st, ok := s.TaskState(id); ok {
+				s.logger.Warn("任务运行时间超过平时", logKeyTaskID, id, "elapsed", st.Elapsed.Round(time.Second), "p95", st.P95)
+			}
+		}
+		// 没有变化时不重复输出，例如监听模式下等待输入变化
+		if line := p.String(); line != last {
+			last = line
+			fmt.Fprintf(s.out, "进度 %s\n", line)
+		}
+	}
+}
+
+// statusResponse 状态 API 的响应
+type statusResponse struct {
+	RunID    string        `json:"run_id"`
+	Progress Progress      `json:"progress"`
+	Tasks    []ControlTask `json:"tasks"`
+}
+
+// HandleStatus 在 mux 上注册状态 API
+//
+//	GET /status  整体进度、预计剩余时间和各任务的状态
+func (s *Scheduler) HandleStatus(mux *http.ServeMux) {
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		resp := statusResponse{RunID: s.runID, Progress: s.Progress()}
+		for _, st := range s.Snapshot() {
+			resp.Tasks = append(resp.Tasks, newControlTask(st))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
@@ -0,0 +1,73 @@
+package scheduler
+
+import "io"
+
+// TTYSize 伪终端的窗口大小
+type TTYSize struct {
+	Cols uint16
+	Rows uint16
+}
+
+// 默认的伪终端窗口大小
+var defaultTTYSize = TTYSize{Cols: 120, Rows: 40}
+
+// ansiStripper 去掉输出中的 ANSI 控制序列（颜色、光标移动、窗口标题等）
+//
+// 控制序列可能被拆到两次 Write 中，所以需要记住解析到一半的状态
+type ansiStripper struct {
+	w     io.Writer
+	state int
+}
+
+const (
+	ansiText = iota
+	ansiEsc  // 读到 ESC
+	ansiCSI  // ESC [ ... 直到 0x40-0x7e 结束
+	ansiOSC  // ESC ] ... 直到 BEL 或 ESC \ 结束
+	ansiOSCEsc
+)
+
+func newANSIStripper(w io.Writer) *ansiStripper {
+	return &ansiStripper{w: w}
+}
+
+func (a *ansiStripper) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, c := range p {
+		switch a.state {
+		case ansiText:
+			if c == 0x1b {
+				a.state = ansiEsc
+			} else {
+				out = append(out, c)
+			}
+		case ansiEsc:
+			switch c {
+			case '[':
+				a.state = ansiCSI
+			case ']':
+				a.state = ansiOSC
+			default:
+				// 其他两字节序列，例如 ESC = 、ESC ( B
+				a.state = ansiText
+			}
+		case ansiCSI:
+			if c >= 0x40 && c <= 0x7e {
+				a.state = ansiText
+			}
+		case ansiOSC:
+			switch c {
+			case 0x07:
+				a.state = ansiText
+			case 0x1b:
+				a.state = ansiOSCEsc
+			}
+		case ansiOSCEsc:
+			a.state = ansiText
+		}
+	}
+	if _, err := a.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptyState 一次执行使用的伪终端
+type ptyState struct {
+	master *os.File
+	slave  *os.File
+	copied chan struct{}
+}
+
+// openPTY 创建伪终端并让命令把它作为标准输入输出和控制终端
+// 命令的 stdout 和 stderr 都写到同一个终端上，无法再区分，统一作为 STDOUT 记录
+func openPTY(cmd *exec.Cmd, size *TTYSize) (*ptyState, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("打开伪终端失败: %w", err)
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("解锁伪终端失败: %w", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("获取伪终端编号失败: %w", err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("打开伪终端失败: %w", err)
+	}
+
+	if size == nil {
+		size = &defaultTTYSize
+	}
+	ws := &unix.Winsize{Col: size.Cols, Row: size.Rows}
+	if err := unix.IoctlSetWinsize(int(slave.Fd()), unix.TIOCSWINSZ, ws); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, fmt.Errorf("设置窗口大小失败: %w", err)
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// 新会话并把终端设为控制终端（子进程中的 0 号 fd），
+	// 新会话本身就是新的进程组，取消时同样可以杀掉整个进程组
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+	return &ptyState{master: master, slave: slave, copied: make(chan struct{})}, nil
+}
+
+// started 命令启动后，父进程关闭自己持有的从端，并开始把终端输出拷贝到 out
+func (p *ptyState) started(out io.Writer) {
+	p.slave.Close()
+	go func() {
+		defer close(p.copied)
+		// 所有持有从端的进程退出后，读主端会返回 EIO，这是正常的结束
+		_, err := io.Copy(out, p.master)
+		if err != nil && !errors.Is(err, syscall.EIO) && !errors.Is(err, os.ErrClosed) {
+			fmt.Fprintf(out, "读取伪终端失败: %v\n", err)
+		}
+	}()
+}
+
+// finish 命令结束后等待输出拷贝完成
+// 孙进程可能还持有从端，最多再等 waitDelay 就强制关闭
+func (p *ptyState) finish(waitDelay time.Duration) {
+	select {
+	case <-p.copied:
+	case <-time.After(waitDelay):
+		p.master.Close()
+		<-p.copied
+		return
+	}
+	p.master.Close()
+}
+
+// close 命令没能启动时释放伪终端
+func (p *ptyState) close() {
+	p.slave.Close()
+	p.master.Close()
+}
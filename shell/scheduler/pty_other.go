@@ -0,0 +1,23 @@
+//go:build !linux
+
+package scheduler
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// ptyState 非 Linux 平台上不支持伪终端
+type ptyState struct{}
+
+func openPTY(cmd *exec.Cmd, size *TTYSize) (*ptyState, error) {
+	return nil, errors.New("TTY 模式仅支持 Linux")
+}
+
+func (p *ptyState) started(out io.Writer) {}
+
+func (p *ptyState) finish(waitDelay time.Duration) {}
+
+func (p *ptyState) close() {}
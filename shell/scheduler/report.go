@@ -0,0 +1,488 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TaskReport 单个任务在报告中的表示
+// 与 TaskResult 不同，这里的字段都是可以直接序列化的基础类型
+type TaskReport struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	StartTime  time.Time `json:"start_time,omitzero"`
+	EndTime    time.Time `json:"end_time,omitzero"`
+	DurationMs int64     `json:"duration_ms"`
+	ExitCode   int       `json:"exit_code"`
+	RetryCount int       `json:"retry_count"`
+	Error      string    `json:"error,omitempty"`
+	// FailureReason 资源限制等导致失败的具体原因
+	FailureReason string    `json:"failure_reason,omitempty"`
+	Warnings      []string  `json:"warnings,omitempty"`
+	Approval      *Approval `json:"approval,omitempty"`
+	// P95Ms 历史耗时的 p95，SLAExceeded 表示本次超过了它
+	P95Ms       int64      `json:"p95_ms,omitempty"`
+	SLAExceeded bool       `json:"sla_exceeded,omitempty"`
+	Artifacts   []Artifact `json:"artifacts,omitempty"`
+	Output      string     `json:"output,omitempty"`
+
+	status   TaskStatus
+	duration time.Duration
+}
+
+// RunReport 一次调度运行的完整报告
+type RunReport struct {
+	Name       string    `json:"name"`
+	StartTime  time.Time `json:"start_time,omitzero"`
+	EndTime    time.Time `json:"end_time,omitzero"`
+	DurationMs int64     `json:"duration_ms"`
+	Total      int       `json:"total"`
+	Success    int       `json:"success"`
+	Warnings   int       `json:"warnings"`
+	Failed     int       `json:"failed"`
+	Skipped    int       `json:"skipped"` // 没有执行：待处理或者还在等待时间窗口
+	// OutsideWindow 按时间窗口策略跳过的任务数，不算失败
+	OutsideWindow int `json:"outside_window,omitempty"`
+	// WaitingApproval 运行被中断时仍在等待审批的节点数
+	WaitingApproval int `json:"waiting_approval,omitempty"`
+	// RunDir 本次运行的目录，产物在其中的 artifacts 下
+	RunDir string        `json:"run_dir,omitempty"`
+	Tasks  []*TaskReport `json:"tasks"`
+
+	duration time.Duration
+}
+
+// BuildReport 根据当前的任务和结果生成报告
+// 没有结果的任务（例如被中断前还没来得及执行）也会出现在报告中，状态为待处理
+func (s *Scheduler) BuildReport(name string) *RunReport {
+	s.mu.Lock()
+	tasks := make([]*Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task)
+	}
+	waiting := make(map[string]time.Time, len(s.gates))
+	for id, p := range s.gates {
+		waiting[id] = p.since
+	}
+	windowWaits := make(map[string]time.Time, len(s.windowWaits))
+	for id, w := range s.windowWaits {
+		windowWaits[id] = w.since
+	}
+	s.mu.Unlock()
+	results := s.GetResults()
+
+	report := &RunReport{Name: name}
+	if s.workspace != nil {
+		report.RunDir = s.workspace.RunDir
+	}
+	for _, task := range tasks {
+		tr := &TaskReport{ID: task.ID, Name: task.Name, Status: StatusPending.Code(), status: StatusPending}
+		if result, ok := results[task.ID]; ok {
+			tr.status = result.Status
+			tr.Status = result.Status.Code()
+			tr.StartTime = result.StartTime
+			tr.EndTime = result.EndTime
+			tr.duration = result.Duration
+			tr.DurationMs = result.Duration.Milliseconds()
+			tr.ExitCode = result.ExitCode
+			tr.RetryCount = result.RetryCount
+			tr.Output = result.Output
+			tr.FailureReason = result.FailureReason
+			tr.Warnings = result.Warnings
+			tr.Approval = result.Approval
+			tr.P95Ms = result.P95.Milliseconds()
+			tr.SLAExceeded = result.SLAExceeded
+			tr.Artifacts = result.Artifacts
+			if result.Error != nil {
+				tr.Error = result.Error.Error()
+			}
+		} else if since, ok := waiting[task.ID]; ok {
+			tr.status = StatusWaitingApproval
+			tr.Status = StatusWaitingApproval.Code()
+			tr.StartTime = since
+		} else if since, ok := windowWaits[task.ID]; ok {
+			tr.status = StatusWaitingWindow
+			tr.Status = StatusWaitingWindow.Code()
+			tr.StartTime = since
+		}
+		report.Tasks = append(report.Tasks, tr)
+	}
+
+	// 按开始时间排序，未执行的任务放在最后
+	sort.SliceStable(report.Tasks, func(i, j int) bool {
+		a, b := report.Tasks[i], report.Tasks[j]
+		if a.StartTime.IsZero() != b.StartTime.IsZero() {
+			return !a.StartTime.IsZero()
+		}
+		if !a.StartTime.Equal(b.StartTime) {
+			return a.StartTime.Before(b.StartTime)
+		}
+		return a.ID < b.ID
+	})
+
+	for _, tr := range report.Tasks {
+		report.Total++
+		switch {
+		case tr.status.Succeeded():
+			report.Success++
+			if tr.status == StatusWarning {
+				report.Warnings++
+			}
+		case tr.status == StatusPending, tr.status == StatusWaitingWindow:
+			report.Skipped++
+		case tr.status == StatusSkipped:
+			report.OutsideWindow++
+		case tr.status == StatusWaitingApproval:
+			report.WaitingApproval++
+		default:
+			report.Failed++
+		}
+		if tr.StartTime.IsZero() {
+			continue
+		}
+		if report.StartTime.IsZero() || tr.StartTime.Before(report.StartTime) {
+			report.StartTime = tr.StartTime
+		}
+		if tr.EndTime.After(report.EndTime) {
+			report.EndTime = tr.EndTime
+		}
+	}
+	if !report.StartTime.IsZero() {
+		report.duration = report.EndTime.Sub(report.StartTime)
+		report.DurationMs = report.duration.Milliseconds()
+	}
+	return report
+}
+
+// reportWriters 支持的报告格式
+var reportWriters = map[string]func(w io.Writer, r *RunReport) error{
+	"json":     writeJSONReport,
+	"junit":    writeJUnitReport,
+	"markdown": writeMarkdownReport,
+	"md":       writeMarkdownReport,
+	"html":     writeHTMLReport,
+}
+
+// ReportTarget 一个报告输出目标，Path 为空时输出到标准输出
+type ReportTarget struct {
+	Format string
+	Path   string
+}
+
+// ParseReportTarget 解析 format 或 format=path 形式的报告目标，例如 junit=out/junit.xml
+func ParseReportTarget(value string) (ReportTarget, error) {
+	format, path, _ := strings.Cut(value, "=")
+	format = strings.ToLower(strings.TrimSpace(format))
+	if _, ok := reportWriters[format]; !ok {
+		return ReportTarget{}, fmt.Errorf("不支持的报告格式 %q (可选: json, junit, markdown, html)", format)
+	}
+	return ReportTarget{Format: format, Path: strings.TrimSpace(path)}, nil
+}
+
+// WriteReport 按指定格式把报告写到目标位置
+func WriteReport(report *RunReport, target ReportTarget) error {
+	write, ok := reportWriters[target.Format]
+	if !ok {
+		return fmt.Errorf("不支持的报告格式 %q", target.Format)
+	}
+	if target.Path == "" {
+		return write(os.Stdout, report)
+	}
+
+	if dir := filepath.Dir(target.Path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(target.Path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeJSONReport 输出 JSON 报告
+func writeJSONReport(w io.Writer, r *RunReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// JUnit XML 结构，字段参考 CI 系统普遍支持的 surefire 格式
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Name    string           `xml:"name,attr"`
+	Tests   int              `xml:"tests,attr"`
+	Failed  int              `xml:"failures,attr"`
+	Skipped int              `xml:"skipped,attr"`
+	Time    string           `xml:"time,attr"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failed    int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// junitSeconds JUnit 中的时间单位是秒
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// writeJUnitReport 输出 JUnit XML 报告
+// 每个任务对应一个 testcase，失败任务的输出作为 failure 的正文，CI 上可以直接看到
+func writeJUnitReport(w io.Writer, r *RunReport) error {
+	suite := junitTestSuite{
+		Name:    r.Name,
+		Tests:   r.Total,
+		Failed:  r.Failed,
+		Skipped: r.Skipped + r.OutsideWindow + r.WaitingApproval,
+		Time:    junitSeconds(r.duration),
+	}
+	if !r.StartTime.IsZero() {
+		suite.Timestamp = r.StartTime.Format(time.RFC3339)
+	}
+	for _, t := range r.Tasks {
+		tc := junitTestCase{
+			Name:      t.Name,
+			ClassName: r.Name + "." + t.ID,
+			Time:      junitSeconds(t.duration),
+		}
+		switch {
+		case t.status.Succeeded():
+			tc.SystemOut = t.Output
+			if len(t.Warnings) > 0 {
+				tc.SystemErr = "warnings:\n" + strings.Join(t.Warnings, "\n")
+			}
+		case t.status == StatusPending:
+			tc.Skipped = &junitSkipped{Message: "任务未执行"}
+		case t.status == StatusWaitingApproval:
+			tc.Skipped = &junitSkipped{Message: "等待审批"}
+		case t.status == StatusWaitingWindow:
+			tc.Skipped = &junitSkipped{Message: "等待时间窗口"}
+		case t.status == StatusSkipped:
+			tc.Skipped = &junitSkipped{Message: t.Error}
+		default:
+			message := t.Error
+			if t.FailureReason != "" {
+				message = t.FailureReason + ": " + message
+			}
+			if message == "" {
+				message = fmt.Sprintf("exit code %d", t.ExitCode)
+			}
+			tc.Failure = &junitFailure{Message: message, Type: t.Status, Text: t.Output}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	doc := junitTestSuites{
+		Name:    r.Name,
+		Tests:   suite.Tests,
+		Failed:  suite.Failed,
+		Skipped: suite.Skipped,
+		Time:    suite.Time,
+		Suites:  []junitTestSuite{suite},
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// markdownEscape 转义表格单元格中会破坏结构的字符
+func markdownEscape(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	s = strings.ReplaceAll(s, "\r\n", " ")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// markdownFence 选择一个不会和内容冲突的代码块围栏
+func markdownFence(content string) string {
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+// writeMarkdownReport 输出 Markdown 报告，适合直接贴到 PR 评论里
+func writeMarkdownReport(w io.Writer, r *RunReport) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n\n", markdownEscape(r.Name))
+	fmt.Fprintf(&b, "**%d** tasks: **%d** passed (%d with warnings), **%d** failed, **%d** skipped in %v\n\n",
+		r.Total, r.Success, r.Warnings, r.Failed, r.Skipped+r.OutsideWindow, r.duration.Round(time.Millisecond))
+
+	b.WriteString("| Task | ID | Status | Duration | Exit code | Retries |\n")
+	b.WriteString("| --- | --- | --- | ---: | ---: | ---: |\n")
+	for _, t := range r.Tasks {
+		fmt.Fprintf(&b, "| %s | `%s` | %s | %v | %d | %d |\n",
+			markdownEscape(t.Name), markdownEscape(t.ID), t.Status,
+			t.duration.Round(time.Millisecond), t.ExitCode, t.RetryCount)
+	}
+
+	// 产物列表，校验和只显示前 12 位，完整的在 SHA256SUMS 中
+	var artifacts []string
+	for _, t := range r.Tasks {
+		for _, a := range t.Artifacts {
+			artifacts = append(artifacts, fmt.Sprintf("| `%s` | `%s` | %d | `%s` |\n",
+				markdownEscape(t.ID), markdownEscape(a.Path), a.Size, a.SHA256[:12]))
+		}
+	}
+	if len(artifacts) > 0 {
+		b.WriteString("\n### Artifacts\n\n")
+		b.WriteString("| Task | Path | Size | SHA256 |\n")
+		b.WriteString("| --- | --- | ---: | --- |\n")
+		for _, row := range artifacts {
+			b.WriteString(row)
+		}
+	}
+
+	// 失败任务的错误和输出、成功任务的警告放在折叠块中，避免评论过长
+	for _, t := range r.Tasks {
+		if t.status == StatusWarning {
+			fmt.Fprintf(&b, "\n<details>\n<summary>%s (%s)</summary>\n\n", template.HTMLEscapeString(t.Name), t.Status)
+			fence := markdownFence(strings.Join(t.Warnings, "\n"))
+			fmt.Fprintf(&b, "%s\n%s\n%s\n", fence, strings.Join(t.Warnings, "\n"), fence)
+			b.WriteString("\n</details>\n")
+			continue
+		}
+		if t.status.Succeeded() || !t.status.Finished() || t.status == StatusSkipped {
+			continue
+		}
+		fmt.Fprintf(&b, "\n<details>\n<summary>%s (%s)</summary>\n\n", template.HTMLEscapeString(t.Name), t.Status)
+		if t.Error != "" {
+			fmt.Fprintf(&b, "Error: `%s`\n\n", strings.ReplaceAll(t.Error, "`", "'"))
+		}
+		if t.FailureReason != "" {
+			fmt.Fprintf(&b, "Failure reason: `%s`\n\n", t.FailureReason)
+		}
+		if t.Output != "" {
+			fence := markdownFence(t.Output)
+			fmt.Fprintf(&b, "%s\n%s\n%s\n", fence, strings.TrimRight(t.Output, "\n"), fence)
+		}
+		b.WriteString("\n</details>\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// htmlReportTemplate 独立的 HTML 页面，样式内联，不依赖任何外部资源
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms":  func(d time.Duration) time.Duration { return d.Round(time.Millisecond) },
+	"add": func(a, b int) int { return a + b },
+	"datetime": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.DateTime)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "PingFang SC", sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ddd; padding: 6px 10px; text-align: left; }
+th { background: #f5f5f5; }
+.success, .cached { color: #1a7f37; font-weight: bold; }
+.pending { color: #888; }
+.warning { color: #9a6700; font-weight: bold; }
+.failed, .timeout, .cancelled { color: #cf222e; font-weight: bold; }
+pre { background: #f6f8fa; padding: 1em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>{{.Total}} tasks: {{.Success}} passed ({{.Warnings}} with warnings), {{.Failed}} failed, {{add .Skipped .OutsideWindow}} skipped in {{ms .RunDuration}}</p>
+<table>
+<tr><th>Task</th><th>ID</th><th>Status</th><th>Start</th><th>Duration</th><th>Exit code</th><th>Retries</th></tr>
+{{range .Tasks}}<tr>
+<td>{{.Name}}</td><td><code>{{.ID}}</code></td><td class="{{.Status}}">{{.Status}}</td>
+<td>{{datetime .StartTime}}</td><td>{{ms .Duration}}</td><td>{{.ExitCode}}</td><td>{{.RetryCount}}</td>
+</tr>
+{{end}}</table>
+{{if .HasArtifacts}}<h2>Artifacts</h2>
+{{if .RunDir}}<p><code>{{.RunDir}}/artifacts</code></p>{{end}}
+<table>
+<tr><th>Task</th><th>Path</th><th>Size</th><th>SHA256</th></tr>
+{{range .Tasks}}{{$id := .ID}}{{range .Artifacts}}<tr><td><code>{{$id}}</code></td><td><code>{{.Path}}</code></td><td>{{.Size}}</td><td><code>{{.SHA256}}</code></td></tr>
+{{end}}{{end}}</table>
+{{end}}
+{{range .Tasks}}{{if or .Error .Output .Warnings}}
+<h3>{{.Name}} <span class="{{.Status}}">{{.Status}}</span></h3>
+{{if .Error}}<p>Error: <code>{{.Error}}</code></p>{{end}}
+{{if .FailureReason}}<p>Failure reason: <code>{{.FailureReason}}</code></p>{{end}}
+{{if .Warnings}}<p>Warnings:</p><ul>{{range .Warnings}}<li><code>{{.}}</code></li>{{end}}</ul>{{end}}
+{{if .Output}}<pre>{{.Output}}</pre>{{end}}
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// writeHTMLReport 输出独立的 HTML 报告
+func writeHTMLReport(w io.Writer, r *RunReport) error {
+	type htmlTask struct {
+		*TaskReport
+		Duration time.Duration
+	}
+	data := struct {
+		*RunReport
+		RunDuration  time.Duration
+		HasArtifacts bool
+		Tasks        []htmlTask
+	}{RunReport: r, RunDuration: r.duration}
+	for _, t := range r.Tasks {
+		data.Tasks = append(data.Tasks, htmlTask{TaskReport: t, Duration: t.duration})
+		if len(t.Artifacts) > 0 {
+			data.HasArtifacts = true
+		}
+	}
+	return htmlReportTemplate.Execute(w, data)
+}
@@ -0,0 +1,1032 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// TaskStatus 任务状态
+type TaskStatus int
+
+const (
+	StatusPending         TaskStatus = iota // 0
+	StatusRunning                           // 1
+	StatusSuccess                           // 2
+	StatusFailed                            // 3
+	StatusTimeout                           // 4
+	StatusCancelled                         // 5
+	StatusCached                            // 6 输入未变化，直接使用缓存结果
+	StatusWarning                           // 7 成功，但输出中出现了警告
+	StatusWaitingApproval                   // 8 审批节点正在等待人工审批
+	StatusWaitingWindow                     // 9 就绪但不在允许的时间窗口内（或处于冻结中），等待开始
+	StatusSkipped                           // 10 不在允许的时间窗口内，按策略跳过
+)
+
+func (s TaskStatus) String() string {
+	switch s {
+	case StatusPending:
+		return "待处理"
+	case StatusRunning:
+		return "运行中"
+	case StatusSuccess:
+		return "成功"
+	case StatusFailed:
+		return "失败"
+	case StatusTimeout:
+		return "超时"
+	case StatusCancelled:
+		return "取消"
+	case StatusCached:
+		return "缓存命中"
+	case StatusWarning:
+		return "警告"
+	case StatusWaitingApproval:
+		return "等待审批"
+	case StatusWaitingWindow:
+		return "等待时间窗口"
+	case StatusSkipped:
+		return "跳过"
+	default:
+		return "未知"
+	}
+}
+
+// Code 返回状态的英文标识，用于 JSON、JUnit 等机器可读的报告
+func (s TaskStatus) Code() string {
+	switch s {
+	case StatusPending:
+		return "pending"
+	case StatusRunning:
+		return "running"
+	case StatusSuccess:
+		return "success"
+	case StatusFailed:
+		return "failed"
+	case StatusTimeout:
+		return "timeout"
+	case StatusCancelled:
+		return "cancelled"
+	case StatusCached:
+		return "cached"
+	case StatusWarning:
+		return "warning"
+	case StatusWaitingApproval:
+		return "waiting-approval"
+	case StatusWaitingWindow:
+		return "waiting-window"
+	case StatusSkipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
+// Succeeded 任务是否算作成功，缓存命中和带警告的成功都算
+func (s TaskStatus) Succeeded() bool {
+	return s == StatusSuccess || s == StatusCached || s == StatusWarning
+}
+
+// Finished 任务是否已经结束（成功、失败或跳过），待处理、运行中和等待中都不算
+func (s TaskStatus) Finished() bool {
+	return s != StatusPending && s != StatusRunning && s != StatusWaitingApproval && s != StatusWaitingWindow
+}
+
+// Task 任务定义
+type Task struct {
+	ID           string           // 任务ID
+	Name         string           // 任务名称
+	Cmd          string           // 执行命令
+	Args         []string         // 命令参数
+	Script       string           // 多行脚本，与 Cmd/Args 二选一，写入工作目录下的临时文件后执行
+	Shell        string           // 执行 Script 的解释器：bash（默认）、sh、python、node
+	Interpreter  []string         // 自定义解释器命令行，脚本路径追加在最后，优先于 Shell
+	TTY          bool             // 在伪终端中运行，stdout 和 stderr 合并记录，仅 shell 执行器、仅 Linux
+	TTYSize      *TTYSize         // 伪终端的窗口大小，默认 120x40
+	KeepANSI     bool             // TTY 模式下在输出中保留 ANSI 控制序列，默认去掉
+	Timeout      time.Duration    // 单次执行的超时时间
+	TotalTimeout time.Duration    // 所有重试加起来的总时长限制，为 0 时不限制
+	RetryCount   int              // 重试次数
+	RetryDelay   time.Duration    // 重试延迟
+	MaxOutput    int              // 最大输出行数
+	Env          []string         // 环境变量
+	Secrets      []string         // 需要的秘密名称，以同名环境变量注入，输出中出现的值会被遮盖
+	WorkDir      string           // 工作目录，可以使用 $WORKSPACE
+	Dependencies []string         // 依赖的任务ID
+	Inputs       []string         // 输入文件、目录或 glob，可以使用 $WORKSPACE，声明后才会参与缓存（func、git 执行器除外）
+	Outputs      []string         // 输出路径，可以使用 $WORKSPACE，缓存命中时从缓存目录恢复
+	Artifacts    []string         // 产物文件、目录或 glob，可以使用 $WORKSPACE，结束后复制到运行目录的产物目录
+	Executor     string           // 执行器类型：shell（默认）、http、func 或自行注册的名称
+	HTTP         *HTTPRequest     // http 执行器的请求定义
+	Git          *GitCheckout     // git 执行器的检出定义
+	Func         TaskFunc         // func 执行器调用的函数
+	Limits       *ResourceLimits  // 资源限制，仅 shell 执行器、仅 Linux
+	RunAs        *RunAs           // 以指定用户运行，仅 shell 执行器、仅 Linux
+	Kind         TaskKind         // 任务类型，默认为运行到结束的普通任务
+	Probe        *Probe           // 服务任务的就绪/健康探针，为空时启动即视为就绪
+	Gate         *Gate            // 审批节点的配置，仅 Kind 为 gate 时使用
+	Pipeline     *Pipeline        // 子流水线，仅 Kind 为 pipeline 时使用
+	Window       *ExecutionWindow // 允许开始执行的时间，为空时不限制
+
+	SuccessExitCodes []int    // 视为成功的退出码，默认只有 0
+	FailOnOutput     []string // 输出中出现匹配的行即判定失败，并立即结束本次执行
+	SucceedOnOutput  []string // 设置后，输出中必须出现匹配的行才算成功
+	WarnOnOutput     []string // 成功但输出中出现匹配的行时，状态记为警告
+
+	criteria *outputCriteria // AddTask 时编译好的判定规则
+	children []string        // 子流水线节点展开后的子任务 ID
+}
+
+// TaskResult 任务执行结果
+type TaskResult struct {
+	TaskID     string        // 任务ID
+	TaskName   string        // 任务名称
+	Status     TaskStatus    // 任务状态
+	StartTime  time.Time     // 开始时间
+	EndTime    time.Time     // 结束时间
+	Duration   time.Duration // 持续时间
+	ExitCode   int           // 退出code
+	Output     string        // 输出内容
+	Error      error         // 错误信息
+	RetryCount int           // 重试次数
+	// FailureReason 失败的具体原因，目前用于区分资源限制导致的终止，例如 cpu_time_limit
+	FailureReason string
+	Warnings      []string   // 匹配 WarnOnOutput 的输出行
+	Approval      *Approval  // 审批节点的审批结果
+	Artifacts     []Artifact // 收集到的产物
+	// P95 任务历史耗时的 p95，没有足够的历史记录时为 0
+	P95 time.Duration
+	// SLAExceeded 本次耗时超过了历史 p95
+	SLAExceeded bool
+}
+
+// Scheduler 调度器
+type Scheduler struct {
+	maxWorkers      int                       // 最大并发数
+	tasks           map[string]*Task          // 所有任务
+	taskResults     map[string]*TaskResult    // 所有任务结果
+	taskQueue       chan *Task                // 任务队列
+	taskResultQueue chan *TaskResult          // 结果队列
+	wg              sync.WaitGroup            // 等待组
+	mu              sync.Mutex                // 读写锁
+	ctx             context.Context           // 上下文
+	cancel          context.CancelFunc        // 取消函数
+	runCtx          context.Context           // 所有任务的 ctx 从它派生，设置了运行时长限制时带截止时间
+	runCancel       context.CancelFunc        // 取消 runCtx
+	runTimeout      time.Duration             // 整个运行的时长限制
+	history         *RunHistory               // 历史耗时，用于 SLA 检查
+	workspace       *Workspace                // 本次运行的临时工作区和产物目录，为 nil 时不启用
+	runEnv          []string                  // 运行中导出给之后任务的环境变量，例如 GIT_COMMIT
+	secrets         *SecretStore              // 秘密存储，为 nil 时不注入也不遮盖
+	watch           *WatchOptions             // 监听模式的参数，为 nil 时不监听
+	watchWG         sync.WaitGroup            // 等待监听协程退出
+	roundDone       bool                      // 监听模式下本轮任务已经全部结束
+	isRunning       bool                      // 是否正在运行
+	resultsDone     chan struct{}             // 结果处理器退出后关闭
+	completedTasks  map[string]bool           // 已完成任务（包括已就绪的服务），依赖它们的任务可以执行
+	scheduled       map[string]bool           // 已放入队列的任务，避免重复调度
+	metrics         *Metrics                  // 运行指标
+	notifiers       []*notifierEntry          // 通知器
+	notifyStatePath string                    // 上一次运行结果的记录文件
+	notifyWG        sync.WaitGroup            // 等待进行中的通知发送完成
+	done            chan struct{}             // 所有任务结束后关闭
+	cache           *TaskCache                // 输入指纹缓存，为 nil 时不启用
+	executors       map[string]Executor       // 已注册的执行器
+	services        map[string]*serviceHandle // 已就绪、仍在运行的服务
+	serviceWG       sync.WaitGroup            // 等待服务的监控协程退出
+	finishOnce      sync.Once                 // 保证运行结束的收尾只执行一次
+	running         map[string]*runningTask   // 正在执行的任务的实时状态
+	cancelRequested map[string]bool           // 已在队列中、但被要求取消的任务
+	out             io.Writer                 // 任务结果的输出位置，默认标准输出
+	gates           map[string]*pendingGate   // 正在等待审批的节点
+	windowWaits     map[string]*windowWait    // 就绪但在等待时间窗口的任务
+	windowNext      time.Time                 // 下一次重新检查时间窗口的时间
+	windowStop      func() bool               // 取消下一次检查
+	freeze          FreezeState               // 全局冻结
+	startTime       time.Time                 // Start 的时间
+	progressEvery   time.Duration             // 输出进度的间隔，为 0 时不输出
+	windowWG        sync.WaitGroup            // 等待发送跳过结果的协程退出
+	gateWG          sync.WaitGroup            // 等待审批节点的协程退出
+	aborted         chan struct{}             // Abort 后关闭
+	abortOnce       sync.Once                 // 保证 aborted 只关闭一次
+	runID           string                    // 本次运行的 ID，出现在每一条日志中
+	name            string                    // 运行的名称，用于汇总、报告和通知
+	clock           Clock                     // 时钟，模拟模式下为虚拟时钟
+	inflight        atomic.Int64              // 已放入任务队列或结果队列、还没有处理完的数量，模拟器据此判断是否空闲
+	logger          *slog.Logger              // 结构化日志，已带上 run_id
+}
+
+// NewScheduler 创建调度器，默认并发数为 CPU 核数，其余配置见 Option
+func NewScheduler(opts ...Option) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	runID := newRunID()
+	s := &Scheduler{
+		maxWorkers:      runtime.NumCPU(),
+		tasks:           make(map[string]*Task),
+		taskResults:     make(map[string]*TaskResult),
+		taskQueue:       make(chan *Task, 100),
+		taskResultQueue: make(chan *TaskResult, 100),
+		ctx:             ctx,
+		cancel:          cancel,
+		runCtx:          ctx,
+		runCancel:       cancel,
+		completedTasks:  make(map[string]bool),
+		scheduled:       make(map[string]bool),
+		services:        make(map[string]*serviceHandle),
+		running:         make(map[string]*runningTask),
+		cancelRequested: make(map[string]bool),
+		gates:           make(map[string]*pendingGate),
+		windowWaits:     make(map[string]*windowWait),
+		out:             os.Stdout,
+		runID:           runID,
+		name:            "shell",
+		clock:           realClock{},
+		logger:          slog.Default().With(logKeyRunID, runID),
+		metrics:         NewMetrics(),
+		done:            make(chan struct{}),
+		aborted:         make(chan struct{}),
+		executors: map[string]Executor{
+			ExecutorShell: ShellExecutor{},
+			ExecutorHTTP:  HTTPExecutor{},
+			ExecutorFunc:  FuncExecutor{},
+		},
+	}
+	// 检出步骤需要把提交号导出给之后的任务
+	s.executors[ExecutorGit] = &GitExecutor{scheduler: s}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SetLogger 设置日志记录器，需要在 Start 之前调用
+func (s *Scheduler) SetLogger(logger *slog.Logger) {
+	s.logger = logger.With(logKeyRunID, s.runID)
+}
+
+// SetName 设置运行的名称，默认为 shell，通常为流水线的名称；需要在 Start 之前调用
+func (s *Scheduler) SetName(name string) {
+	if name != "" {
+		s.name = name
+	}
+}
+
+// Name 返回运行的名称
+func (s *Scheduler) Name() string {
+	return s.name
+}
+
+// RunID 返回本次运行的 ID
+func (s *Scheduler) RunID() string {
+	return s.runID
+}
+
+// AddTask 添加任务
+// 子流水线节点（Kind 为 pipeline）会展开为带命名空间的子任务，见 Pipeline
+func (s *Scheduler) AddTask(task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addNode(task, nil)
+}
+
+// addTask 填充默认值、检查并登记任务，调用方需要持有 s.mu
+func (s *Scheduler) addTask(task *Task) error {
+	if task.ID == "" {
+		task.ID = fmt.Sprintf("task-%d", len(s.tasks)+1)
+	}
+	if task.Name == "" {
+		task.Name = task.ID
+	}
+	if task.Timeout == 0 {
+		task.Timeout = 5 * time.Minute
+	}
+	if task.MaxOutput == 0 {
+		task.MaxOutput = 5000
+	}
+	if err := validateScript(task); err != nil {
+		return fmt.Errorf("任务 %s: %w", task.ID, err)
+	}
+	criteria, err := compileCriteria(task)
+	if err != nil {
+		return fmt.Errorf("任务 %s: %w", task.ID, err)
+	}
+	task.criteria = criteria
+	if err := task.Window.validate(); err != nil {
+		return fmt.Errorf("任务 %s: %w", task.ID, err)
+	}
+	if _, exists := s.tasks[task.ID]; exists {
+		return fmt.Errorf("任务 ID %s 重复", task.ID)
+	}
+	s.tasks[task.ID] = task
+	return nil
+}
+
+// checkDependencies 检查任务依赖
+func (s *Scheduler) checkDependencies() error {
+	// 根据 Dependencies 去检查需要的任务是否存在在队列中
+	for _, task := range s.tasks {
+		for _, depID := range task.Dependencies {
+			if _, exists := s.tasks[depID]; !exists {
+				return fmt.Errorf("任务 %s 依赖的任务 %s 不存在", task.ID, depID)
+			}
+		}
+	}
+	return nil
+}
+
+// taskDispatcher 任务分发器
+func (s *Scheduler) taskDispatcher() {
+	// 检查依赖
+	if err := s.checkDependencies(); err != nil {
+		s.logger.Error("检查依赖失败", "error", err)
+		return
+	}
+	// 没有依赖的任务按 ID 顺序加入队列，worker 不够时先后顺序是确定的
+	// 有依赖的任务会在没有依赖的任务完成后执行
+	s.mu.Lock()
+	for _, id := range slices.Sorted(maps.Keys(s.tasks)) {
+		if task := s.tasks[id]; len(task.Dependencies) == 0 {
+			s.scheduled[task.ID] = true
+			if s.holdForWindow(task) {
+				continue
+			}
+			s.inflight.Add(1)
+			s.taskQueue <- task
+		}
+	}
+	s.mu.Unlock()
+}
+
+// trimOutput 限制输出大小
+func (s *Scheduler) trimOutput(output string, maxLines int) string {
+	lines := bytes.Split([]byte(output), []byte("\n"))
+	if len(lines) <= maxLines {
+		return output
+	}
+
+	// 保留开头和结尾
+	keep := maxLines / 2
+	firstPart := lines[:keep]
+	lastPart := lines[len(lines)-keep:]
+
+	var result []byte
+	result = append(result, bytes.Join(firstPart, []byte("\n"))...)
+	result = append(result, []byte("\n... (忽略中间内容) ...\n")...)
+	result = append(result, bytes.Join(lastPart, []byte("\n"))...)
+
+	return string(result)
+}
+
+// executeTask 执行单个任务
+func (s *Scheduler) executeTask(workerID int, task *Task) *TaskResult {
+	result := &TaskResult{
+		TaskID:     task.ID,
+		TaskName:   task.Name,
+		Status:     StatusRunning,
+		StartTime:  s.clock.Now(),
+		RetryCount: 0,
+	}
+
+	logger := s.logger.With(logKeyTaskID, task.ID, logKeyWorker, workerID)
+
+	// 输入没有变化时直接使用缓存
+	fingerprint, cached := s.lookupCache(task, logger)
+	if cached {
+		logger.Info("输入未变化，使用缓存结果", "fingerprint", fingerprint[:12])
+		result.Status = StatusCached
+		result.EndTime = s.clock.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		s.collectArtifacts(task, result, logger)
+		return result
+	}
+
+	logger.Info("开始执行任务", "name", task.Name, "cmd", task.Cmd)
+
+	// 所有重试共用的 ctx：带上任务的总时长限制，并受整个运行的截止时间约束
+	taskCtx, cancelTask := context.WithCancel(s.runCtx)
+	if task.TotalTimeout > 0 {
+		taskCtx, cancelTask = s.clock.WithTimeout(s.runCtx, task.TotalTimeout)
+	}
+	defer cancelTask()
+
+	// 执行命令
+	output := newTaskOutput(logger, s.secrets)
+	matcher := newOutputMatcher(task.criteria)
+	output.OnLine(matcher.match)
+	rt := s.trackRunning(task, output)
+	var err error
+	var exitCode int
+
+	for attempt := 0; attempt <= task.RetryCount; attempt++ {
+		if attempt > 0 {
+			logger.Warn("任务重试", logKeyAttempt, attempt, "error", err)
+			s.metrics.taskRetried(task.ID)
+			s.clock.Sleep(taskCtx, task.RetryDelay)
+		}
+		// 调度器在退避期间停止，不再开始新的尝试
+		if s.stopping() {
+			result.Status = StatusCancelled
+			err = errRunStopped
+			break
+		}
+		if !s.setAttempt(rt, attempt) {
+			result.Status = StatusCancelled
+			err = errTaskCancelled
+			break
+		}
+		if reason, deadlineErr := s.deadlineError(taskCtx, task); deadlineErr != nil {
+			result.Status = StatusTimeout
+			result.FailureReason = reason
+			err = deadlineErr
+			break
+		}
+
+		result.RetryCount = attempt
+		output.Reset()
+		exitCode, err = s.runAttempt(taskCtx, task, output, matcher, rt)
+
+		// 手动取消的任务不再判定、也不再重试
+		if s.isCancelled(rt) {
+			result.Status = StatusCancelled
+			err = errTaskCancelled
+			break
+		}
+		// 调度器停止（Stop 或 Start 的 ctx 被取消）导致的中断不算失败，也不再重试
+		if s.stopping() {
+			result.Status = StatusCancelled
+			err = errRunStopped
+			break
+		}
+
+		// 按退出码和输出规则判定本次尝试
+		var warnings []string
+		warnings, err = matcher.evaluate(exitCode, err)
+		if err == nil {
+			result.Status = StatusSuccess
+			if len(warnings) > 0 {
+				result.Status = StatusWarning
+				result.Warnings = warnings
+			}
+			break
+		}
+
+		timedOut := errors.Is(err, errTaskTimeout)
+		if timedOut {
+			s.metrics.taskTimedOut(task.ID)
+		}
+
+		// 总时长或整个运行的截止时间已到，不再重试
+		if reason, deadlineErr := s.deadlineError(taskCtx, task); deadlineErr != nil {
+			result.Status = StatusTimeout
+			result.FailureReason = reason
+			err = deadlineErr
+			break
+		}
+
+		if attempt == task.RetryCount {
+			result.Status = StatusFailed
+			if timedOut {
+				result.Status = StatusTimeout
+			}
+		}
+	}
+
+	result.EndTime = s.clock.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.ExitCode = exitCode
+	result.Output = s.trimOutput(output.String(), task.MaxOutput)
+	result.Error = err
+
+	var limitErr *LimitExceededError
+	if errors.As(err, &limitErr) {
+		result.FailureReason = limitErr.Reason
+	}
+
+	if result.Status.Succeeded() && fingerprint != "" {
+		if err := s.cache.Save(s.withWorkspacePaths(task), s.workspaceDir(), fingerprint); err != nil {
+			logger.Warn("写入缓存失败", "error", err)
+		}
+	}
+	s.collectArtifacts(task, result, logger)
+
+	return result
+}
+
+// stopping 调度器是否正在停止：Stop 已经调用，或者 Start 的 ctx 被取消
+// 整个运行超过截止时间时 runCtx 的错误是 DeadlineExceeded，由 deadlineError 处理
+func (s *Scheduler) stopping() bool {
+	return s.ctx.Err() != nil || errors.Is(s.runCtx.Err(), context.Canceled)
+}
+
+// lookupCache 计算任务的输入指纹并尝试从缓存恢复
+// 返回的指纹为空表示该任务不参与缓存
+func (s *Scheduler) lookupCache(task *Task, logger *slog.Logger) (string, bool) {
+	if s.cache == nil || len(task.Inputs) == 0 || !cacheable(task) {
+		return "", false
+	}
+	task = s.withWorkspacePaths(task)
+	fingerprint, err := s.cache.Fingerprint(task, s.workspaceDir(), s.exportedEnv())
+	if err != nil {
+		logger.Warn("计算输入指纹失败，跳过缓存", "error", err)
+		return "", false
+	}
+	restored, err := s.cache.Restore(task, s.workspaceDir(), fingerprint)
+	if err != nil {
+		logger.Warn("恢复缓存失败，重新执行", "error", err)
+		return fingerprint, false
+	}
+	return fingerprint, restored
+}
+
+// worker 工作协程
+func (s *Scheduler) worker(id int) {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case task := <-s.taskQueue:
+			s.runTask(id, task)
+			s.inflight.Add(-1)
+		}
+	}
+}
+
+// runTask 执行从队列取出的一个任务，并把结果交给结果队列
+func (s *Scheduler) runTask(workerID int, task *Task) {
+	if result := s.takeCancelRequest(task); result != nil {
+		s.sendResult(result)
+		return
+	}
+	if result := s.takeDeadlineResult(task); result != nil {
+		s.metrics.taskFinished(result)
+		s.sendResult(result)
+		return
+	}
+	s.metrics.workerBusy(true)
+	var result *TaskResult
+	switch task.Kind {
+	case KindService:
+		result = s.startService(workerID, task)
+	case KindGate:
+		s.startGate(workerID, task)
+	case KindPipeline:
+		result = s.pipelineResult(task)
+	default:
+		result = s.executeTask(workerID, task)
+	}
+	s.metrics.workerBusy(false)
+	// 服务已就绪并转入后台运行、审批节点在后台等待，结果要等它们结束时才有
+	if result == nil {
+		return
+	}
+	s.metrics.taskFinished(result)
+	s.sendResult(result)
+}
+
+// trySchedule 把任务放入队列，队列已满时返回 false，调用方需要持有 s.mu
+func (s *Scheduler) trySchedule(task *Task) bool {
+	if s.holdForWindow(task) {
+		s.scheduled[task.ID] = true
+		return true
+	}
+	s.inflight.Add(1)
+	select {
+	case s.taskQueue <- task:
+		s.scheduled[task.ID] = true
+		return true
+	default:
+		s.inflight.Add(-1)
+		s.logger.Warn("任务队列已满，等待调度", logKeyTaskID, task.ID)
+		return false
+	}
+}
+
+// sendResult 把结果交给结果处理器
+func (s *Scheduler) sendResult(result *TaskResult) {
+	s.inflight.Add(1)
+	s.taskResultQueue <- result
+}
+
+// Color 任务状态在终端中显示的颜色
+func (s TaskStatus) Color() *color.Color {
+	switch s {
+	case StatusSuccess, StatusCached:
+		return color.New(color.FgGreen, color.Bold)
+	case StatusWarning:
+		return color.New(color.FgYellow, color.Bold)
+	case StatusFailed, StatusTimeout:
+		return color.New(color.FgRed, color.Bold)
+	case StatusCancelled, StatusSkipped:
+		return color.New(color.FgYellow, color.Bold)
+	case StatusRunning:
+		return color.New(color.FgCyan)
+	default:
+		return color.New(color.FgWhite)
+	}
+}
+
+// printResult 打印任务结果
+func (s *Scheduler) printResult(result *TaskResult) {
+	statusColor := result.Status.Color()
+	w := s.out
+	statusColor.Fprintf(w, "\n任务完成: %s (%s)\n", result.TaskName, result.TaskID)
+	fmt.Fprintf(w, "  状态: %s", result.Status)
+	fmt.Fprintf(w, "  耗时: %v", result.Duration)
+	fmt.Fprintf(w, "  开始: %s", result.StartTime.Format(time.DateTime))
+	fmt.Fprintf(w, "  结束: %s", result.EndTime.Format(time.DateTime))
+	fmt.Fprintf(w, "  退出码: %d", result.ExitCode)
+	fmt.Fprintf(w, "  重试次数: %d", result.RetryCount)
+
+	if result.Error != nil {
+		fmt.Fprintf(w, "  错误: %v\n", result.Error)
+	}
+	if result.FailureReason != "" {
+		fmt.Fprintf(w, "  失败原因: %s\n", result.FailureReason)
+	}
+	if result.SLAExceeded {
+		fmt.Fprintf(w, "  SLA: 耗时超过历史 p95 (%v)\n", result.P95)
+	}
+	if a := result.Approval; a != nil {
+		decision := "拒绝"
+		if a.Approved {
+			decision = "通过"
+		}
+		fmt.Fprintf(w, "  审批: %s %s %s\n", a.Approver, decision, a.Comment)
+	}
+	if len(result.Warnings) > 0 {
+		fmt.Fprintln(w, "  警告:")
+		for _, line := range result.Warnings {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+	if len(result.Artifacts) > 0 {
+		fmt.Fprintln(w, "  产物:")
+		for _, a := range result.Artifacts {
+			fmt.Fprintf(w, "    %s (%d 字节, sha256 %s)\n", a.Path, a.Size, a.SHA256[:12])
+		}
+	}
+
+	if result.Output != "" {
+		fmt.Fprintln(w, "  输出预览:")
+		lines := bytes.SplitN([]byte(result.Output), []byte("\n"), 6)
+		for i, line := range lines {
+			if i >= 5 {
+				fmt.Fprintln(w, "    ...(更多输出请查看完整日志)...")
+			}
+			if len(line) > 0 {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// SetOutput 设置任务结果的输出位置，需要在 Start 之前调用
+// 例如终端界面模式下结果直接显示在界面上，不需要逐条打印
+func (s *Scheduler) SetOutput(w io.Writer) {
+	s.out = w
+}
+
+// checkDependentTasks 检查依赖任务
+func (s *Scheduler) checkDependentTasks() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range slices.Sorted(maps.Keys(s.tasks)) {
+		task := s.tasks[id]
+		// 如果任务已经在队列或已完成则跳过
+		if s.scheduled[task.ID] {
+			continue
+		}
+		// 未进行任务依赖项是否全部满足
+		allDepsCompleted := true
+		for _, depID := range task.Dependencies {
+			if !s.completedTasks[depID] {
+				allDepsCompleted = false
+				break
+			}
+		}
+		// 如果依赖项项目全部满足，加入队列
+		if allDepsCompleted && len(task.Dependencies) > 0 {
+			s.trySchedule(task)
+		}
+	}
+}
+
+// resultProcessor 处理任务结果
+func (s *Scheduler) resultProcessor() {
+	for result := range s.taskResultQueue {
+		s.maskResult(result)
+		s.mu.Lock()
+		s.taskResults[result.TaskID] = result
+		delete(s.running, result.TaskID)
+		s.checkSLA(result)
+		// 没有通过的审批节点不放行依赖它的任务，而是把它们全部取消
+		var cancelled []*TaskResult
+		if task := s.tasks[result.TaskID]; task != nil && blocksDependents(task, result) {
+			cancelled = s.cancelDependents(result.TaskID)
+		} else {
+			s.completedTasks[result.TaskID] = true
+		}
+		s.mu.Unlock()
+		s.logResult(result)
+		// 打印结果
+		s.printResult(result)
+		s.notifyTaskFinished(result)
+		for _, r := range cancelled {
+			s.logResult(r)
+			s.printResult(r)
+		}
+		// 检查是否有依赖此任务的任务可以执行
+		s.checkDependentTasks()
+		s.maybeFinishRun()
+		s.inflight.Add(-1)
+	}
+}
+
+// logResult 记录任务结束，失败的任务记为 ERROR 级别
+func (s *Scheduler) logResult(result *TaskResult) {
+	level := slog.LevelInfo
+	switch {
+	case result.Status == StatusWarning, result.Status == StatusSkipped:
+		level = slog.LevelWarn
+	case !result.Status.Succeeded():
+		level = slog.LevelError
+	}
+	attrs := []any{
+		logKeyTaskID, result.TaskID,
+		logKeyStatus, result.Status.Code(),
+		logKeyAttempt, result.RetryCount,
+		logKeyExitCode, result.ExitCode,
+		logKeyDuration, result.Duration,
+	}
+	if result.Error != nil {
+		attrs = append(attrs, "error", result.Error)
+	}
+	if result.FailureReason != "" {
+		attrs = append(attrs, "failure_reason", result.FailureReason)
+	}
+	s.logger.Log(context.Background(), level, "任务结束", attrs...)
+}
+
+// maybeFinishRun 所有普通任务都有了结果、服务都已就绪时，运行结束：
+// 关闭服务，发送运行级通知，等通知发完再宣告结束；监听模式下不结束
+func (s *Scheduler) maybeFinishRun() {
+	s.mu.Lock()
+	for id := range s.tasks {
+		if _, ok := s.taskResults[id]; ok {
+			continue
+		}
+		if _, running := s.services[id]; running {
+			continue
+		}
+		s.mu.Unlock()
+		return
+	}
+	if s.watch != nil {
+		// 监听模式下运行不会结束，只提示本轮已经完成
+		first := !s.roundDone
+		s.roundDone = true
+		s.mu.Unlock()
+		if first {
+			s.logger.Info("本轮任务全部结束，等待输入变化")
+		}
+		return
+	}
+	s.mu.Unlock()
+
+	s.finishOnce.Do(func() {
+		s.stopServices()
+		s.notifyRunFinished()
+		s.notifyWG.Wait()
+		close(s.done)
+	})
+}
+
+// Done 返回一个在所有任务结束（且通知发送完毕）后关闭的 channel
+func (s *Scheduler) Done() <-chan struct{} {
+	return s.done
+}
+
+// AddTasks 批量添加任务
+func (s *Scheduler) AddTasks(tasks ...*Task) {
+	for _, task := range tasks {
+		if err := s.AddTask(task); err != nil {
+			s.logger.Error("添加任务失败", logKeyTaskID, task.ID, "error", err)
+		}
+	}
+}
+
+// Start 启动调度器，立即返回，用 Done 等待所有任务结束
+// ctx 被取消时正在执行的任务都会被终止、不再调度新的任务，调用方仍需调用 Stop 收尾
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.isRunning {
+		s.mu.Unlock()
+		return fmt.Errorf("程序已经在运行")
+	}
+	s.isRunning = true
+	s.resultsDone = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.runCtx, s.runCancel = s.ctx, s.cancel
+	s.startTime = s.clock.Now()
+	s.mu.Unlock()
+
+	s.startRunDeadline()
+
+	// 启动work
+	for i := 0; i < s.maxWorkers; i++ {
+		s.wg.Add(1)
+		go s.worker(i)
+	}
+
+	// 启动结果处理器
+	go func() {
+		defer close(s.resultsDone)
+		s.resultProcessor()
+	}()
+
+	// 启动任务调器，分发完成之前调度器不算空闲
+	s.inflight.Add(1)
+	go func() {
+		defer s.inflight.Add(-1)
+		s.taskDispatcher()
+	}()
+
+	// 监听输入变化
+	if s.watch != nil {
+		s.watchWG.Add(1)
+		go s.watchLoop()
+	}
+
+	// 定期输出进度
+	if s.progressEvery > 0 {
+		s.wg.Add(1)
+		go s.progressLoop()
+	}
+
+	s.logger.Info("调度器启动", "max_workers", s.maxWorkers)
+	return nil
+}
+
+// Stop 停止调度器
+func (s *Scheduler) Stop() {
+	s.logger.Info("停止调度器")
+	s.mu.Lock()
+	s.isRunning = false
+	s.mu.Unlock()
+	s.stopServices()
+	s.runCancel()
+	s.cancel()
+	s.wg.Wait()
+	s.serviceWG.Wait()
+	s.gateWG.Wait()
+	s.watchWG.Wait()
+	s.mu.Lock()
+	if s.windowStop != nil {
+		s.windowStop()
+	}
+	s.mu.Unlock()
+	s.windowWG.Wait()
+	// 结果处理器可能还在把依赖任务放入队列，等它退出之后才能关闭任务队列
+	close(s.taskResultQueue)
+	<-s.resultsDone
+	close(s.taskQueue)
+	s.logger.Info("调度器已停止")
+}
+
+// GetResults 获取所有任务结果
+func (s *Scheduler) GetResults() map[string]*TaskResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make(map[string]*TaskResult)
+	for k, v := range s.taskResults {
+		results[k] = v
+	}
+	return results
+}
+
+// PrintSummary 把汇总报告打印到 w
+func (s *Scheduler) PrintSummary(w io.Writer) {
+	report := s.BuildReport(s.name)
+
+	fmt.Fprintln(w, "\n"+strings.Repeat("-", 60))
+	fmt.Fprintln(w, "任务执行汇总报告")
+	fmt.Fprintln(w, strings.Repeat("-", 60))
+
+	var totalTime time.Duration
+	executed := 0
+	for _, t := range report.Tasks {
+		if t.status != StatusPending {
+			totalTime += t.duration
+			executed++
+		}
+	}
+
+	fmt.Fprintf(w, "任务总数: %d\n", report.Total)
+	fmt.Fprintf(w, "成功: %d\n", report.Success)
+	if report.Warnings > 0 {
+		fmt.Fprintf(w, "  其中带警告: %d\n", report.Warnings)
+	}
+	fmt.Fprintf(w, "失败: %d\n", report.Failed)
+	if report.WaitingApproval > 0 {
+		fmt.Fprintf(w, "等待审批: %d\n", report.WaitingApproval)
+	}
+	if report.Skipped > 0 {
+		fmt.Fprintf(w, "未执行: %d\n", report.Skipped)
+	}
+	if report.OutsideWindow > 0 {
+		fmt.Fprintf(w, "不在时间窗口内跳过: %d\n", report.OutsideWindow)
+	}
+	fmt.Fprintf(w, "总耗时: %v\n", totalTime)
+	if executed > 0 {
+		fmt.Fprintf(w, "平均耗时: %v\n", totalTime/time.Duration(executed))
+	}
+	if report.RunDir != "" {
+		fmt.Fprintf(w, "运行目录: %s\n", report.RunDir)
+	}
+
+	// 打印详细结果表格
+	// 中文等宽字符在终端中占两列，这里按显示宽度而不是字节数补齐
+	fmt.Fprintln(w, "\n详细结果:")
+	fmt.Fprintln(w, strings.Repeat("-", 100))
+	fmt.Fprintln(w, PadRight("任务名称", 20)+" "+PadRight("状态", 15)+" "+PadRight("耗时", 12)+" "+PadRight("退出码", 10)+" "+"开始时间")
+	fmt.Fprintln(w, strings.Repeat("-", 100))
+	for _, t := range report.Tasks {
+		statusStr := PadRight(t.status.String(), 15)
+		switch {
+		case t.status == StatusWarning:
+			statusStr = color.YellowString(statusStr)
+		case t.status.Succeeded():
+			statusStr = color.GreenString(statusStr)
+		case t.status == StatusPending, t.status == StatusWaitingApproval, t.status == StatusWaitingWindow, t.status == StatusSkipped:
+			statusStr = color.YellowString(statusStr)
+		default:
+			statusStr = color.RedString(statusStr)
+		}
+
+		startTime := "-"
+		if !t.StartTime.IsZero() {
+			startTime = t.StartTime.Format(time.DateTime)
+		}
+		fmt.Fprintln(w, PadRight(t.Name, 20)+" "+statusStr+" "+PadRight(t.duration.Round(time.Millisecond).String(), 12)+" "+PadRight(fmt.Sprint(t.ExitCode), 10)+" "+startTime)
+		if t.SLAExceeded {
+			fmt.Fprintf(w, "  SLA: 耗时超过历史 p95 (%v)\n", time.Duration(t.P95Ms)*time.Millisecond)
+		}
+		if a := t.Approval; a != nil {
+			decision := "拒绝"
+			if a.Approved {
+				decision = "通过"
+			}
+			fmt.Fprintf(w, "  审批: %s %s %s (%s)\n", a.Approver, decision, a.Comment, a.Time.Format(time.DateTime))
+		}
+		fmt.Fprintln(w, strings.Repeat("-", 100))
+	}
+}
+
+// DisplayWidth 计算字符串在终端中的显示宽度
+// 东亚宽字符（中日韩文字、全角符号等）占两列，其余字符占一列
+func DisplayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		switch {
+		case r >= 0x1100 && r <= 0x115F, // 韩文字母
+			r >= 0x2E80 && r <= 0xA4CF && r != 0x303F, // CJK 部首、假名、CJK 统一汉字等
+			r >= 0xAC00 && r <= 0xD7A3,                // 韩文音节
+			r >= 0xF900 && r <= 0xFAFF,                // CJK 兼容汉字
+			r >= 0xFE30 && r <= 0xFE4F,                // CJK 兼容标点
+			r >= 0xFF00 && r <= 0xFF60,                // 全角字符
+			r >= 0xFFE0 && r <= 0xFFE6,
+			r >= 0x1F300 && r <= 0x1F64F, // emoji
+			r >= 0x1F900 && r <= 0x1F9FF,
+			r >= 0x20000 && r <= 0x3FFFD: // CJK 扩展区
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+// PadRight 按显示宽度在右侧补空格
+func PadRight(s string, width int) string {
+	if w := DisplayWidth(s); w < width {
+		return s + strings.Repeat(" ", width-w)
+	}
+	return s
+}
@@ -0,0 +1,285 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// simStart 模拟开始的虚拟时间
+var simStart = time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+
+// newTestScheduler 不输出日志和结果的调度器
+func newTestScheduler(opts ...Option) *Scheduler {
+	opts = append([]Option{
+		WithOutput(io.Discard),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}, opts...)
+	return NewScheduler(opts...)
+}
+
+// runSimulation 在虚拟时间中运行任务，返回调度器和模拟器，调度器已经停止
+func runSimulation(t *testing.T, workers int, tasks []*Task, scripts map[string][]SimStep) (*Scheduler, *Simulation) {
+	t.Helper()
+	s := newTestScheduler(WithMaxWorkers(workers))
+	for _, task := range tasks {
+		if err := s.AddTask(task); err != nil {
+			t.Fatalf("添加任务 %s 失败: %v", task.ID, err)
+		}
+	}
+	sim := NewSimulation(s, simStart)
+	for id, steps := range scripts {
+		sim.Script(id, steps...)
+	}
+	if err := sim.Run(context.Background()); err != nil {
+		s.Stop()
+		t.Fatalf("模拟失败: %v", err)
+	}
+	s.Stop()
+	return s, sim
+}
+
+// firstStarts 每个任务第一次开始执行的虚拟时间
+func firstStarts(trace []SimEvent) map[string]time.Duration {
+	starts := make(map[string]time.Duration)
+	for _, e := range trace {
+		if _, ok := starts[e.TaskID]; !ok && e.Event == "start" {
+			starts[e.TaskID] = e.At
+		}
+	}
+	return starts
+}
+
+func TestSchedulerSimulation(t *testing.T) {
+	fail := SimStep{Duration: time.Minute, ExitCode: 1}
+	ok := SimStep{Duration: time.Minute}
+	tests := []struct {
+		name        string
+		workers     int
+		tasks       []*Task
+		scripts     map[string][]SimStep
+		want        map[string]TaskStatus
+		wantRetries map[string]int
+		wantReason  map[string]string
+		wantStarts  map[string]time.Duration
+	}{
+		{
+			name:    "依赖按顺序执行",
+			workers: 2,
+			tasks: []*Task{
+				{ID: "a", Cmd: "true"},
+				{ID: "b", Cmd: "true", Dependencies: []string{"a"}},
+				{ID: "c", Cmd: "true", Dependencies: []string{"a"}},
+				{ID: "d", Cmd: "true", Dependencies: []string{"b", "c"}},
+			},
+			scripts: map[string][]SimStep{
+				"a": {{Duration: time.Minute}},
+				"b": {{Duration: 2 * time.Minute}},
+				"c": {{Duration: 30 * time.Second}},
+				"d": {{Duration: time.Minute}},
+			},
+			want:       map[string]TaskStatus{"a": StatusSuccess, "b": StatusSuccess, "c": StatusSuccess, "d": StatusSuccess},
+			wantStarts: map[string]time.Duration{"a": 0, "b": time.Minute, "c": time.Minute, "d": 3 * time.Minute},
+		},
+		{
+			name:    "并发数不够时排队",
+			workers: 1,
+			tasks: []*Task{
+				{ID: "a", Cmd: "true"},
+				{ID: "b", Cmd: "true"},
+			},
+			scripts:    map[string][]SimStep{"a": {ok}, "b": {ok}},
+			want:       map[string]TaskStatus{"a": StatusSuccess, "b": StatusSuccess},
+			wantStarts: map[string]time.Duration{"a": 0, "b": time.Minute},
+		},
+		{
+			name:        "重试后成功",
+			workers:     1,
+			tasks:       []*Task{{ID: "a", Cmd: "true", RetryCount: 2, RetryDelay: 10 * time.Second}},
+			scripts:     map[string][]SimStep{"a": {fail, fail, ok}},
+			want:        map[string]TaskStatus{"a": StatusSuccess},
+			wantRetries: map[string]int{"a": 2},
+		},
+		{
+			name:    "重试用尽后失败，依赖它的任务仍然执行",
+			workers: 1,
+			tasks: []*Task{
+				{ID: "a", Cmd: "true", RetryCount: 1},
+				{ID: "b", Cmd: "true", Dependencies: []string{"a"}},
+			},
+			scripts:     map[string][]SimStep{"a": {fail}},
+			want:        map[string]TaskStatus{"a": StatusFailed, "b": StatusSuccess},
+			wantRetries: map[string]int{"a": 1},
+			wantStarts:  map[string]time.Duration{"a": 0, "b": 2 * time.Minute},
+		},
+		{
+			name:        "单次执行超时",
+			workers:     1,
+			tasks:       []*Task{{ID: "a", Cmd: "true", Timeout: time.Minute, RetryCount: 1}},
+			scripts:     map[string][]SimStep{"a": {{Duration: 5 * time.Minute}}},
+			want:        map[string]TaskStatus{"a": StatusTimeout},
+			wantRetries: map[string]int{"a": 1},
+		},
+		{
+			name:        "总时长用完后不再重试",
+			workers:     1,
+			tasks:       []*Task{{ID: "a", Cmd: "true", Timeout: time.Minute, TotalTimeout: 90 * time.Second, RetryCount: 5}},
+			scripts:     map[string][]SimStep{"a": {{Duration: 5 * time.Minute}}},
+			want:        map[string]TaskStatus{"a": StatusTimeout},
+			wantRetries: map[string]int{"a": 1},
+			wantReason:  map[string]string{"a": FailureTotalTimeout},
+		},
+		{
+			name:    "自定义成功退出码",
+			workers: 1,
+			tasks:   []*Task{{ID: "a", Cmd: "true", SuccessExitCodes: []int{0, 3}}},
+			scripts: map[string][]SimStep{"a": {{ExitCode: 3}}},
+			want:    map[string]TaskStatus{"a": StatusSuccess},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, sim := runSimulation(t, tt.workers, tt.tasks, tt.scripts)
+			results := s.GetResults()
+			for id, want := range tt.want {
+				r := results[id]
+				if r == nil {
+					t.Fatalf("任务 %s 没有结果", id)
+				}
+				if r.Status != want {
+					t.Errorf("任务 %s 状态 = %s，期望 %s（错误: %v）", id, r.Status, want, r.Error)
+				}
+				if r.RetryCount != tt.wantRetries[id] {
+					t.Errorf("任务 %s 重试次数 = %d，期望 %d", id, r.RetryCount, tt.wantRetries[id])
+				}
+				if r.FailureReason != tt.wantReason[id] {
+					t.Errorf("任务 %s 失败原因 = %q，期望 %q", id, r.FailureReason, tt.wantReason[id])
+				}
+			}
+			starts := firstStarts(sim.Executor.Trace())
+			for id, want := range tt.wantStarts {
+				if got, ok := starts[id]; !ok || got != want {
+					t.Errorf("任务 %s 开始于 +%v，期望 +%v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTrimOutput(t *testing.T) {
+	lines := func(n int) string {
+		var b strings.Builder
+		for i := 1; i <= n; i++ {
+			if i > 1 {
+				b.WriteByte('\n')
+			}
+			b.WriteString("line")
+			b.WriteByte(byte('0' + i%10))
+		}
+		return b.String()
+	}
+	tests := []struct {
+		name     string
+		output   string
+		maxLines int
+		want     string
+	}{
+		{"没有超过限制", lines(3), 4, lines(3)},
+		{"恰好等于限制", lines(4), 4, lines(4)},
+		{"保留开头和结尾", lines(10), 4, "line1\nline2\n... (忽略中间内容) ...\nline9\nline0"},
+		{"空输出", "", 4, ""},
+	}
+	s := newTestScheduler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.trimOutput(tt.output, tt.maxLines); got != tt.want {
+				t.Errorf("trimOutput() = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxOutputTrimsResult(t *testing.T) {
+	var out strings.Builder
+	for i := range 10 {
+		out.WriteString("line")
+		out.WriteByte(byte('0' + i))
+		out.WriteByte('\n')
+	}
+	s, _ := runSimulation(t, 1,
+		[]*Task{{ID: "a", Cmd: "true", MaxOutput: 4}},
+		map[string][]SimStep{"a": {{Output: out.String()}}})
+	got := s.GetResults()["a"].Output
+	if !strings.Contains(got, "... (忽略中间内容) ...") {
+		t.Fatalf("输出没有被截断: %q", got)
+	}
+	if strings.Contains(got, "line5") || !strings.Contains(got, "line0") || !strings.Contains(got, "line9") {
+		t.Errorf("截断后应保留开头和结尾: %q", got)
+	}
+}
+
+func TestShutdownDuringRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(s *Scheduler, cancel context.CancelFunc)
+	}{
+		{"调用 Stop", func(s *Scheduler, cancel context.CancelFunc) { s.Stop() }},
+		{"取消 Start 的 ctx", func(s *Scheduler, cancel context.CancelFunc) { cancel(); s.Stop() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			started := make(chan struct{}, 1)
+			s := newTestScheduler(WithMaxWorkers(1))
+			err := s.AddTask(&Task{
+				ID:         "a",
+				Executor:   ExecutorFunc,
+				RetryCount: 3,
+				RetryDelay: time.Hour,
+				Func: func(ctx context.Context, w io.Writer) error {
+					// 停止后不应再次执行，即使执行了也不能阻塞在这里
+					if calls.Add(1) == 1 {
+						started <- struct{}{}
+					}
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := s.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			<-started
+			tt.stop(s, cancel)
+
+			r := s.GetResults()["a"]
+			if r == nil {
+				t.Fatal("任务没有结果")
+			}
+			if r.Status != StatusCancelled {
+				t.Errorf("状态 = %s，期望 %s", r.Status, StatusCancelled)
+			}
+			if !errors.Is(r.Error, errRunStopped) {
+				t.Errorf("错误 = %v，期望 %v", r.Error, errRunStopped)
+			}
+			if n := calls.Load(); n != 1 || r.RetryCount != 0 {
+				t.Errorf("执行了 %d 次（重试 %d 次），停止后不应再重试", n, r.RetryCount)
+			}
+			s.metrics.mu.Lock()
+			retries := s.metrics.retries["a"]
+			s.metrics.mu.Unlock()
+			if retries != 0 {
+				t.Errorf("重试指标 = %d，期望 0", retries)
+			}
+		})
+	}
+}
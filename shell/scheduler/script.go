@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 内置的脚本解释器，Task.Shell 为空时使用 bash
+const (
+	ShellBash   = "bash"
+	ShellSh     = "sh"
+	ShellPython = "python"
+	ShellNode   = "node"
+)
+
+// shellInterpreters 内置解释器对应的命令行和脚本文件扩展名
+// bash 默认开启 -euo pipefail：任何一步失败、使用未定义变量、管道中间失败都会让脚本立即退出
+var shellInterpreters = map[string]struct {
+	args []string
+	ext  string
+}{
+	ShellBash:   {[]string{"bash", "-euo", "pipefail"}, ".sh"},
+	ShellSh:     {[]string{"sh", "-eu"}, ".sh"},
+	ShellPython: {[]string{"python3", "-u"}, ".py"},
+	ShellNode:   {[]string{"node"}, ".js"},
+}
+
+// interpreterFor 返回执行脚本的命令行（不含脚本路径）和脚本文件的扩展名
+// Interpreter 优先于 Shell，便于使用内置列表以外的解释器
+func interpreterFor(task *Task) ([]string, string, error) {
+	if len(task.Interpreter) > 0 {
+		return task.Interpreter, "", nil
+	}
+	name := task.Shell
+	if name == "" {
+		name = ShellBash
+	}
+	interp, ok := shellInterpreters[name]
+	if !ok {
+		return nil, "", fmt.Errorf("未知的 Shell %q，可选 bash、sh、python、node，或使用 Interpreter 自定义", name)
+	}
+	return interp.args, interp.ext, nil
+}
+
+// validateScript 检查脚本相关的字段，在 AddTask 时调用
+func validateScript(task *Task) error {
+	if task.Script == "" {
+		if task.Shell != "" || len(task.Interpreter) > 0 {
+			return fmt.Errorf("设置了 Shell 或 Interpreter 但没有 Script")
+		}
+		return nil
+	}
+	if task.Cmd != "" || len(task.Args) > 0 {
+		return fmt.Errorf("Cmd/Args 和 Script 只能设置一个")
+	}
+	_, _, err := interpreterFor(task)
+	return err
+}
+
+// scriptCommand 把脚本写入工作目录下的临时文件，返回执行它的命令
+// 调用方在命令结束后需要调用 cleanup 删除临时文件
+func scriptCommand(task *Task) (name string, args []string, cleanup func(), err error) {
+	interp, ext, err := interpreterFor(task)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	// 放在工作目录下，脚本中的相对路径和 $0 所在目录与任务的工作目录一致
+	dir := task.WorkDir
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, ".shell-task-*"+ext)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("创建脚本文件失败: %w", err)
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+	if _, err := f.WriteString(task.Script); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, nil, fmt.Errorf("写入脚本文件失败: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, nil, fmt.Errorf("写入脚本文件失败: %w", err)
+	}
+	// 以其他用户运行时，对方需要能读到脚本
+	if task.RunAs != nil {
+		if err := os.Chmod(f.Name(), 0o644); err != nil {
+			cleanup()
+			return "", nil, nil, err
+		}
+	}
+
+	// 命令的工作目录就是 dir，这里只需要文件名
+	args = append(append([]string(nil), interp[1:]...), "./"+filepath.Base(f.Name()))
+	return interp[0], args, cleanup, nil
+}
@@ -0,0 +1,268 @@
+package scheduler
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// secretMask 替换秘密值的占位符
+const secretMask = "***"
+
+// minSecretLen 短于这个长度的值不做遮盖，否则日志中大量无关的字符都会被替换
+const minSecretLen = 4
+
+// SecretStore 秘密值的存储
+//
+// 秘密只通过环境变量注入到声明了它的任务（Task.Secrets），
+// 所有日志、任务输出、结果和报告中出现的秘密值都会被替换为 ***，
+// 包括它的 base64（也包括嵌在更长的值中编码的情况）和 URL 编码形式
+type SecretStore struct {
+	mu       sync.RWMutex
+	values   map[string]string
+	replacer *strings.Replacer
+}
+
+// NewSecretStore 创建空的秘密存储
+func NewSecretStore() *SecretStore {
+	return &SecretStore{values: make(map[string]string), replacer: strings.NewReplacer()}
+}
+
+// Set 设置一个秘密
+func (st *SecretStore) Set(name, value string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.values[name] = value
+	st.replacer = buildSecretReplacer(st.values)
+}
+
+// Get 返回秘密的值
+func (st *SecretStore) Get(name string) (string, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	value, ok := st.values[name]
+	return value, ok
+}
+
+// LoadFile 从文件读取秘密，每行一个 NAME=VALUE，忽略空行和 # 开头的注释，
+// 值两侧的引号会被去掉
+func (st *SecretStore) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		slog.Warn("秘密文件可以被其他用户读取，建议 chmod 600", "path", path, "mode", info.Mode().Perm())
+	}
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(strings.TrimPrefix(name, "export "))
+		if !ok || name == "" {
+			return fmt.Errorf("%s:%d: 格式应为 NAME=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		st.Set(name, value)
+	}
+	return scanner.Err()
+}
+
+// LoadEnv 从当前进程的环境变量读取秘密，读取后从进程环境中删除，
+// 避免被没有声明它的任务继承
+func (st *SecretStore) LoadEnv(names ...string) error {
+	var missing []string
+	for _, name := range names {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		st.Set(name, value)
+		os.Unsetenv(name)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("环境变量中没有秘密 %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Mask 把字符串中出现的秘密替换为 ***，st 为 nil 时原样返回
+func (st *SecretStore) Mask(s string) string {
+	if st == nil {
+		return s
+	}
+	st.mu.RLock()
+	r := st.replacer
+	st.mu.RUnlock()
+	return r.Replace(s)
+}
+
+// Writer 返回一个写入前遮盖秘密的 writer
+// slog 的 handler 每条记录只调用一次 Write，所以秘密不会被拆到两次写入中
+func (st *SecretStore) Writer(w io.Writer) io.Writer {
+	return &maskingWriter{store: st, w: w}
+}
+
+type maskingWriter struct {
+	store *SecretStore
+	w     io.Writer
+}
+
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(m.w, m.store.Mask(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// buildSecretReplacer 为所有秘密及其常见编码形式构造替换器
+// 长的排在前面，避免一个秘密恰好是另一个的子串时只替换了一部分
+func buildSecretReplacer(values map[string]string) *strings.Replacer {
+	seen := make(map[string]bool)
+	var forms []string
+	add := func(s string) {
+		if len(s) >= minSecretLen && !seen[s] {
+			seen[s] = true
+			forms = append(forms, s)
+		}
+	}
+	for _, value := range values {
+		variants := []string{value}
+		// 多行的秘密（例如私钥）逐行输出时每一行都要遮盖
+		for _, line := range strings.Split(value, "\n") {
+			variants = append(variants, strings.TrimSpace(line))
+		}
+		for _, v := range variants {
+			add(v)
+			add(base64.StdEncoding.EncodeToString([]byte(v)))
+			add(base64.URLEncoding.EncodeToString([]byte(v)))
+			for _, enc := range []*base64.Encoding{base64.RawStdEncoding, base64.RawURLEncoding} {
+				for offset := range 3 {
+					add(base64Middle(enc, v, offset))
+				}
+			}
+			add(url.QueryEscape(v))
+			add(url.PathEscape(v))
+			add(uriComponentEscape(v))
+			// JSON 日志和 text 日志中带引号的值会被转义
+			if quoted, err := json.Marshal(v); err == nil {
+				add(string(quoted[1 : len(quoted)-1]))
+			}
+			quoted := strconv.Quote(v)
+			add(quoted[1 : len(quoted)-1])
+		}
+	}
+	sort.Slice(forms, func(i, j int) bool { return len(forms[i]) > len(forms[j]) })
+
+	pairs := make([]string, 0, 2*len(forms))
+	for _, f := range forms {
+		pairs = append(pairs, f, secretMask)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// base64Middle 秘密嵌在更长的值中编码时（例如 Basic 认证的 base64(user:token)），
+// 编码结果取决于它前面的字节数除以 3 的余数 offset。这里返回只由秘密本身决定的那一段字符：
+// 去掉开头混有前面字节的字符和末尾混有后面字节的字符，offset 为 0 时也包括没有填充的完整编码
+func base64Middle(enc *base64.Encoding, v string, offset int) string {
+	encoded := enc.EncodeToString(append(make([]byte, offset), v...))
+	start := (8*offset + 5) / 6
+	end := 8 * (offset + len(v)) / 6
+	if offset == 0 && len(v)%3 == 0 {
+		end = len(encoded)
+	}
+	if start >= end {
+		return ""
+	}
+	return encoded[start:end]
+}
+
+// uriComponentEscape 与 JavaScript 的 encodeURIComponent、curl --data-urlencode 等工具一致的编码：
+// 除字母数字和 -_.!~*'() 以外都编码为 %XX，空格编码为 %20
+func uriComponentEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexByte("-_.!~*'()", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// SetSecrets 设置秘密存储，需要在 Start 之前调用
+func (s *Scheduler) SetSecrets(store *SecretStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets = store
+}
+
+// secretEnv 返回任务声明的秘密对应的环境变量
+func (s *Scheduler) secretEnv(task *Task) ([]string, error) {
+	if len(task.Secrets) == 0 {
+		return nil, nil
+	}
+	if s.secrets == nil {
+		return nil, errors.New("任务声明了秘密，但没有配置秘密存储")
+	}
+	env := make([]string, 0, len(task.Secrets))
+	for _, name := range task.Secrets {
+		value, ok := s.secrets.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("秘密 %s 不存在", name)
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
+
+// maskResult 遮盖结果中可能包含秘密的字段，在结果被记录、打印、通知之前调用
+func (s *Scheduler) maskResult(result *TaskResult) {
+	if s.secrets == nil {
+		return
+	}
+	result.Output = s.secrets.Mask(result.Output)
+	for i, w := range result.Warnings {
+		result.Warnings[i] = s.secrets.Mask(w)
+	}
+	if result.Error != nil {
+		if masked := s.secrets.Mask(result.Error.Error()); masked != result.Error.Error() {
+			result.Error = &maskedError{msg: masked, err: result.Error}
+		}
+	}
+}
+
+// maskedError 遮盖了秘密的错误，仍然可以用 errors.Is/As 判断原来的错误
+type maskedError struct {
+	msg string
+	err error
+}
+
+func (e *maskedError) Error() string { return e.msg }
+func (e *maskedError) Unwrap() error { return e.err }
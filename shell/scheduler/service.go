@@ -0,0 +1,322 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// TaskKind 任务类型
+type TaskKind string
+
+const (
+	// KindJob 普通任务：运行到结束，退出码决定成败
+	KindJob TaskKind = ""
+	// KindService 服务任务：长期运行，探针通过后视为就绪并放行依赖它的任务，
+	// 整个运行结束时由调度器统一关闭
+	KindService TaskKind = "service"
+	// KindGate 人工审批节点：不执行命令，等待通过或拒绝，见 Gate
+	KindGate TaskKind = "gate"
+)
+
+// Probe 服务的就绪/健康探针，TCP、HTTP、LogPattern 三选一
+type Probe struct {
+	TCP        string        // 能建立 TCP 连接即视为通过，例如 127.0.0.1:5432
+	HTTP       string        // GET 返回 200 即视为通过
+	LogPattern string        // 输出中出现匹配该正则的行即视为就绪（只用于就绪判断）
+	Interval   time.Duration // 探测间隔，默认 1s
+	Timeout    time.Duration // 等待就绪的最长时间，默认 1m
+
+	HealthInterval time.Duration // 就绪后的健康检查间隔，默认 10s，日志探针不做健康检查
+	HealthFailures int           // 连续失败多少次视为不健康，默认 3
+}
+
+// errServiceUnhealthy 服务在运行过程中健康检查连续失败
+var errServiceUnhealthy = errors.New("服务健康检查失败")
+
+// serviceExit 服务进程的退出信息
+type serviceExit struct {
+	code int
+	err  error
+}
+
+// serviceHandle 一个已就绪、正在后台运行的服务
+type serviceHandle struct {
+	task     *Task
+	rt       *runningTask
+	cancel   context.CancelFunc
+	tornDown bool             // 由调度器主动关闭，受 Scheduler.mu 保护
+	done     chan *TaskResult // 被调度器关闭时，最终结果从这里返回
+}
+
+// check 执行一次 TCP 或 HTTP 探测
+func (p *Probe) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Interval)
+	defer cancel()
+	switch {
+	case p.TCP != "":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", p.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case p.HTTP != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.HTTP, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("HTTP 状态码 %d", resp.StatusCode)
+		}
+		return nil
+	}
+	return errors.New("探针没有配置 TCP 或 HTTP")
+}
+
+// withDefaults 补全探针的默认值
+func (p Probe) withDefaults() Probe {
+	if p.Interval <= 0 {
+		p.Interval = time.Second
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = time.Minute
+	}
+	if p.HealthInterval <= 0 {
+		p.HealthInterval = 10 * time.Second
+	}
+	if p.HealthFailures <= 0 {
+		p.HealthFailures = 3
+	}
+	return p
+}
+
+// startService 启动服务并等待其就绪
+//
+// 就绪后服务转入后台运行，worker 立即被释放，返回 nil；
+// 启动失败或就绪超时则返回失败的结果，由 worker 像普通任务一样上报
+func (s *Scheduler) startService(workerID int, task *Task) *TaskResult {
+	result := &TaskResult{
+		TaskID:    task.ID,
+		TaskName:  task.Name,
+		Status:    StatusRunning,
+		StartTime: s.clock.Now(),
+	}
+	var rt *runningTask
+	logger := s.logger.With(logKeyTaskID, task.ID, logKeyWorker, workerID)
+	fail := func(err error, exitCode int, output *taskOutput) *TaskResult {
+		result.Status = StatusFailed
+		if rt != nil && s.isCancelled(rt) {
+			result.Status = StatusCancelled
+			err = errTaskCancelled
+		}
+		result.Error = err
+		result.ExitCode = exitCode
+		result.EndTime = s.clock.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		result.Output = s.trimOutput(output.String(), task.MaxOutput)
+		return result
+	}
+
+	var probe Probe
+	if task.Probe != nil {
+		probe = task.Probe.withDefaults()
+	}
+	var logPattern *regexp.Regexp
+	if probe.LogPattern != "" {
+		var err error
+		if logPattern, err = regexp.Compile(probe.LogPattern); err != nil {
+			return fail(fmt.Errorf("无效的日志探针: %w", err), -1, newTaskOutput(logger, s.secrets))
+		}
+	}
+
+	executor, err := s.executorFor(task)
+	if err != nil {
+		return fail(err, -1, newTaskOutput(logger, s.secrets))
+	}
+
+	logger.Info("启动服务", "name", task.Name, "cmd", task.Cmd)
+
+	// 日志探针：在输出流中匹配
+	output := newTaskOutput(logger, s.secrets)
+	var logMatched atomic.Bool
+	if logPattern != nil {
+		output.OnLine(func(prefix, line string) {
+			if logPattern.MatchString(line) {
+				logMatched.Store(true)
+			}
+		})
+	}
+
+	// 服务的生命周期不受 Task.Timeout 限制，只在运行结束或健康检查失败时被取消
+	ctx, cancel := context.WithCancel(s.ctx)
+	rt = s.trackRunning(task, output)
+	s.setCancel(rt, cancel)
+	exited := make(chan serviceExit, 1)
+	go func() {
+		stdout, stderr := output.Stream("STDOUT"), output.Stream("STDERR")
+		var code int
+		t, err := s.withRunEnv(task)
+		if err == nil {
+			code, err = executor.Execute(ctx, t, stdout, stderr)
+		}
+		stdout.Flush()
+		stderr.Flush()
+		exited <- serviceExit{code, err}
+	}()
+
+	// 等待就绪
+	ready := func() bool {
+		switch {
+		case task.Probe == nil:
+			return true
+		case logPattern != nil:
+			return logMatched.Load()
+		default:
+			return probe.check(ctx) == nil
+		}
+	}
+	deadline := time.NewTimer(probe.Timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(probe.Interval)
+	defer ticker.Stop()
+	for !ready() {
+		select {
+		case e := <-exited:
+			cancel()
+			if e.err == nil {
+				e.err = errors.New("服务在就绪前退出")
+			}
+			return fail(e.err, e.code, output)
+		case <-deadline.C:
+			cancel()
+			e := <-exited
+			return fail(fmt.Errorf("服务在 %v 内没有就绪", probe.Timeout), e.code, output)
+		case <-ticker.C:
+		}
+	}
+
+	logger.Info("服务已就绪", logKeyDuration, s.clock.Now().Sub(result.StartTime))
+
+	handle := &serviceHandle{task: task, rt: rt, cancel: cancel, done: make(chan *TaskResult, 1)}
+	s.mu.Lock()
+	s.services[task.ID] = handle
+	s.completedTasks[task.ID] = true
+	s.mu.Unlock()
+
+	s.serviceWG.Add(1)
+	go s.superviseService(handle, probe, output, exited, result)
+
+	// 放行依赖此服务的任务；如果其他任务都已经结束，这次就绪也可能意味着整个运行结束
+	s.checkDependentTasks()
+	s.maybeFinishRun()
+	return nil
+}
+
+// superviseService 服务就绪后的健康检查，直到服务退出
+func (s *Scheduler) superviseService(h *serviceHandle, probe Probe, output *taskOutput, exited <-chan serviceExit, result *TaskResult) {
+	defer s.serviceWG.Done()
+	task := h.task
+
+	var healthC <-chan time.Time
+	if task.Probe != nil && probe.LogPattern == "" {
+		ticker := time.NewTicker(probe.HealthInterval)
+		defer ticker.Stop()
+		healthC = ticker.C
+	}
+
+	var exitCode int
+	var err error
+	failures := 0
+wait:
+	for {
+		select {
+		case e := <-exited:
+			exitCode, err = e.code, e.err
+			break wait
+		case <-healthC:
+			if checkErr := probe.check(s.ctx); checkErr != nil {
+				failures++
+				s.logger.Warn("服务健康检查失败", logKeyTaskID, task.ID, "failures", failures, "max_failures", probe.HealthFailures, "error", checkErr)
+				if failures >= probe.HealthFailures {
+					h.cancel()
+					e := <-exited
+					exitCode, err = e.code, fmt.Errorf("%w: %v", errServiceUnhealthy, checkErr)
+					break wait
+				}
+			} else {
+				failures = 0
+			}
+		}
+	}
+	h.cancel()
+
+	result.EndTime = s.clock.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.ExitCode = exitCode
+	result.Output = s.trimOutput(output.String(), task.MaxOutput)
+
+	// 判断是被调度器主动关闭，还是自己退出/不健康
+	s.mu.Lock()
+	tornDown := h.tornDown
+	if !tornDown {
+		delete(s.services, task.ID)
+	}
+	s.mu.Unlock()
+
+	if tornDown {
+		result.Status = StatusSuccess
+		h.done <- result
+		return
+	}
+
+	if err == nil {
+		err = errors.New("服务意外退出")
+	}
+	result.Status = StatusFailed
+	if s.isCancelled(h.rt) {
+		result.Status = StatusCancelled
+		err = errTaskCancelled
+	}
+	result.Error = err
+	s.metrics.taskFinished(result)
+	s.sendResult(result)
+}
+
+// stopServices 关闭所有仍在运行的服务，并记录它们的最终结果
+func (s *Scheduler) stopServices() {
+	s.mu.Lock()
+	handles := make([]*serviceHandle, 0, len(s.services))
+	for id, h := range s.services {
+		h.tornDown = true
+		handles = append(handles, h)
+		delete(s.services, id)
+	}
+	s.mu.Unlock()
+
+	for _, h := range handles {
+		s.logger.Info("关闭服务", logKeyTaskID, h.task.ID)
+		h.cancel()
+	}
+	for _, h := range handles {
+		result := <-h.done
+		s.maskResult(result)
+		s.metrics.taskFinished(result)
+		s.mu.Lock()
+		s.taskResults[result.TaskID] = result
+		delete(s.running, result.TaskID)
+		s.mu.Unlock()
+		s.logResult(result)
+		s.printResult(result)
+	}
+}
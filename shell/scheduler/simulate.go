@@ -0,0 +1,335 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecutorSim 模拟执行器的名称
+const ExecutorSim = "sim"
+
+// SimStep 模拟任务一次尝试的脚本
+type SimStep struct {
+	Duration time.Duration // 虚拟耗时
+	ExitCode int           // 退出码，非 0 时按 SuccessExitCodes 判定
+	Err      error         // 执行错误，例如模拟命令无法启动
+	Output   string        // 写入 stdout 的输出，在耗时开始前写出，可以触发输出判定规则
+}
+
+// SimEvent 模拟过程中的一条记录，用于检查执行顺序
+type SimEvent struct {
+	At      time.Duration // 相对模拟开始的虚拟时间
+	TaskID  string
+	Attempt int    // 第几次执行，从 0 开始
+	Event   string // start、finish、timeout、cancelled
+}
+
+// SimExecutor 按脚本“执行”任务：写出脚本中的输出，在虚拟时间中等待脚本中的耗时，返回脚本中的结果
+// 每次执行使用下一条脚本，用完后重复最后一条；没有脚本的任务立即成功
+type SimExecutor struct {
+	clock    *SimClock
+	start    time.Time
+	mu       sync.Mutex
+	scripts  map[string][]SimStep
+	attempts map[string]int
+	trace    []SimEvent
+}
+
+// Script 设置任务每次执行的脚本
+func (e *SimExecutor) Script(taskID string, steps ...SimStep) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scripts[taskID] = steps
+}
+
+// Trace 返回到目前为止的记录，按虚拟时间排列
+// 同一时刻的记录按任务 ID 排列：多个 worker 同时开始执行时，谁先记录取决于 goroutine 的调度，
+// 按 ID 排列后同样的脚本每次得到同样的时间线
+func (e *SimExecutor) Trace() []SimEvent {
+	e.mu.Lock()
+	trace := slices.Clone(e.trace)
+	e.mu.Unlock()
+	sort.SliceStable(trace, func(i, j int) bool {
+		if trace[i].At != trace[j].At {
+			return trace[i].At < trace[j].At
+		}
+		if trace[i].TaskID != trace[j].TaskID {
+			return trace[i].TaskID < trace[j].TaskID
+		}
+		return trace[i].Attempt < trace[j].Attempt
+	})
+	return trace
+}
+
+func (e *SimExecutor) record(taskID string, attempt int, event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.trace = append(e.trace, SimEvent{At: e.clock.Now().Sub(e.start), TaskID: taskID, Attempt: attempt, Event: event})
+}
+
+func (e *SimExecutor) Execute(ctx context.Context, task *Task, stdout, stderr io.Writer) (int, error) {
+	e.mu.Lock()
+	attempt := e.attempts[task.ID]
+	e.attempts[task.ID]++
+	var step SimStep
+	if steps := e.scripts[task.ID]; len(steps) > 0 {
+		step = steps[min(attempt, len(steps)-1)]
+	}
+	e.mu.Unlock()
+
+	e.record(task.ID, attempt, "start")
+	if step.Output != "" {
+		io.WriteString(stdout, strings.TrimSuffix(step.Output, "\n")+"\n")
+	}
+
+	var err error
+	if task.Kind == KindService {
+		// 服务在后台运行到被关闭为止，不算作模拟器需要等待的工作
+		err = e.serve(ctx, step.Duration)
+	} else {
+		err = e.clock.Sleep(ctx, step.Duration)
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		e.record(task.ID, attempt, "timeout")
+		return -1, err
+	case err != nil:
+		e.record(task.ID, attempt, "cancelled")
+		return -1, err
+	}
+	e.record(task.ID, attempt, "finish")
+	return step.ExitCode, step.Err
+}
+
+// serve 等待服务被关闭，d 大于 0 时服务在 d 之后自己退出
+func (e *SimExecutor) serve(ctx context.Context, d time.Duration) error {
+	exited := make(chan struct{})
+	if d > 0 {
+		stop := e.clock.AfterFunc(d, func() { close(exited) })
+		defer stop()
+	}
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Simulation 在虚拟时间中运行调度器
+//
+// 所有执行器都被替换为 SimExecutor，任务的耗时和结果由脚本决定。
+// 每当调度器空闲——所有进行中的工作都在等待虚拟时间——模拟器就把时钟推进到下一个计时器，
+// 所以几小时的流水线在毫秒内跑完，同样的脚本每次得到同样的执行顺序和时间。
+// 模拟不读写缓存和历史耗时，也不发送通知；带探针的服务仍然按真实时间探测，不适合模拟
+type Simulation struct {
+	Clock    *SimClock
+	Executor *SimExecutor
+	// StallTimeout 调度器持续忙碌（真实时间）超过这么久就认为卡住了，默认 10s
+	StallTimeout time.Duration
+
+	scheduler *Scheduler
+	start     time.Time
+}
+
+// NewSimulation 把调度器切换到从 start 开始的虚拟时间，需要在 Start 之前调用
+func NewSimulation(s *Scheduler, start time.Time) *Simulation {
+	clock := NewSimClock(start)
+	executor := &SimExecutor{
+		clock:    clock,
+		start:    start,
+		scripts:  make(map[string][]SimStep),
+		attempts: make(map[string]int),
+	}
+	s.SetClock(clock)
+	s.mu.Lock()
+	for name := range s.executors {
+		s.executors[name] = executor
+	}
+	s.executors[ExecutorSim] = executor
+	s.cache = nil
+	s.history = nil
+	s.notifiers = nil
+	s.notifyStatePath = ""
+	s.progressEvery = 0
+	s.mu.Unlock()
+	return &Simulation{Clock: clock, Executor: executor, StallTimeout: 10 * time.Second, scheduler: s, start: start}
+}
+
+// Script 设置任务每次执行的脚本，见 SimExecutor
+func (sim *Simulation) Script(taskID string, steps ...SimStep) {
+	sim.Executor.Script(taskID, steps...)
+}
+
+// At 在模拟开始后的虚拟时间 offset 执行 fn，例如取消任务、审批，需要在 Run 之前调用
+func (sim *Simulation) At(offset time.Duration, fn func()) {
+	sim.Clock.AfterFunc(sim.start.Add(offset).Sub(sim.Clock.Now()), fn)
+}
+
+// Run 启动调度器并在虚拟时间中运行到所有任务结束，返回后由调用方 Stop
+// 调度器空闲但没有计时器可以推进（例如审批节点没有超时、也没有人审批）时返回错误
+func (sim *Simulation) Run(ctx context.Context) error {
+	s := sim.scheduler
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
+	busySince := time.Now()
+	for {
+		if !sim.idle() {
+			if time.Since(busySince) > sim.StallTimeout {
+				return fmt.Errorf("模拟停滞：调度器忙碌超过 %v，可能有任务在等待真实时间", sim.StallTimeout)
+			}
+			runtime.Gosched()
+			time.Sleep(50 * time.Microsecond)
+			continue
+		}
+		select {
+		case <-s.Done():
+			return nil
+		default:
+		}
+		if !sim.Clock.AdvanceNext() {
+			return fmt.Errorf("模拟停滞：没有可以推进的计时器，仍未结束的任务: %s", strings.Join(sim.unfinished(), ", "))
+		}
+		busySince = time.Now()
+	}
+}
+
+// simLoad 调度器某一时刻的工作量
+type simLoad struct {
+	sleeping int64 // 在虚拟时间中等待的任务，都占着 worker
+	queued   int64 // 在队列中等待 worker 的任务
+	inflight int64
+}
+
+func (sim *Simulation) load() simLoad {
+	// 先读 sleeper 数：空闲时只有推进时钟才会减少它，读得早只会偏小，不会误判为空闲
+	sleeping := int64(sim.Clock.Sleeping())
+	queued := int64(len(sim.scheduler.taskQueue))
+	return simLoad{sleeping: sleeping, queued: queued, inflight: sim.scheduler.inflight.Load()}
+}
+
+// idle 所有进行中的工作是否都在等待虚拟时间：
+// 除了在 Sleep 中的，其余都是在队列中排队、而 worker 全部在 Sleep 中的任务
+// 前后两次读到的工作量一致才算，避免恰好读到几个计数同时变化的中间状态
+func (sim *Simulation) idle() bool {
+	a := sim.load()
+	runtime.Gosched()
+	if b := sim.load(); a != b {
+		return false
+	}
+	if a.inflight != a.sleeping+a.queued {
+		return false
+	}
+	return a.queued == 0 || a.sleeping >= int64(sim.scheduler.maxWorkers)
+}
+
+// unfinished 还没有结果的任务
+func (sim *Simulation) unfinished() []string {
+	s := sim.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id := range s.tasks {
+		if _, ok := s.taskResults[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// PrintTrace 按时间顺序输出模拟记录
+func (sim *Simulation) PrintTrace(w io.Writer) {
+	fmt.Fprintln(w, "模拟时间线:")
+	for _, e := range sim.Executor.Trace() {
+		fmt.Fprintf(w, "  +%-10v %-20s #%d %s\n", e.At, e.TaskID, e.Attempt, e.Event)
+	}
+}
+
+// simScript --simulate 读取的脚本文件
+//
+//	{
+//	  "tasks":   {"build": [{"duration": "3m", "exit_code": 1}, {"duration": "2m"}]},
+//	  "cancel":  {"deploy": "10m"},
+//	  "approve": {"release": "1h"}
+//	}
+type simScript struct {
+	Tasks   map[string][]simScriptStep `json:"tasks"`
+	Cancel  map[string]string          `json:"cancel"`  // 任务 ID -> 取消的虚拟时间
+	Approve map[string]string          `json:"approve"` // 审批节点 ID -> 通过的虚拟时间
+	Reject  map[string]string          `json:"reject"`  // 审批节点 ID -> 拒绝的虚拟时间
+}
+
+type simScriptStep struct {
+	Duration string `json:"duration"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error"`
+	Output   string `json:"output"`
+}
+
+// LoadScript 从 JSON 文件读取任务脚本和定时操作
+func (sim *Simulation) LoadScript(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var script simScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return fmt.Errorf("解析模拟脚本失败: %w", err)
+	}
+	for id, raw := range script.Tasks {
+		steps := make([]SimStep, 0, len(raw))
+		for i, r := range raw {
+			step := SimStep{ExitCode: r.ExitCode, Output: r.Output}
+			if r.Duration != "" {
+				if step.Duration, err = time.ParseDuration(r.Duration); err != nil {
+					return fmt.Errorf("任务 %s 第 %d 条脚本: %w", id, i+1, err)
+				}
+			}
+			if r.Error != "" {
+				step.Err = errors.New(r.Error)
+			}
+			steps = append(steps, step)
+		}
+		sim.Script(id, steps...)
+	}
+
+	s := sim.scheduler
+	actions := []struct {
+		times map[string]string
+		fn    func(id string) error
+	}{
+		{script.Cancel, s.CancelTask},
+		{script.Approve, func(id string) error { return s.Approve(id, "simulation", "") }},
+		{script.Reject, func(id string) error { return s.Reject(id, "simulation", "") }},
+	}
+	for _, action := range actions {
+		// 同一时刻的操作按任务 ID 的顺序执行
+		for _, id := range slices.Sorted(maps.Keys(action.times)) {
+			at := action.times[id]
+			offset, err := time.ParseDuration(at)
+			if err != nil {
+				return fmt.Errorf("任务 %s 的操作时间: %w", id, err)
+			}
+			fn := action.fn
+			sim.At(offset, func() {
+				if err := fn(id); err != nil {
+					s.logger.Warn("模拟操作失败", logKeyTaskID, id, "error", err)
+				}
+			})
+		}
+	}
+	return nil
+}
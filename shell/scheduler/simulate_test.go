@@ -0,0 +1,259 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimClock(t *testing.T) {
+	c := NewSimClock(simStart)
+	var fired []string
+	c.AfterFunc(2*time.Minute, func() { fired = append(fired, "b") })
+	c.AfterFunc(time.Minute, func() { fired = append(fired, "a") })
+	c.AfterFunc(2*time.Minute, func() { fired = append(fired, "c") })
+	stop := c.AfterFunc(90*time.Second, func() { fired = append(fired, "stopped") })
+	if !stop() {
+		t.Error("取消还没到期的计时器应返回 true")
+	}
+	if stop() {
+		t.Error("重复取消应返回 false")
+	}
+
+	ctx, cancel := c.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+	if d, ok := ctx.Deadline(); !ok || !d.Equal(simStart.Add(3*time.Minute)) {
+		t.Errorf("Deadline() = %v，期望 %v", d, simStart.Add(3*time.Minute))
+	}
+
+	c.Advance(2 * time.Minute)
+	if want := []string{"a", "b", "c"}; !slices.Equal(fired, want) {
+		t.Errorf("执行顺序 = %v，期望 %v（同时到期的按创建顺序）", fired, want)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("还没到期的 ctx 的错误 = %v", ctx.Err())
+	}
+	if !c.AdvanceNext() {
+		t.Fatal("还有 ctx 的计时器")
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("到期的 ctx 的错误 = %v，期望 DeadlineExceeded", ctx.Err())
+	}
+	if got := c.Now(); !got.Equal(simStart.Add(3 * time.Minute)) {
+		t.Errorf("Now() = %v，期望 %v", got, simStart.Add(3*time.Minute))
+	}
+	if c.AdvanceNext() {
+		t.Error("没有计时器时 AdvanceNext 应返回 false")
+	}
+}
+
+func TestSimClockSleep(t *testing.T) {
+	tests := []struct {
+		name    string
+		cancel  bool
+		wantErr error
+	}{
+		{"推进时钟后醒来", false, nil},
+		{"ctx 取消后提前返回", true, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewSimClock(simStart)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- c.Sleep(ctx, time.Hour) }()
+			for c.Sleeping() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			if tt.cancel {
+				cancel()
+			} else {
+				c.AdvanceNext()
+			}
+			if err := <-done; !errors.Is(err, tt.wantErr) {
+				t.Errorf("Sleep() 错误 = %v，期望 %v", err, tt.wantErr)
+			}
+			if n := c.Sleeping(); n != 0 {
+				t.Errorf("Sleeping() = %d，期望 0", n)
+			}
+		})
+	}
+}
+
+// fanOut 多个任务同时就绪、worker 不够的流水线
+func fanOut() ([]*Task, map[string][]SimStep) {
+	tasks := []*Task{{ID: "setup", Cmd: "true"}}
+	scripts := map[string][]SimStep{"setup": {{Duration: time.Minute}}}
+	var all []string
+	for _, id := range []string{"e", "d", "c", "b", "a"} {
+		tasks = append(tasks, &Task{ID: id, Cmd: "true", Dependencies: []string{"setup"}, RetryCount: 1})
+		scripts[id] = []SimStep{{Duration: 10 * time.Minute, ExitCode: len(id) % 2}, {Duration: 5 * time.Minute}}
+		all = append(all, id)
+	}
+	tasks = append(tasks, &Task{ID: "report", Cmd: "true", Dependencies: all})
+	return tasks, scripts
+}
+
+func TestSimulationDeterministic(t *testing.T) {
+	tasks, scripts := fanOut()
+	_, sim := runSimulation(t, 2, tasks, scripts)
+	want := sim.Executor.Trace()
+	if end := want[len(want)-1]; end.TaskID != "report" || end.Event != "finish" {
+		t.Fatalf("最后一条记录 = %+v，期望 report 结束", end)
+	}
+	for range 5 {
+		tasks, scripts := fanOut()
+		_, sim := runSimulation(t, 2, tasks, scripts)
+		if got := sim.Executor.Trace(); !slices.Equal(got, want) {
+			t.Fatalf("同样的脚本得到不同的时间线:\n%v\n%v", got, want)
+		}
+	}
+}
+
+func TestSimulationActions(t *testing.T) {
+	release := func(g *Gate) []*Task {
+		return []*Task{
+			{ID: "build", Cmd: "true", Timeout: time.Hour},
+			{ID: "release", Kind: KindGate, Gate: g, Dependencies: []string{"build"}},
+			{ID: "deploy", Cmd: "true", Dependencies: []string{"release"}},
+		}
+	}
+	scripts := map[string][]SimStep{"build": {{Duration: 10 * time.Minute}}, "deploy": {{Duration: time.Minute}}}
+	tests := []struct {
+		name       string
+		tasks      []*Task
+		scripts    map[string][]SimStep
+		actions    func(sim *Simulation, s *Scheduler)
+		want       map[string]TaskStatus
+		wantStarts map[string]time.Duration
+		wantErr    string
+	}{
+		{
+			name:    "取消运行中的任务",
+			tasks:   []*Task{{ID: "a", Cmd: "true", Timeout: 2 * time.Hour}, {ID: "b", Cmd: "true", Dependencies: []string{"a"}}},
+			scripts: map[string][]SimStep{"a": {{Duration: time.Hour}}},
+			actions: func(sim *Simulation, s *Scheduler) {
+				sim.At(10*time.Minute, func() { s.CancelTask("a") })
+			},
+			want:       map[string]TaskStatus{"a": StatusCancelled, "b": StatusSuccess},
+			wantStarts: map[string]time.Duration{"a": 0, "b": 10 * time.Minute},
+		},
+		{
+			name:    "审批通过后继续",
+			tasks:   release(&Gate{}),
+			scripts: scripts,
+			actions: func(sim *Simulation, s *Scheduler) {
+				sim.At(time.Hour, func() { s.Approve("release", "alice", "") })
+			},
+			want:       map[string]TaskStatus{"release": StatusSuccess, "deploy": StatusSuccess},
+			wantStarts: map[string]time.Duration{"deploy": time.Hour},
+		},
+		{
+			name:    "审批拒绝后取消之后的任务",
+			tasks:   release(&Gate{}),
+			scripts: scripts,
+			actions: func(sim *Simulation, s *Scheduler) {
+				sim.At(time.Hour, func() { s.Reject("release", "alice", "不发布") })
+			},
+			want: map[string]TaskStatus{"release": StatusFailed, "deploy": StatusCancelled},
+		},
+		{
+			name:       "审批超时自动通过",
+			tasks:      release(&Gate{Timeout: 30 * time.Minute, OnTimeout: GateTimeoutApprove}),
+			scripts:    scripts,
+			want:       map[string]TaskStatus{"release": StatusSuccess, "deploy": StatusSuccess},
+			wantStarts: map[string]time.Duration{"deploy": 40 * time.Minute},
+		},
+		{
+			name:    "没有人审批时停滞",
+			tasks:   release(&Gate{}),
+			scripts: scripts,
+			wantErr: "没有可以推进的计时器",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheduler(WithMaxWorkers(2))
+			for _, task := range tt.tasks {
+				if err := s.AddTask(task); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sim := NewSimulation(s, simStart)
+			for id, steps := range tt.scripts {
+				sim.Script(id, steps...)
+			}
+			if tt.actions != nil {
+				tt.actions(sim, s)
+			}
+			err := sim.Run(context.Background())
+			s.Stop()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() 错误 = %v，期望包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() 错误: %v", err)
+			}
+			results := s.GetResults()
+			for id, want := range tt.want {
+				if r := results[id]; r == nil || r.Status != want {
+					t.Errorf("任务 %s 的结果 = %+v，期望状态 %s", id, r, want)
+				}
+			}
+			starts := firstStarts(sim.Executor.Trace())
+			for id, want := range tt.wantStarts {
+				if got, ok := starts[id]; !ok || got != want {
+					t.Errorf("任务 %s 开始于 +%v，期望 +%v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulationLoadScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.json")
+	script := `{
+		"tasks":   {"build": [{"duration": "3m", "exit_code": 1, "output": "flaky"}, {"duration": "2m"}]},
+		"cancel":  {"slow": "1h"},
+		"approve": {"release": "10m"}
+	}`
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestScheduler(WithMaxWorkers(2))
+	s.AddTasks(
+		&Task{ID: "build", Cmd: "true", RetryCount: 1},
+		&Task{ID: "slow", Cmd: "true", Timeout: 48 * time.Hour},
+		&Task{ID: "release", Kind: KindGate, Gate: &Gate{}, Dependencies: []string{"build"}},
+	)
+	sim := NewSimulation(s, simStart)
+	if err := sim.LoadScript(path); err != nil {
+		t.Fatal(err)
+	}
+	sim.Script("slow", SimStep{Duration: 24 * time.Hour})
+	err := sim.Run(context.Background())
+	s.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := s.GetResults()
+	if r := results["build"]; r.Status != StatusSuccess || r.RetryCount != 1 || r.Duration != 5*time.Minute {
+		t.Errorf("build = %s，重试 %d 次，耗时 %v；期望重试一次后成功，耗时 5m", r.Status, r.RetryCount, r.Duration)
+	}
+	if r := results["slow"]; r.Status != StatusCancelled || r.Duration != time.Hour {
+		t.Errorf("slow = %s，耗时 %v；期望在 1h 时被取消", r.Status, r.Duration)
+	}
+	if r := results["release"]; r.Status != StatusSuccess || r.Approval == nil || r.Approval.Approver != "simulation" {
+		t.Errorf("release = %+v，期望被 simulation 审批通过", r)
+	}
+}
@@ -0,0 +1,304 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runningTask 正在执行（或已就绪的服务）任务的实时状态
+// 所有字段都受 Scheduler.mu 保护
+type runningTask struct {
+	startTime time.Time
+	attempt   int
+	output    *taskOutput
+	cancel    context.CancelFunc // 取消当前这次执行
+	cancelled bool               // 是否被手动取消，取消后不再重试
+}
+
+// TaskState 任务在某一时刻的状态快照
+type TaskState struct {
+	ID           string
+	Name         string
+	Kind         TaskKind
+	Status       TaskStatus
+	StartTime    time.Time
+	Elapsed      time.Duration
+	Attempt      int // 当前（或最后一次）是第几次重试，从 0 开始
+	Dependencies []string
+	Depth        int           // 在依赖图中的层级，没有依赖的任务为 0
+	Expected     time.Duration // 历史耗时的中位数，没有历史记录时为 0
+	P95          time.Duration // 历史耗时的 p95，样本不足时为 0
+	Slow         bool          // 正在运行，且已经超过历史 p95
+}
+
+// trackRunning 登记一个开始执行的任务
+func (s *Scheduler) trackRunning(task *Task, output *taskOutput) *runningTask {
+	rt := &runningTask{startTime: s.clock.Now(), output: output}
+	s.mu.Lock()
+	s.running[task.ID] = rt
+	s.mu.Unlock()
+	return rt
+}
+
+// setAttempt 记录当前是第几次尝试
+// 返回 false 表示任务已经被手动取消，不应该再开始新的尝试
+func (s *Scheduler) setAttempt(rt *runningTask, attempt int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt.attempt = attempt
+	return !rt.cancelled
+}
+
+// setCancel 记录取消当前这次执行的方法，如果任务已经被取消则立即调用
+func (s *Scheduler) setCancel(rt *runningTask, cancel context.CancelFunc) {
+	s.mu.Lock()
+	rt.cancel = cancel
+	cancelled := rt.cancelled
+	s.mu.Unlock()
+	if cancelled {
+		cancel()
+	}
+}
+
+// isCancelled 任务是否被手动取消
+func (s *Scheduler) isCancelled(rt *runningTask) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return rt.cancelled
+}
+
+// taskDepths 计算每个任务在依赖图中的层级
+// 调用方需要持有 s.mu
+func (s *Scheduler) taskDepths() map[string]int {
+	depths := make(map[string]int, len(s.tasks))
+	visiting := make(map[string]bool)
+	var depth func(id string) int
+	depth = func(id string) int {
+		if d, ok := depths[id]; ok {
+			return d
+		}
+		task, ok := s.tasks[id]
+		if !ok || visiting[id] {
+			// 依赖不存在或出现环，交给 checkDependencies 报错，这里只要不死循环
+			return 0
+		}
+		visiting[id] = true
+		d := 0
+		for _, dep := range task.Dependencies {
+			d = max(d, depth(dep)+1)
+		}
+		visiting[id] = false
+		depths[id] = d
+		return d
+	}
+	for id := range s.tasks {
+		depth(id)
+	}
+	return depths
+}
+
+// TaskState 返回单个任务的当前状态，Depth 不计算，任务不存在时返回 false
+func (s *Scheduler) TaskState(id string) (TaskState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[id]
+	if !ok {
+		return TaskState{}, false
+	}
+	return s.stateLocked(id, task, s.clock.Now()), true
+}
+
+// stateLocked 计算一个任务的状态，调用方需要持有 s.mu
+func (s *Scheduler) stateLocked(id string, task *Task, now time.Time) TaskState {
+	st := TaskState{
+		ID:           id,
+		Name:         task.Name,
+		Kind:         task.Kind,
+		Status:       StatusPending,
+		Dependencies: task.Dependencies,
+	}
+	if s.history != nil && task.Kind == KindJob {
+		st.Expected = s.history.Median(id)
+		st.P95 = s.history.P95(id)
+	}
+	if result, ok := s.taskResults[id]; ok {
+		st.Status = result.Status
+		st.StartTime = result.StartTime
+		st.Elapsed = result.Duration
+		st.Attempt = result.RetryCount
+	} else if rt, ok := s.running[id]; ok {
+		st.Status = StatusRunning
+		if _, waiting := s.gates[id]; waiting {
+			st.Status = StatusWaitingApproval
+		}
+		st.StartTime = rt.startTime
+		st.Elapsed = now.Sub(rt.startTime)
+		st.Attempt = rt.attempt
+		st.Slow = st.Status == StatusRunning && st.P95 > 0 && st.Elapsed > st.P95
+	} else if w, ok := s.windowWaits[id]; ok {
+		st.Status = StatusWaitingWindow
+		st.StartTime = w.since
+		st.Elapsed = now.Sub(w.since)
+	}
+	return st
+}
+
+// Snapshot 返回所有任务的当前状态，按依赖层级和ID排序
+func (s *Scheduler) Snapshot() []TaskState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	depths := s.taskDepths()
+	now := s.clock.Now()
+	states := make([]TaskState, 0, len(s.tasks))
+	for id, task := range s.tasks {
+		st := s.stateLocked(id, task, now)
+		st.Depth = depths[id]
+		states = append(states, st)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Depth != states[j].Depth {
+			return states[i].Depth < states[j].Depth
+		}
+		return states[i].ID < states[j].ID
+	})
+	return states
+}
+
+// OutputTail 返回任务最近的 n 行输出，运行中的任务返回实时输出
+func (s *Scheduler) OutputTail(id string, n int) []string {
+	s.mu.Lock()
+	var output string
+	if rt, ok := s.running[id]; ok {
+		s.mu.Unlock()
+		output = rt.output.String()
+	} else {
+		if result, ok := s.taskResults[id]; ok {
+			output = result.Output
+		}
+		s.mu.Unlock()
+	}
+
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// CancelTask 手动取消一个任务
+// 正在执行的任务会被终止且不再重试；还没开始的任务直接记为取消
+func (s *Scheduler) CancelTask(id string) error {
+	s.mu.Lock()
+	if !s.isRunning {
+		s.mu.Unlock()
+		return errors.New("调度器没有在运行")
+	}
+	task, ok := s.tasks[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("任务 %s 不存在", id)
+	}
+	if _, ok := s.taskResults[id]; ok {
+		s.mu.Unlock()
+		return fmt.Errorf("任务 %s 已经结束", id)
+	}
+	if rt, ok := s.running[id]; ok {
+		rt.cancelled = true
+		cancel := rt.cancel
+		s.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+		return nil
+	}
+	if _, waiting := s.windowWaits[id]; waiting {
+		// 等待时间窗口的任务没有在队列中，直接记为取消
+		delete(s.windowWaits, id)
+	} else if s.scheduled[id] {
+		// 已经在队列中，worker 取出时会发现它被取消了
+		s.cancelRequested[id] = true
+		s.mu.Unlock()
+		return nil
+	}
+	s.scheduled[id] = true
+	s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.sendResult(&TaskResult{
+		TaskID:    task.ID,
+		TaskName:  task.Name,
+		Status:    StatusCancelled,
+		StartTime: now,
+		EndTime:   now,
+		Error:     fmt.Errorf("%w（开始前）", errTaskCancelled),
+	})
+	return nil
+}
+
+// takeCancelRequest worker 从队列取出任务时检查它是否已被取消
+func (s *Scheduler) takeCancelRequest(task *Task) *TaskResult {
+	s.mu.Lock()
+	requested := s.cancelRequested[task.ID]
+	delete(s.cancelRequested, task.ID)
+	s.mu.Unlock()
+	if !requested {
+		return nil
+	}
+	now := s.clock.Now()
+	return &TaskResult{
+		TaskID:    task.ID,
+		TaskName:  task.Name,
+		Status:    StatusCancelled,
+		StartTime: now,
+		EndTime:   now,
+		Error:     fmt.Errorf("%w（开始前）", errTaskCancelled),
+	}
+}
+
+// RetryTask 重新执行一个已经结束的任务
+func (s *Scheduler) RetryTask(id string) error {
+	s.mu.Lock()
+	if !s.isRunning {
+		s.mu.Unlock()
+		return errors.New("调度器没有在运行")
+	}
+	task, ok := s.tasks[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("任务 %s 不存在", id)
+	}
+	if _, ok := s.taskResults[id]; !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("任务 %s 还没有结束", id)
+	}
+	// 不在允许的时间内时不放入队列，清除旧结果后按策略等待或跳过
+	reason, _ := s.windowBlock(task, s.clock.Now())
+	held := reason != "" && task.Kind != KindPipeline
+	if !held {
+		s.inflight.Add(1)
+		select {
+		case s.taskQueue <- task:
+		default:
+			s.inflight.Add(-1)
+			s.mu.Unlock()
+			return fmt.Errorf("任务队列已满")
+		}
+	}
+	delete(s.taskResults, id)
+	delete(s.completedTasks, id)
+	delete(s.cancelRequested, id)
+	s.scheduled[id] = true
+	if held {
+		s.holdForWindow(task)
+	}
+	s.mu.Unlock()
+	return nil
+}
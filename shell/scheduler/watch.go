@@ -0,0 +1,277 @@
+package scheduler
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WatchOptions 监听模式的参数
+type WatchOptions struct {
+	Debounce     time.Duration // 最后一次变化之后等待多久才重新执行，合并编辑器保存等连续的变化，默认 300ms
+	PollInterval time.Duration // 系统不支持文件事件时，轮询输入的间隔，默认 1s
+}
+
+// SetWatch 开启监听模式，需要在 Start 之前调用
+//
+// 监听模式下调度器在所有任务结束后不会结束运行，而是监听各任务声明的 Inputs：
+// 输入变化后，取消这些任务及依赖它们的任务中正在执行的，再重新执行它们。
+// Linux 上使用 inotify，其他系统或 inotify 不可用时退回轮询
+func (s *Scheduler) SetWatch(opts WatchOptions) {
+	if opts.Debounce <= 0 {
+		opts.Debounce = 300 * time.Millisecond
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	s.watch = &opts
+}
+
+// fileNotifier 文件系统事件的来源，只通知“有变化”，具体哪些任务受影响由输入签名比较得出
+type fileNotifier interface {
+	Add(dir string) error
+	Events() <-chan struct{}
+	Close() error
+}
+
+// watchLoop 监听输入的变化并重新执行受影响的任务，直到调度器停止
+func (s *Scheduler) watchLoop() {
+	defer s.watchWG.Done()
+	opts := s.watch
+
+	notifier, err := newFileNotifier()
+	var events <-chan struct{}
+	var poll <-chan time.Time
+	if err != nil {
+		s.logger.Warn("文件事件不可用，改为轮询输入", "interval", opts.PollInterval, "error", err)
+		ticker := time.NewTicker(opts.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	} else {
+		defer notifier.Close()
+		events = notifier.Events()
+		s.addWatchDirs(notifier)
+	}
+
+	last := s.inputSignatures()
+	s.logger.Info("监听模式：输入变化时重新执行受影响的任务", "tasks", len(last))
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-events:
+		case <-poll:
+		}
+
+		// 等到一段时间内没有新的变化再处理
+		timer := time.NewTimer(opts.Debounce)
+	debounce:
+		for {
+			select {
+			case <-s.ctx.Done():
+				timer.Stop()
+				return
+			case <-events:
+				timer.Reset(opts.Debounce)
+			case <-timer.C:
+				break debounce
+			}
+		}
+
+		current := s.inputSignatures()
+		var changed []string
+		for id, sig := range current {
+			if last[id] != sig {
+				changed = append(changed, id)
+			}
+		}
+		last = current
+		if notifier != nil {
+			// 可能新建了目录
+			s.addWatchDirs(notifier)
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		sort.Strings(changed)
+		s.rerunTasks(changed)
+	}
+}
+
+// inputSignatures 计算每个声明了 Inputs 的任务的输入签名：文件路径、大小和修改时间
+// 只用于发现变化，是否真的需要重新执行仍由缓存的内容指纹决定
+func (s *Scheduler) inputSignatures() map[string]string {
+	s.mu.Lock()
+	tasks := make([]*Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		if len(task.Inputs) > 0 {
+			tasks = append(tasks, s.withWorkspacePaths(task))
+		}
+	}
+	s.mu.Unlock()
+
+	sigs := make(map[string]string, len(tasks))
+	for _, task := range tasks {
+		files, err := expandInputs(task)
+		if err != nil {
+			sigs[task.ID] = "error: " + err.Error()
+			continue
+		}
+		var b strings.Builder
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				fmt.Fprintf(&b, "%s\x00missing\x00", file)
+				continue
+			}
+			fmt.Fprintf(&b, "%s\x00%d\x00%d\x00", file, info.Size(), info.ModTime().UnixNano())
+		}
+		sigs[task.ID] = b.String()
+	}
+	return sigs
+}
+
+// addWatchDirs 监听所有输入所在的目录：glob 中第一个通配符之前的目录，以及其下的全部子目录
+func (s *Scheduler) addWatchDirs(notifier fileNotifier) {
+	s.mu.Lock()
+	var roots []string
+	for _, task := range s.tasks {
+		task = s.withWorkspacePaths(task)
+		for _, pattern := range task.Inputs {
+			roots = append(roots, globBase(resolvePath(task, pattern)))
+		}
+	}
+	s.mu.Unlock()
+
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if err := notifier.Add(path); err != nil {
+				s.logger.Warn("监听目录失败", "dir", path, "error", err)
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}
+}
+
+// globBase 返回 glob 中不含通配符的最长目录前缀，例如 src/*/x.go 返回 src
+// 不含通配符的文件返回其所在目录，目录返回其本身
+func globBase(pattern string) string {
+	dir := pattern
+	for strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	if dir == pattern {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		return filepath.Dir(dir)
+	}
+	return dir
+}
+
+// rerunTasks 重新执行输入发生变化的任务以及直接或间接依赖它们的任务
+// 这些任务中正在执行或已在队列中的先取消，等它们全部停下后再重新调度
+func (s *Scheduler) rerunTasks(changed []string) {
+	s.mu.Lock()
+	affected := make(map[string]bool, len(changed))
+	for _, id := range changed {
+		affected[id] = true
+	}
+	for grown := true; grown; {
+		grown = false
+		for _, task := range s.tasks {
+			if affected[task.ID] {
+				continue
+			}
+			for _, dep := range task.Dependencies {
+				if affected[dep] {
+					affected[task.ID] = true
+					grown = true
+					break
+				}
+			}
+		}
+	}
+	// 还没开始的先占住，避免在等待其他任务停下的过程中被调度
+	held := make(map[string]bool)
+	for id := range affected {
+		if !s.scheduled[id] {
+			s.scheduled[id] = true
+			held[id] = true
+		}
+	}
+	s.mu.Unlock()
+
+	s.logger.Info("输入发生变化，重新执行任务", "changed", changed, "affected", len(affected))
+	for !s.resetForRerun(affected, held) {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// resetForRerun 如果 ids 中的任务都已经停下，清除它们的结果并重新调度，返回 true；
+// 否则请求取消还在执行或排队的任务，返回 false，由调用方稍后再试
+// held 为 rerunTasks 占住的还没开始的任务，它们不需要取消
+func (s *Scheduler) resetForRerun(ids, held map[string]bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settled := true
+	for id := range ids {
+		if _, done := s.taskResults[id]; done || held[id] {
+			continue
+		}
+		settled = false
+		if rt, ok := s.running[id]; ok {
+			if !rt.cancelled {
+				rt.cancelled = true
+				if rt.cancel != nil {
+					rt.cancel()
+				}
+			}
+		} else {
+			// 还在队列中，worker 取出时直接记为取消
+			s.cancelRequested[id] = true
+		}
+	}
+	if !settled {
+		// 被取消的任务陆续上报结果时，不要提示本轮已经结束
+		s.roundDone = true
+		return false
+	}
+
+	for id := range ids {
+		delete(s.taskResults, id)
+		delete(s.completedTasks, id)
+		delete(s.scheduled, id)
+		delete(s.cancelRequested, id)
+	}
+	s.roundDone = false
+	// 依赖都已满足的任务直接放入队列，其余的等依赖完成后由 checkDependentTasks 调度
+	for id := range ids {
+		task := s.tasks[id]
+		ready := true
+		for _, dep := range task.Dependencies {
+			if !s.completedTasks[dep] {
+				ready = false
+				break
+			}
+		}
+		if !ready {
+			continue
+		}
+		s.trySchedule(task)
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package scheduler
+
+import (
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask 关心的事件：内容、属性变化以及文件的新建、删除和移动
+const inotifyMask = unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+	unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF
+
+// inotifyNotifier 基于 inotify 的文件事件
+type inotifyNotifier struct {
+	file   *os.File
+	events chan struct{}
+
+	mu      sync.Mutex
+	watched map[string]bool
+}
+
+// newFileNotifier 创建 inotify 实例
+// 使用非阻塞的 fd 交给 os.File，Close 时读取的协程能立即返回
+func newFileNotifier() (fileNotifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	n := &inotifyNotifier{
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		events:  make(chan struct{}, 1),
+		watched: make(map[string]bool),
+	}
+	go n.read()
+	return n, nil
+}
+
+// Add 监听一个目录，重复添加会被忽略
+func (n *inotifyNotifier) Add(dir string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.watched[dir] {
+		return nil
+	}
+	// 不用 n.file.Fd()：它可能把 fd 改回阻塞模式，Close 时读取的协程就无法返回；
+	// Control 还保证调用期间 fd 不会被并发的 Close 释放
+	raw, err := n.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var addErr error
+	if err := raw.Control(func(fd uintptr) {
+		_, addErr = unix.InotifyAddWatch(int(fd), dir, inotifyMask)
+	}); err != nil {
+		return err
+	}
+	if addErr != nil {
+		return os.NewSyscallError("inotify_add_watch", addErr)
+	}
+	n.watched[dir] = true
+	return nil
+}
+
+func (n *inotifyNotifier) Events() <-chan struct{} {
+	return n.events
+}
+
+func (n *inotifyNotifier) Close() error {
+	return n.file.Close()
+}
+
+// read 读取事件，不解析具体内容，只通知有变化；通知没被取走时合并
+func (n *inotifyNotifier) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		if _, err := n.file.Read(buf); err != nil {
+			return
+		}
+		select {
+		case n.events <- struct{}{}:
+		default:
+		}
+	}
+}
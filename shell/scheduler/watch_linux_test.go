@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestInotifyNotifier(t *testing.T) {
+	fn, err := newFileNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := fn.(*inotifyNotifier)
+	defer n.Close()
+	dir := t.TempDir()
+	if err := n.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Add(dir); err != nil {
+		t.Fatalf("重复添加: %v", err)
+	}
+
+	// Add 之后 fd 仍然是非阻塞的，Close 才能让读取的协程返回
+	raw, err := n.file.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flags int
+	raw.Control(func(fd uintptr) { flags, err = unix.FcntlInt(fd, unix.F_GETFL, 0) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&unix.O_NONBLOCK == 0 {
+		t.Error("Add 把 inotify 的 fd 改成了阻塞模式")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-n.Events():
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有收到文件变化的通知")
+	}
+}
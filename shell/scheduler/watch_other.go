@@ -0,0 +1,10 @@
+//go:build !linux
+
+package scheduler
+
+import "errors"
+
+// newFileNotifier 只在 Linux 上支持 inotify，其他系统由调用方退回轮询
+func newFileNotifier() (fileNotifier, error) {
+	return nil, errors.New("当前系统不支持 inotify")
+}
@@ -0,0 +1,347 @@
+package scheduler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WindowPolicy 就绪的任务不在允许的时间内（或处于冻结中）时的处理方式
+type WindowPolicy string
+
+const (
+	WindowWait WindowPolicy = "wait" // 等到允许的时间再执行（默认），期间状态为等待时间窗口
+	WindowSkip WindowPolicy = "skip" // 直接跳过，状态记为跳过
+)
+
+// TimeWindow 每周重复的允许执行时段，例如工作日 22:00 到次日 06:00
+type TimeWindow struct {
+	Days  []time.Weekday // 时段开始的那天是星期几，为空表示每天
+	Start string         // 开始时间 HH:MM
+	End   string         // 结束时间 HH:MM，不大于 Start 时表示跨过午夜到第二天
+}
+
+// Blackout 禁止执行的时段，例如发布日冻结，Start 和 End 带各自的时区
+type Blackout struct {
+	Start  time.Time
+	End    time.Time
+	Reason string
+}
+
+// ExecutionWindow 任务允许开始执行的时间，可以设置在任务上，也可以设置在流水线上由其中的任务继承
+//
+// 只在任务就绪、准备放入队列时检查：已经开始的任务不会因为时段结束而被中断，
+// 等待中的任务也不占用 worker
+type ExecutionWindow struct {
+	Allowed   []TimeWindow   // 允许执行的时段，满足任意一个即可；为空表示任何时间都允许
+	Blackouts []Blackout     // 禁止执行的时段，优先于 Allowed
+	Location  *time.Location // Allowed 使用的时区，默认为本地时区
+	Policy    WindowPolicy   // 不在允许的时间内时的处理方式，默认 wait
+}
+
+// FailureOutsideWindow 任务不在允许的时间内，按策略被跳过
+const FailureOutsideWindow = "outside_window"
+
+// windowWait 一个等待时间窗口的任务
+type windowWait struct {
+	since  time.Time
+	reason string
+}
+
+// FreezeState 全局冻结的状态，冻结期间所有还没开始的任务都按各自的策略等待或跳过
+type FreezeState struct {
+	Frozen bool      `json:"frozen"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by,omitempty"`
+	Since  time.Time `json:"since,omitzero"`
+}
+
+// validate 检查时段的格式，AddTask 时调用
+func (w *ExecutionWindow) validate() error {
+	if w == nil {
+		return nil
+	}
+	for _, tw := range w.Allowed {
+		if _, err := parseClock(tw.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(tw.End); err != nil {
+			return err
+		}
+	}
+	for _, b := range w.Blackouts {
+		if !b.End.After(b.Start) {
+			return fmt.Errorf("禁止时段 %s 的结束时间不晚于开始时间", b.Reason)
+		}
+	}
+	switch w.Policy {
+	case "", WindowWait, WindowSkip:
+	default:
+		return fmt.Errorf("无效的时间窗口策略 %q", w.Policy)
+	}
+	return nil
+}
+
+// parseClock 解析 HH:MM，返回从零点开始的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("无效的时间 %q，格式应为 HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// location Allowed 使用的时区
+func (w *ExecutionWindow) location() *time.Location {
+	if w.Location != nil {
+		return w.Location
+	}
+	return time.Local
+}
+
+// blocked 判断 now 时能否开始执行，不能时返回原因，以及之后最早可能允许执行的时间（为零表示无法预知）
+// 返回的时间只是下一次需要重新检查的时间，到时仍可能因为其他时段而不允许
+func (w *ExecutionWindow) blocked(now time.Time) (reason string, next time.Time) {
+	if w == nil {
+		return "", time.Time{}
+	}
+	for _, b := range w.Blackouts {
+		if !now.Before(b.Start) && now.Before(b.End) {
+			reason = "处于禁止时段"
+			if b.Reason != "" {
+				reason += ": " + b.Reason
+			}
+			return reason, b.End
+		}
+	}
+	if len(w.Allowed) == 0 {
+		return "", time.Time{}
+	}
+
+	loc := w.location()
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	// 跨午夜的时段从前一天开始；下一次开始最晚在一周之后
+	for offset := -1; offset <= 7; offset++ {
+		day := today.AddDate(0, 0, offset)
+		for _, tw := range w.Allowed {
+			if len(tw.Days) > 0 && !slices.Contains(tw.Days, day.Weekday()) {
+				continue
+			}
+			startMin, _ := parseClock(tw.Start)
+			endMin, _ := parseClock(tw.End)
+			start := time.Date(day.Year(), day.Month(), day.Day(), startMin/60, startMin%60, 0, 0, loc)
+			end := time.Date(day.Year(), day.Month(), day.Day(), endMin/60, endMin%60, 0, 0, loc)
+			if endMin <= startMin {
+				end = end.AddDate(0, 0, 1)
+			}
+			if !now.Before(start) && now.Before(end) {
+				return "", time.Time{}
+			}
+			if start.After(now) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return "不在允许的时间窗口内", next
+}
+
+// windowBlock 判断任务现在能否开始，冻结优先于任务自身的时间窗口，调用方需要持有 s.mu
+func (s *Scheduler) windowBlock(task *Task, now time.Time) (reason string, next time.Time) {
+	if s.freeze.Frozen {
+		reason = "全局冻结中"
+		if s.freeze.Reason != "" {
+			reason += ": " + s.freeze.Reason
+		}
+		return reason, time.Time{}
+	}
+	return task.Window.blocked(now)
+}
+
+// holdForWindow 就绪的任务放入队列之前检查时间窗口，不能开始时按策略登记为等待或直接跳过
+// 返回 true 表示任务已经处理，不要放入队列；调用方需要持有 s.mu
+func (s *Scheduler) holdForWindow(task *Task) bool {
+	if task.Kind == KindPipeline {
+		// 子流水线节点只汇总子任务的状态，子任务已经各自检查过
+		return false
+	}
+	now := s.clock.Now()
+	reason, next := s.windowBlock(task, now)
+	if reason == "" {
+		return false
+	}
+	if task.Window != nil && task.Window.Policy == WindowSkip {
+		s.logger.Warn("任务不在允许的时间内，按策略跳过", logKeyTaskID, task.ID, "reason", reason)
+		result := &TaskResult{
+			TaskID:        task.ID,
+			TaskName:      task.Name,
+			Status:        StatusSkipped,
+			StartTime:     now,
+			EndTime:       now,
+			Error:         errors.New(reason),
+			FailureReason: FailureOutsideWindow,
+		}
+		if !s.isRunning {
+			// 调度器正在停止，结果队列即将关闭
+			return true
+		}
+		// 调用方持有 s.mu，结果处理器也需要它，所以不能在这里同步发送
+		s.inflight.Add(1)
+		s.windowWG.Add(1)
+		go func() {
+			defer s.windowWG.Done()
+			s.taskResultQueue <- result
+		}()
+		return true
+	}
+
+	if w, waiting := s.windowWaits[task.ID]; waiting {
+		w.reason = reason
+	} else {
+		attrs := []any{logKeyTaskID, task.ID, "reason", reason}
+		if !next.IsZero() {
+			attrs = append(attrs, "until", next)
+		}
+		s.logger.Info("任务等待时间窗口", attrs...)
+		s.windowWaits[task.ID] = &windowWait{since: now, reason: reason}
+	}
+	s.armWindowTimer(next)
+	return true
+}
+
+// armWindowTimer 在 at 时重新检查等待中的任务，已有更早的检查时不做任何事
+// 调用方需要持有 s.mu
+func (s *Scheduler) armWindowTimer(at time.Time) {
+	if at.IsZero() || (!s.windowNext.IsZero() && !at.Before(s.windowNext)) {
+		return
+	}
+	if s.windowStop != nil {
+		s.windowStop()
+	}
+	s.windowNext = at
+	s.windowStop = s.clock.AfterFunc(at.Sub(s.clock.Now()), s.recheckWindows)
+}
+
+// recheckWindows 时段变化或解除冻结后，把可以开始的等待中任务放入队列
+func (s *Scheduler) recheckWindows() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windowNext, s.windowStop = time.Time{}, nil
+	if !s.isRunning {
+		return
+	}
+	for _, id := range slices.Sorted(maps.Keys(s.windowWaits)) {
+		task := s.tasks[id]
+		if s.holdForWindow(task) {
+			continue
+		}
+		w := s.windowWaits[id]
+		delete(s.windowWaits, id)
+		s.logger.Info("任务进入允许的时间窗口", logKeyTaskID, id)
+		if !s.trySchedule(task) {
+			// 队列已满，稍后再试
+			w.reason = "任务队列已满"
+			s.windowWaits[id] = w
+			s.armWindowTimer(s.clock.Now().Add(time.Second))
+		}
+	}
+}
+
+// Freeze 开启全局冻结：还没开始的任务按各自的策略等待或跳过，已经在执行的任务不受影响
+func (s *Scheduler) Freeze(reason, by string) {
+	s.mu.Lock()
+	if !s.freeze.Frozen {
+		s.freeze = FreezeState{Frozen: true, Reason: reason, By: by, Since: s.clock.Now()}
+	}
+	s.mu.Unlock()
+	s.logger.Warn("开启全局冻结", "reason", reason, "by", by)
+}
+
+// Unfreeze 解除全局冻结，等待中的任务重新检查各自的时间窗口
+func (s *Scheduler) Unfreeze(by string) {
+	s.mu.Lock()
+	frozen := s.freeze.Frozen
+	s.freeze = FreezeState{}
+	s.mu.Unlock()
+	if frozen {
+		s.logger.Info("解除全局冻结", "by", by)
+		s.recheckWindows()
+	}
+}
+
+// FreezeState 返回当前的冻结状态
+func (s *Scheduler) FreezeState() FreezeState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.freeze
+}
+
+// HandleFreeze 在 mux 上注册只读的冻结 API
+//
+//	GET /freeze  查看冻结状态
+//
+// 开启和解除冻结只能经由控制 socket（freeze / unfreeze 命令），操作人取自 socket 对端的凭据
+func (s *Scheduler) HandleFreeze(mux *http.ServeMux) {
+	mux.HandleFunc("GET /freeze", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.FreezeState())
+	})
+}
+
+// LoadBlackoutCalendar 读取禁止时段日历，每行一个日期或日期范围，后面可以跟原因，# 开头为注释：
+//
+//	2026-10-01..2026-10-07 国庆
+//	2026-11-11 发布日
+//
+// 日期按 loc 时区（为 nil 时为本地时区）解释为整天
+func LoadBlackoutCalendar(path string, loc *time.Location) ([]Blackout, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var blackouts []Blackout
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dates, reason, _ := strings.Cut(line, " ")
+		b, err := dateBlackout(dates, loc)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		b.Reason = strings.TrimSpace(reason)
+		blackouts = append(blackouts, b)
+	}
+	return blackouts, scanner.Err()
+}
+
+// dateBlackout 把 2026-10-01 或 2026-10-01..2026-10-07 解析为按 loc 时区的整天
+func dateBlackout(dates string, loc *time.Location) (Blackout, error) {
+	from, to, isRange := strings.Cut(dates, "..")
+	if !isRange {
+		to = from
+	}
+	start, err := time.ParseInLocation(time.DateOnly, from, loc)
+	if err != nil {
+		return Blackout{}, fmt.Errorf("无效的日期 %q", from)
+	}
+	end, err := time.ParseInLocation(time.DateOnly, to, loc)
+	if err != nil || end.Before(start) {
+		return Blackout{}, fmt.Errorf("无效的日期范围 %q", dates)
+	}
+	return Blackout{Start: start, End: end.AddDate(0, 0, 1)}, nil
+}
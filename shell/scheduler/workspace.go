@@ -0,0 +1,307 @@
+package scheduler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// WorkspaceKeep 运行结束后是否保留临时工作区
+type WorkspaceKeep string
+
+const (
+	KeepOnFailure WorkspaceKeep = "on-failure" // 有任务失败时保留，便于排查（默认）
+	KeepAlways    WorkspaceKeep = "always"
+	KeepNever     WorkspaceKeep = "never"
+)
+
+// workspaceEnv 任务中指向临时工作区的环境变量
+const workspaceEnv = "WORKSPACE"
+
+// Workspace 一次运行的目录：
+//
+//	<Root>/<run_id>/workspace  临时工作区，任务通过 $WORKSPACE 访问，运行结束后按 Keep 清理
+//	<Root>/<run_id>/artifacts  任务声明的产物，按任务 ID 分目录存放，附带 SHA256SUMS，始终保留
+type Workspace struct {
+	RunDir      string
+	Dir         string
+	ArtifactDir string
+	Keep        WorkspaceKeep
+}
+
+// Artifact 收集到的一个产物文件
+type Artifact struct {
+	Path   string `json:"path"`   // 相对于产物目录的路径
+	Source string `json:"source"` // 任务产生的原始路径
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// NewWorkspace 在 root 下为本次运行创建目录
+func NewWorkspace(root, runID string, keep WorkspaceKeep) (*Workspace, error) {
+	switch keep {
+	case "":
+		keep = KeepOnFailure
+	case KeepOnFailure, KeepAlways, KeepNever:
+	default:
+		return nil, fmt.Errorf("无效的工作区保留策略 %q，可选 on-failure、always、never", keep)
+	}
+	runDir, err := filepath.Abs(filepath.Join(root, runID))
+	if err != nil {
+		return nil, err
+	}
+	ws := &Workspace{
+		RunDir:      runDir,
+		Dir:         filepath.Join(runDir, "workspace"),
+		ArtifactDir: filepath.Join(runDir, "artifacts"),
+		Keep:        keep,
+	}
+	for _, dir := range []string{ws.Dir, ws.ArtifactDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("创建运行目录失败: %w", err)
+		}
+	}
+	return ws, nil
+}
+
+// SetWorkspace 为调度器设置运行目录，需要在 Start 之前调用
+func (s *Scheduler) SetWorkspace(ws *Workspace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workspace = ws
+}
+
+// withRunEnv 返回交给执行器的任务：展开路径中的 $WORKSPACE，在环境变量中加上 $WORKSPACE、运行中导出的变量和任务声明的秘密
+// 复制一份而不是修改原任务，避免工作区路径、秘密等进入缓存指纹；导出的变量由 exportedEnv 单独计入指纹
+func (s *Scheduler) withRunEnv(task *Task) (*Task, error) {
+	env := s.exportedEnv()
+	if s.workspace != nil {
+		env = append(env, workspaceEnv+"="+s.workspace.Dir)
+	}
+	secrets, err := s.secretEnv(task)
+	if err != nil {
+		return nil, err
+	}
+	if len(env) == 0 && len(secrets) == 0 {
+		return task, nil
+	}
+	t := *s.withWorkspacePaths(task)
+	// 任务自己设置的同名变量优先，秘密最后设置，不会被覆盖
+	t.Env = append(append(env, task.Env...), secrets...)
+	return &t, nil
+}
+
+// exportedEnv 运行中导出给之后任务的环境变量
+func (s *Scheduler) exportedEnv() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.runEnv)
+}
+
+// setRunEnv 导出一个环境变量给之后开始执行的所有任务，例如源码检出后的 GIT_COMMIT
+func (s *Scheduler) setRunEnv(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runEnv = slices.DeleteFunc(s.runEnv, func(kv string) bool {
+		return strings.HasPrefix(kv, key+"=")
+	})
+	s.runEnv = append(s.runEnv, key+"="+value)
+}
+
+// expandWorkspace 把路径中的 $WORKSPACE 展开为 dir，其余变量保持原样；dir 为空时不展开
+func expandWorkspace(path, dir string) string {
+	return os.Expand(path, func(name string) string {
+		if name == workspaceEnv && dir != "" {
+			return dir
+		}
+		return "${" + name + "}"
+	})
+}
+
+// withWorkspacePaths 返回工作目录、输入、输出和产物中的 $WORKSPACE 都已展开的任务副本，没有工作区时返回原任务
+func (s *Scheduler) withWorkspacePaths(task *Task) *Task {
+	if s.workspace == nil {
+		return task
+	}
+	expandAll := func(paths []string) []string {
+		if paths == nil {
+			return nil
+		}
+		out := make([]string, len(paths))
+		for i, p := range paths {
+			out[i] = expandWorkspace(p, s.workspace.Dir)
+		}
+		return out
+	}
+	t := *task
+	t.WorkDir = expandWorkspace(task.WorkDir, s.workspace.Dir)
+	t.Inputs = expandAll(task.Inputs)
+	t.Outputs = expandAll(task.Outputs)
+	t.Artifacts = expandAll(task.Artifacts)
+	return &t
+}
+
+// inWorkspace 路径是否以 $WORKSPACE 开头
+func inWorkspace(path string) bool {
+	for _, prefix := range []string{"$" + workspaceEnv, "${" + workspaceEnv + "}"} {
+		if rest, ok := strings.CutPrefix(path, prefix); ok && (rest == "" || rest[0] == '/' || rest[0] == filepath.Separator) {
+			return true
+		}
+	}
+	return false
+}
+
+// workspaceDir 临时工作区的路径，没有工作区时为空
+func (s *Scheduler) workspaceDir() string {
+	if s.workspace == nil {
+		return ""
+	}
+	return s.workspace.Dir
+}
+
+// collectArtifacts 把任务声明的产物复制到产物目录并计算校验和，结果记录在 result 中
+// 任务失败时同样收集，失败时的日志、截图往往最有用；没有匹配到文件只记警告
+func (s *Scheduler) collectArtifacts(task *Task, result *TaskResult, logger *slog.Logger) {
+	if s.workspace == nil || len(task.Artifacts) == 0 {
+		return
+	}
+	task = s.withWorkspacePaths(task)
+	base, err := filepath.Abs(resolvePath(task, "."))
+	if err != nil {
+		logger.Warn("收集产物失败", "error", err)
+		return
+	}
+	dst := filepath.Join(s.workspace.ArtifactDir, filepath.FromSlash(task.ID))
+
+	seen := make(map[string]bool)
+	for _, pattern := range task.Artifacts {
+		matches, err := filepath.Glob(resolvePath(task, pattern))
+		if err != nil {
+			logger.Warn("无效的产物模式", "pattern", pattern, "error", err)
+			continue
+		}
+		if len(matches) == 0 {
+			logger.Warn("产物没有匹配到任何文件", "pattern", pattern)
+			continue
+		}
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.Type().IsRegular() || seen[path] {
+					return nil
+				}
+				seen[path] = true
+				artifact, err := s.copyArtifact(path, base, dst)
+				if err != nil {
+					return err
+				}
+				result.Artifacts = append(result.Artifacts, artifact)
+				return nil
+			})
+			if err != nil {
+				logger.Warn("收集产物失败", "path", match, "error", err)
+			}
+		}
+	}
+	if len(result.Artifacts) > 0 {
+		logger.Info("已收集产物", "count", len(result.Artifacts), "dir", dst)
+	}
+}
+
+// copyArtifact 复制一个产物文件，复制的同时计算 SHA256
+// 目标路径保持产物相对于 $WORKSPACE（或任务工作目录）的相对路径，都不在其中时只保留文件名
+func (s *Scheduler) copyArtifact(src, base, dst string) (Artifact, error) {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return Artifact{}, err
+	}
+	rel := filepath.Base(abs)
+	// 工作区通常就在任务工作目录下，先按工作区计算
+	for _, dir := range []string{s.workspace.Dir, base} {
+		if r, err := filepath.Rel(dir, abs); err == nil && !strings.HasPrefix(r, "..") {
+			rel = r
+			break
+		}
+	}
+	target := filepath.Join(dst, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return Artifact{}, err
+	}
+
+	in, err := os.Open(abs)
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return Artifact{}, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, h), in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	path, err := filepath.Rel(s.workspace.ArtifactDir, target)
+	if err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{
+		Path:   filepath.ToSlash(path),
+		Source: src,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// CloseWorkspace 运行结束时调用：在产物目录写入 SHA256SUMS，并按保留策略清理临时工作区
+func (s *Scheduler) CloseWorkspace() error {
+	ws := s.workspace
+	if ws == nil {
+		return nil
+	}
+
+	// 被中断、有任务没有结果的运行也按失败处理
+	results := s.GetResults()
+	s.mu.Lock()
+	failed := len(results) < len(s.tasks)
+	s.mu.Unlock()
+	var artifacts []Artifact
+	for _, result := range results {
+		if !result.Status.Succeeded() && result.Status != StatusSkipped {
+			failed = true
+		}
+		artifacts = append(artifacts, result.Artifacts...)
+	}
+	if len(artifacts) > 0 {
+		sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Path < artifacts[j].Path })
+		var b strings.Builder
+		for _, a := range artifacts {
+			// 与 sha256sum 的输出格式一致，可以直接用 sha256sum -c 校验
+			fmt.Fprintf(&b, "%s  %s\n", a.SHA256, a.Path)
+		}
+		if err := os.WriteFile(filepath.Join(ws.ArtifactDir, "SHA256SUMS"), []byte(b.String()), 0o644); err != nil {
+			return err
+		}
+	}
+
+	if ws.Keep == KeepAlways || (ws.Keep == KeepOnFailure && failed) {
+		s.logger.Info("保留临时工作区", "dir", ws.Dir)
+		return nil
+	}
+	return os.RemoveAll(ws.Dir)
+}
@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkspacePaths(t *testing.T) {
+	root := t.TempDir()
+	cache := NewTaskCache(filepath.Join(root, "cache"))
+	// 工作目录、输入、输出和产物都使用 $WORKSPACE，两次运行的工作区不同但指纹相同
+	run := func(runID string) (*Workspace, map[string]*TaskResult) {
+		t.Helper()
+		ws, err := NewWorkspace(filepath.Join(root, "runs"), runID, KeepAlways)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newTestScheduler(WithMaxWorkers(1), WithWorkspace(ws), WithCache(cache))
+		s.AddTasks(
+			&Task{ID: "setup", Cmd: "sh", Args: []string{"-c", `mkdir -p "$WORKSPACE/src" && echo v1 > "$WORKSPACE/src/in.txt"`}},
+			&Task{
+				ID:           "build",
+				Cmd:          "sh",
+				Args:         []string{"-c", "cp in.txt out.txt"},
+				WorkDir:      "$WORKSPACE/src",
+				Inputs:       []string{"in.txt"},
+				Outputs:      []string{"${WORKSPACE}/src/out.txt"},
+				Artifacts:    []string{"out.txt"},
+				Dependencies: []string{"setup"},
+			},
+		)
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-s.Done():
+		case <-time.After(time.Minute):
+			t.Fatal("运行没有结束")
+		}
+		s.Stop()
+		return ws, s.GetResults()
+	}
+
+	for i, want := range []TaskStatus{StatusSuccess, StatusCached} {
+		ws, results := run(fmt.Sprintf("run-%d", i+1))
+		r := results["build"]
+		if r.Status != want {
+			t.Fatalf("第 %d 次运行 build = %s，期望 %s（错误: %v）\n%s", i+1, r.Status, want, r.Error, r.Output)
+		}
+		if len(r.Artifacts) != 1 || r.Artifacts[0].Path != "build/src/out.txt" {
+			t.Fatalf("第 %d 次运行的产物 = %+v，期望 build/src/out.txt", i+1, r.Artifacts)
+		}
+		if src := r.Artifacts[0].Source; !strings.HasPrefix(src, ws.Dir+string(filepath.Separator)) {
+			t.Errorf("产物来源 %s 不在本次运行的工作区 %s 中", src, ws.Dir)
+		}
+	}
+}
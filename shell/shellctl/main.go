@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zxc7563598/go-lab/shell/scheduler"
+)
+
+// 退出码，脚本可以据此判断远程运行的结果
+const (
+	exitOK        = 0 // 命令成功，运行或任务成功结束
+	exitFailed    = 1 // 运行或任务失败
+	exitUsage     = 2 // 参数错误
+	exitError     = 3 // 无法连接守护进程，或者守护进程拒绝了命令
+	exitCancelled = 4 // 运行或任务被取消
+)
+
+const usage = `用法: shellctl [-socket path] <命令> [参数]
+
+命令:
+  run [-d] <pipeline.yaml>           提交流水线，默认等待运行结束，-d 只输出运行 ID
+  ps [run]                           列出运行；指定运行时列出其中的任务
+  logs [-f] [-n N] [-run ID] <task>  查看任务输出，-f 持续输出直到任务结束
+  wait [run]                         等待运行结束
+  cancel [-run ID] [task]            取消运行，指定任务时只取消该任务
+  retry [-d] [-run ID] [task]        重试任务；不指定任务时重新提交整个运行
+  approve [-run ID] <task>           通过审批节点，-comment 填写审批意见，审批人为执行命令的用户
+  reject [-run ID] <task>            拒绝审批节点，依赖它的任务都会被取消
+  freeze [-reason text]              开启全局冻结，还没开始的任务等待或跳过，对之后提交的运行同样有效
+  unfreeze                           解除全局冻结
+
+不指定运行时使用最近提交的运行。
+`
+
+// client 所有子命令共用的连接信息
+type client struct {
+	ctl scheduler.ControlClient
+	ctx context.Context
+	by  string
+}
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	socket := flag.String("socket", defaultSocket(), "守护进程的控制 socket，也可以用 SHELLCTL_SOCKET 环境变量指定")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	// Ctrl+C 只断开连接，不会取消远程的运行
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c := &client{
+		ctl: scheduler.ControlClient{Path: *socket},
+		ctx: ctx,
+		by:  "shellctl " + os.Getenv("USER"),
+	}
+
+	commands := map[string]func([]string) int{
+		"run":      c.run,
+		"ps":       c.ps,
+		"logs":     c.logs,
+		"wait":     c.wait,
+		"cancel":   c.cancel,
+		"retry":    c.retry,
+		"approve":  c.gate("approve"),
+		"reject":   c.gate("reject"),
+		"freeze":   c.freeze,
+		"unfreeze": c.unfreeze,
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "未知的命令 %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	os.Exit(cmd(flag.Args()[1:]))
+}
+
+// defaultSocket SHELLCTL_SOCKET 优先，否则与 shell daemon 的默认值一致
+func defaultSocket() string {
+	if path := os.Getenv("SHELLCTL_SOCKET"); path != "" {
+		return path
+	}
+	return scheduler.DefaultDaemonSocket()
+}
+
+// fail 输出错误并返回对应的退出码，被 Ctrl+C 中断时不算错误
+func (c *client) fail(err error) int {
+	if c.ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "已断开，远程运行不受影响")
+		return exitCancelled
+	}
+	fmt.Fprintln(os.Stderr, "shellctl:", err)
+	return exitError
+}
+
+func (c *client) run(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	detach := fs.Bool("d", false, "提交后立即返回，只输出运行 ID")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: shellctl run [-d] <pipeline.yaml>")
+		return exitUsage
+	}
+	// 守护进程的工作目录和这里不同，所以发送绝对路径
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return c.fail(err)
+	}
+	return c.submit(scheduler.ControlRequest{Command: "run", Pipeline: path, Follow: !*detach})
+}
+
+func (c *client) retry(args []string) int {
+	fs := flag.NewFlagSet("retry", flag.ExitOnError)
+	detach := fs.Bool("d", false, "不等待重试结束")
+	run := fs.String("run", "", "运行 ID，默认为最近一次运行")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "用法: shellctl retry [-d] [-run ID] [task]")
+		return exitUsage
+	}
+	req := scheduler.ControlRequest{Command: "retry", Run: *run, Task: fs.Arg(0), By: c.by, Follow: !*detach}
+	if req.Task == "" {
+		return c.submit(req)
+	}
+
+	var task scheduler.ControlTask
+	if err := c.ctl.Stream(c.ctx, req, nil, &task); err != nil {
+		return c.fail(err)
+	}
+	if *detach {
+		fmt.Printf("已重新排队任务 %s\n", req.Task)
+		return exitOK
+	}
+	printTask(task)
+	return taskExitCode(task.Status)
+}
+
+// submit 提交运行（run 或重试整个运行），Follow 时输出每个结束的任务并等待运行结束
+func (c *client) submit(req scheduler.ControlRequest) int {
+	var result scheduler.ControlRun
+	if err := c.ctl.Stream(c.ctx, req, printTaskEvent, &result); err != nil {
+		return c.fail(err)
+	}
+	if !req.Follow {
+		fmt.Println(result.ID)
+		return exitOK
+	}
+	return printRunResult(result)
+}
+
+func (c *client) wait(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "用法: shellctl wait [run]")
+		return exitUsage
+	}
+	req := scheduler.ControlRequest{Command: "wait"}
+	if len(args) == 1 {
+		req.Run = args[0]
+	}
+	var result scheduler.ControlRun
+	if err := c.ctl.Stream(c.ctx, req, printTaskEvent, &result); err != nil {
+		return c.fail(err)
+	}
+	return printRunResult(result)
+}
+
+func (c *client) ps(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "用法: shellctl ps [run]")
+		return exitUsage
+	}
+	if len(args) == 1 {
+		var tasks []scheduler.ControlTask
+		if err := c.ctl.Call(scheduler.ControlRequest{Command: "ps", Run: args[0]}, &tasks); err != nil {
+			return c.fail(err)
+		}
+		fmt.Printf("%s %s %s %s %s %s\n", scheduler.PadRight("TASK", 32), scheduler.PadRight("STATUS", 18), scheduler.PadRight("ELAPSED", 10),
+			scheduler.PadRight("USUAL", 10), scheduler.PadRight("ATTEMPT", 8), "DEPENDENCIES")
+		for _, t := range tasks {
+			// USUAL 为历史耗时的中位数，运行时间超过历史 p95 的任务标上 (slow)
+			usual := "-"
+			if t.ExpectedMs > 0 {
+				usual = formatMs(t.ExpectedMs)
+			}
+			status := t.Status
+			if t.Slow {
+				status += " (slow)"
+			}
+			fmt.Printf("%s %s %s %s %s %s\n", scheduler.PadRight(t.ID, 32), scheduler.PadRight(status, 18), scheduler.PadRight(formatMs(t.ElapsedMs), 10),
+				scheduler.PadRight(usual, 10), scheduler.PadRight(fmt.Sprint(t.Attempt), 8), strings.Join(t.Dependencies, ","))
+		}
+		return exitOK
+	}
+
+	var runs []scheduler.ControlRun
+	if err := c.ctl.Call(scheduler.ControlRequest{Command: "ps"}, &runs); err != nil {
+		return c.fail(err)
+	}
+	var freeze scheduler.FreezeState
+	if err := c.ctl.Call(scheduler.ControlRequest{Command: "freeze-status"}, &freeze); err != nil {
+		return c.fail(err)
+	}
+	if freeze.Frozen {
+		printFreeze(freeze)
+		fmt.Println()
+	}
+	fmt.Printf("%s %s %s %s %s %s %s\n", scheduler.PadRight("RUN", 26), scheduler.PadRight("STATUS", 10), scheduler.PadRight("TASKS", 8),
+		scheduler.PadRight("PROGRESS", 9), scheduler.PadRight("ETA", 10), scheduler.PadRight("STARTED", 20), "PIPELINE")
+	for _, r := range runs {
+		tasks := fmt.Sprintf("%d/%d", r.Finished, r.Total)
+		// 没有历史记录时无法估算剩余时间
+		eta := "-"
+		if r.Status == scheduler.RunRunning && r.ETAMs > 0 {
+			eta = (time.Duration(r.ETAMs) * time.Millisecond).Round(time.Second).String()
+		}
+		fmt.Printf("%s %s %s %s %s %s %s\n", scheduler.PadRight(r.ID, 26), scheduler.PadRight(r.Status, 10), scheduler.PadRight(tasks, 8),
+			scheduler.PadRight(fmt.Sprintf("%.0f%%", r.Percent), 9), scheduler.PadRight(eta, 10),
+			scheduler.PadRight(r.StartTime.Local().Format(time.DateTime), 20), r.Pipeline)
+	}
+	return exitOK
+}
+
+func (c *client) logs(args []string) int {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := fs.Bool("f", false, "持续输出直到任务结束，退出码反映任务的结果")
+	lines := fs.Int("n", 0, "只输出最后 N 行，为 0 时输出全部")
+	run := fs.String("run", "", "运行 ID，默认为最近一次运行")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: shellctl logs [-f] [-n N] [-run ID] <task>")
+		return exitUsage
+	}
+	req := scheduler.ControlRequest{Command: "logs", Run: *run, Task: fs.Arg(0), Follow: *follow, Lines: *lines}
+	var task scheduler.ControlTask
+	err := c.ctl.Stream(c.ctx, req, func(data json.RawMessage) error {
+		var line string
+		if err := json.Unmarshal(data, &line); err != nil {
+			return err
+		}
+		fmt.Println(line)
+		return nil
+	}, &task)
+	if err != nil {
+		return c.fail(err)
+	}
+	return taskExitCode(task.Status)
+}
+
+func (c *client) cancel(args []string) int {
+	fs := flag.NewFlagSet("cancel", flag.ExitOnError)
+	run := fs.String("run", "", "运行 ID，默认为最近一次运行")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "用法: shellctl cancel [-run ID] [task]")
+		return exitUsage
+	}
+	req := scheduler.ControlRequest{Command: "cancel", Run: *run, Task: fs.Arg(0), By: c.by}
+	if err := c.ctl.Call(req, nil); err != nil {
+		return c.fail(err)
+	}
+	if req.Task != "" {
+		fmt.Printf("已取消任务 %s\n", req.Task)
+	} else {
+		fmt.Println("已取消运行")
+	}
+	return exitOK
+}
+
+// gate 返回 approve / reject 子命令，审批人由守护进程根据 socket 对端的凭据确定
+func (c *client) gate(action string) func([]string) int {
+	return func(args []string) int {
+		fs := flag.NewFlagSet(action, flag.ExitOnError)
+		run := fs.String("run", "", "运行 ID，默认为最近一次运行")
+		comment := fs.String("comment", "", "审批意见")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "用法: shellctl %s [-run ID] [-comment text] <task>\n", action)
+			return exitUsage
+		}
+		req := scheduler.ControlRequest{Command: action, Run: *run, Task: fs.Arg(0), Comment: *comment}
+		if err := c.ctl.Call(req, nil); err != nil {
+			return c.fail(err)
+		}
+		if action == "approve" {
+			fmt.Printf("已通过任务 %s\n", req.Task)
+		} else {
+			fmt.Printf("已拒绝任务 %s\n", req.Task)
+		}
+		return exitOK
+	}
+}
+
+func (c *client) freeze(args []string) int {
+	fs := flag.NewFlagSet("freeze", flag.ExitOnError)
+	reason := fs.String("reason", "", "冻结的原因，会显示在等待中的任务上")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "用法: shellctl freeze [-reason text]")
+		return exitUsage
+	}
+	var state scheduler.FreezeState
+	if err := c.ctl.Call(scheduler.ControlRequest{Command: "freeze", Reason: *reason}, &state); err != nil {
+		return c.fail(err)
+	}
+	printFreeze(state)
+	return exitOK
+}
+
+func (c *client) unfreeze(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "用法: shellctl unfreeze")
+		return exitUsage
+	}
+	if err := c.ctl.Call(scheduler.ControlRequest{Command: "unfreeze"}, nil); err != nil {
+		return c.fail(err)
+	}
+	fmt.Println("已解除全局冻结")
+	return exitOK
+}
+
+// printFreeze 输出冻结状态
+func printFreeze(f scheduler.FreezeState) {
+	fmt.Printf("全局冻结中，开始于 %s", f.Since.Local().Format(time.DateTime))
+	if f.By != "" {
+		fmt.Printf("（%s）", f.By)
+	}
+	if f.Reason != "" {
+		fmt.Printf(": %s", f.Reason)
+	}
+	fmt.Println()
+}
+
+// printTaskEvent 输出运行过程中结束的任务
+func printTaskEvent(data json.RawMessage) error {
+	var task scheduler.ControlTask
+	if err := json.Unmarshal(data, &task); err != nil {
+		return err
+	}
+	printTask(task)
+	return nil
+}
+
+func printTask(t scheduler.ControlTask) {
+	fmt.Printf("%s %s %s\n", scheduler.PadRight(t.ID, 32), scheduler.PadRight(t.Status, 10), formatMs(t.ElapsedMs))
+}
+
+// printRunResult 输出运行的结果，返回对应的退出码
+func printRunResult(r scheduler.ControlRun) int {
+	fmt.Printf("\n运行 %s: %s，%d 个任务，%d 个失败\n", r.ID, r.Status, r.Total, r.Failed)
+	switch r.Status {
+	case scheduler.RunSuccess:
+		return exitOK
+	case scheduler.RunCancelled:
+		return exitCancelled
+	case scheduler.RunRunning:
+		// 只有断开时才会出现，正常情况下服务端在运行结束后才返回
+		fmt.Fprintln(os.Stderr, "运行还没有结束")
+		return exitError
+	default:
+		return exitFailed
+	}
+}
+
+// taskExitCode 根据任务状态（TaskStatus.Code）决定退出码，还在运行和按时间窗口跳过的任务算成功
+func taskExitCode(status string) int {
+	switch status {
+	case "success", "cached", "warning", "skipped", "pending", "running", "waiting-approval", "waiting-window":
+		return exitOK
+	case "cancelled":
+		return exitCancelled
+	default:
+		return exitFailed
+	}
+}
+
+func formatMs(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).Round(time.Millisecond).String()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/zxc7563598/go-lab/shell/scheduler"
+)
+
+// runSimulation --simulate 模式：按脚本在虚拟时间中运行已添加的任务，输出时间线、汇总和报告
+func runSimulation(s *scheduler.Scheduler, script string, reports reportFlag) {
+	sim := scheduler.NewSimulation(s, time.Now())
+	if err := sim.LoadScript(script); err != nil {
+		fatal("读取模拟脚本失败", err)
+	}
+	err := sim.Run(context.Background())
+	s.Stop()
+	sim.PrintTrace(os.Stdout)
+	s.PrintSummary(os.Stdout)
+	if err != nil {
+		fatal("模拟失败", err)
+	}
+	report := s.BuildReport(s.Name())
+	for _, target := range reports {
+		if err := scheduler.WriteReport(report, target); err != nil {
+			fatal("生成报告失败", err)
+		}
+	}
+}
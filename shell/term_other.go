@@ -0,0 +1,27 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// termState 其他平台不支持终端界面，调用方会退回普通输出
+type termState struct{}
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func makeRaw(f *os.File) (*termState, error) {
+	return nil, errors.New("当前平台不支持终端界面")
+}
+
+func restoreTerm(f *os.File, state *termState) error {
+	return nil
+}
+
+func termSize(f *os.File) (cols, rows int, err error) {
+	return 0, 0, errors.New("当前平台不支持终端界面")
+}
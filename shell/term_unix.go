@@ -0,0 +1,57 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// termState 进入原始模式前的终端设置，用于退出时恢复
+type termState struct {
+	termios unix.Termios
+}
+
+// isTerminal 判断文件是否连接到终端
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlGetTermios)
+	return err == nil
+}
+
+// makeRaw 把终端切换到原始模式：按键立即可读、不回显、Ctrl+C 不再产生信号
+func makeRaw(f *os.File) (*termState, error) {
+	fd := int(f.Fd())
+	termios, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	old := &termState{termios: *termios}
+
+	// 与 cfmakeraw 相同的设置
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, termios); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// restoreTerm 恢复终端设置
+func restoreTerm(f *os.File, state *termState) error {
+	return unix.IoctlSetTermios(int(f.Fd()), ioctlSetTermios, &state.termios)
+}
+
+// termSize 返回终端的列数和行数
+func termSize(f *os.File) (cols, rows int, err error) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/zxc7563598/go-lab/shell/scheduler"
+)
+
+// 终端界面的控制序列
+const (
+	ansiAltScreenOn  = "\x1b[?1049h"
+	ansiAltScreenOff = "\x1b[?1049l"
+	ansiHideCursor   = "\x1b[?25l"
+	ansiShowCursor   = "\x1b[?25h"
+	ansiHome         = "\x1b[H"
+	ansiClearLine    = "\x1b[K"
+	ansiClearBelow   = "\x1b[J"
+	ansiReverse      = "\x1b[7m"
+	ansiReset        = "\x1b[0m"
+)
+
+// dashboardLogLines 日志区域显示的行数
+const dashboardLogLines = 5
+
+// logRing 保存最近的日志行，终端界面模式下代替标准错误作为日志的输出
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+	size  int
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		r.lines = append(r.lines, line)
+	}
+	if len(r.lines) > r.size {
+		r.lines = r.lines[len(r.lines)-r.size:]
+	}
+	return len(p), nil
+}
+
+// tail 返回最近的 n 行
+func (r *logRing) tail(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.lines) > n {
+		return append([]string(nil), r.lines[len(r.lines)-n:]...)
+	}
+	return append([]string(nil), r.lines...)
+}
+
+// dashboard 全屏终端界面：任务列表、选中任务的输出、最近的日志
+//
+// 按键：↑/↓ 或 k/j 选择任务，c 取消，r 重试，a/x 通过/拒绝审批，q 或 Ctrl+C 退出
+type dashboard struct {
+	s         *scheduler.Scheduler
+	in, out   *os.File
+	termState *termState
+	logs      *logRing
+	logOut    *switchWriter
+	started   time.Time
+
+	selected string // 当前选中的任务 ID
+	message  string // 最近一次操作的提示
+}
+
+// newDashboard 接管终端：切换到原始模式和备用屏幕，日志改为写入界面
+// 标准输入或输出不是终端时返回错误，调用方应退回普通输出
+func newDashboard(s *scheduler.Scheduler, logOut *switchWriter) (*dashboard, error) {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return nil, fmt.Errorf("标准输入或输出不是终端")
+	}
+	state, err := makeRaw(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("终端切换到原始模式失败: %w", err)
+	}
+	d := &dashboard{
+		s:         s,
+		in:        os.Stdin,
+		out:       os.Stdout,
+		termState: state,
+		logs:      &logRing{size: 200},
+		logOut:    logOut,
+		started:   time.Now(),
+	}
+	logOut.Set(d.logs)
+	fmt.Fprint(d.out, ansiAltScreenOn+ansiHideCursor)
+	return d, nil
+}
+
+// Close 恢复终端，日志重新输出到标准错误
+func (d *dashboard) Close() {
+	fmt.Fprint(d.out, ansiShowCursor+ansiAltScreenOff)
+	restoreTerm(d.in, d.termState)
+	d.logOut.Set(os.Stderr)
+}
+
+// Run 刷新界面并处理按键，直到用户退出、收到中断信号或运行被中止
+// 运行结束后界面保持显示，等用户看完结果再退出
+func (d *dashboard) Run(interrupt <-chan struct{}) {
+	keys := make(chan string, 16)
+	go d.readKeys(keys)
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	d.render()
+	for {
+		select {
+		case <-interrupt:
+			return
+		case <-d.s.Aborted():
+			return
+		case key := <-keys:
+			if !d.handleKey(key) {
+				return
+			}
+		case <-ticker.C:
+		}
+		d.render()
+	}
+}
+
+// readKeys 读取按键，方向键等转义序列作为一个整体发送
+func (d *dashboard) readKeys(keys chan<- string) {
+	buf := make([]byte, 64)
+	for {
+		n, err := d.in.Read(buf)
+		if err != nil {
+			return
+		}
+		data := buf[:n]
+		for len(data) > 0 {
+			if data[0] == 0x1b && len(data) >= 3 && data[1] == '[' {
+				keys <- string(data[:3])
+				data = data[3:]
+				continue
+			}
+			keys <- string(data[:1])
+			data = data[1:]
+		}
+	}
+}
+
+// handleKey 处理一个按键，返回 false 表示退出
+func (d *dashboard) handleKey(key string) bool {
+	switch key {
+	case "q", "\x03":
+		return false
+	case "k", "\x1b[A":
+		d.move(-1)
+	case "j", "\x1b[B":
+		d.move(1)
+	case "c":
+		if err := d.s.CancelTask(d.selected); err != nil {
+			d.message = err.Error()
+		} else {
+			d.message = fmt.Sprintf("已取消任务 %s", d.selected)
+		}
+	case "r":
+		if err := d.s.RetryTask(d.selected); err != nil {
+			d.message = err.Error()
+		} else {
+			d.message = fmt.Sprintf("已重新提交任务 %s", d.selected)
+		}
+	case "a":
+		if err := d.s.Approve(d.selected, os.Getenv("USER"), "通过终端界面审批"); err != nil {
+			d.message = err.Error()
+		} else {
+			d.message = fmt.Sprintf("已通过任务 %s", d.selected)
+		}
+	case "x":
+		if err := d.s.Reject(d.selected, os.Getenv("USER"), "通过终端界面拒绝"); err != nil {
+			d.message = err.Error()
+		} else {
+			d.message = fmt.Sprintf("已拒绝任务 %s", d.selected)
+		}
+	}
+	return true
+}
+
+// move 上下移动选中的任务
+func (d *dashboard) move(delta int) {
+	states := d.s.Snapshot()
+	if len(states) == 0 {
+		return
+	}
+	i := d.selectedIndex(states)
+	i = min(max(i+delta, 0), len(states)-1)
+	d.selected = states[i].ID
+}
+
+// selectedIndex 选中任务在列表中的位置，没有选中时默认第一个
+func (d *dashboard) selectedIndex(states []scheduler.TaskState) int {
+	for i, st := range states {
+		if st.ID == d.selected {
+			return i
+		}
+	}
+	return 0
+}
+
+// render 重绘整个界面
+func (d *dashboard) render() {
+	cols, rows, err := termSize(d.out)
+	if err != nil || cols <= 0 || rows <= 0 {
+		cols, rows = 80, 24
+	}
+	states := d.s.Snapshot()
+	sel := d.selectedIndex(states)
+	if len(states) > 0 {
+		d.selected = states[sel].ID
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(ansiHome)
+	line := func(s string) {
+		buf.WriteString(s)
+		buf.WriteString(ansiClearLine + "\r\n")
+	}
+
+	// 标题：整体进度和预计剩余时间
+	progress := d.s.Progress()
+	title := fmt.Sprintf("任务调度 %s %3.0f%%  %d/%d 已结束  运行 %v", progress.Bar(20), progress.Percent, progress.Finished, progress.Total, time.Since(d.started).Round(time.Second))
+	select {
+	case <-d.s.Done():
+		title += "  运行结束，按 q 退出"
+	default:
+		if eta := progress.ETA(); eta > 0 {
+			title += fmt.Sprintf("  预计剩余 %v", eta.Round(time.Second))
+		}
+	}
+	line(color.New(color.Bold).Sprint(truncateWidth(title, cols)))
+
+	// 任务列表：按依赖层级缩进，空间不够时让选中的任务保持可见
+	fixed := 1 + 1 + 1 + 1 + dashboardLogLines + 1 // 标题、表头、输出标题、日志标题、日志、底部提示
+	listHeight := min(len(states), max((rows-fixed)/2, 1))
+	offset := 0
+	if sel >= listHeight {
+		offset = sel - listHeight + 1
+	}
+	line(truncateWidth(fmt.Sprintf("  %s %s %s %s  %s", scheduler.PadRight("任务", 32), scheduler.PadRight("状态", 8), scheduler.PadRight("耗时/平时", 12), scheduler.PadRight("重试", 4), "依赖"), cols))
+	for i := offset; i < offset+listHeight && i < len(states); i++ {
+		st := states[i]
+		name := strings.Repeat("  ", st.Depth) + st.Name
+		if st.Kind == scheduler.KindService {
+			name += " [服务]"
+		}
+		// 运行中的任务同时显示历史耗时的中位数，超过 p95 时标出
+		elapsed := ""
+		if !st.StartTime.IsZero() {
+			elapsed = st.Elapsed.Round(100 * time.Millisecond).String()
+		}
+		if st.Status == scheduler.StatusRunning && st.Expected > 0 {
+			elapsed = st.Elapsed.Round(time.Second).String() + "/" + st.Expected.Round(time.Second).String()
+		}
+		if st.Slow {
+			name += " [比平时慢]"
+		}
+		// 先按宽度截断再上色，避免控制字符影响宽度计算
+		prefix := truncateWidth("  "+scheduler.PadRight(truncateWidth(name, 32), 32)+" ", cols)
+		status := scheduler.PadRight(st.Status.String(), 8)
+		rest := fmt.Sprintf(" %s %s  %s", scheduler.PadRight(elapsed, 12), scheduler.PadRight(fmt.Sprint(st.Attempt), 4), strings.Join(st.Dependencies, ", "))
+		rest = truncateWidth(rest, max(cols-scheduler.DisplayWidth(prefix)-scheduler.DisplayWidth(status), 0))
+		if i == sel {
+			line(ansiReverse + prefix + status + rest + ansiReset)
+		} else {
+			line(prefix + st.Status.Color().Sprint(status) + rest)
+		}
+	}
+
+	// 选中任务的输出
+	outputHeight := max(rows-fixed-listHeight, 0)
+	line(color.New(color.Bold).Sprint(truncateWidth(fmt.Sprintf("── 输出: %s ", d.selected)+strings.Repeat("─", cols), cols)))
+	output := d.s.OutputTail(d.selected, outputHeight)
+	for i := 0; i < outputHeight; i++ {
+		if i < len(output) {
+			line(truncateWidth(output[i], cols))
+		} else {
+			line("")
+		}
+	}
+
+	// 最近的日志
+	line(color.New(color.Bold).Sprint(truncateWidth("── 日志 "+strings.Repeat("─", cols), cols)))
+	logs := d.logs.tail(dashboardLogLines)
+	for i := 0; i < dashboardLogLines; i++ {
+		if i < len(logs) {
+			line(truncateWidth(logs[i], cols))
+		} else {
+			line("")
+		}
+	}
+
+	// 底部提示，最后一行不换行，避免屏幕滚动
+	help := "↑/↓ 选择  c 取消  r 重试  a/x 通过/拒绝审批  q 退出"
+	if d.message != "" {
+		help += "  | " + d.message
+	}
+	buf.WriteString(color.New(color.Faint).Sprint(truncateWidth(help, cols)))
+	buf.WriteString(ansiClearLine + ansiClearBelow)
+	d.out.Write(buf.Bytes())
+}
+
+// truncateWidth 按显示宽度截断字符串
+// 制表符替换为空格，其他控制字符（例如任务输出里的颜色序列）替换为 ?，以免打乱界面
+func truncateWidth(s string, width int) string {
+	s = strings.ReplaceAll(s, "\t", "    ")
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '?'
+		}
+		return r
+	}, s)
+	if scheduler.DisplayWidth(s) <= width {
+		return s
+	}
+	w := 0
+	for i, r := range s {
+		rw := scheduler.DisplayWidth(string(r))
+		if w+rw > width {
+			return s[:i]
+		}
+		w += rw
+	}
+	return s
+}